@@ -8,6 +8,8 @@ import (
 	"os"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -56,6 +58,24 @@ func convertToIndonesianDate(dateStr string) (string, error) {
 	return fmt.Sprintf("%s, %d %s %d", hari, tanggalDatetime.Day(), bulan, tahun), nil
 }
 
+// openPeringatan joins the alerts whose foreignField is localField and
+// that are not resolved yet, most urgent first (darurat sorts before
+// waspada).
+func openPeringatan(localField, foreignField string) bson.M {
+	return bson.M{
+		"from": peringatan.Collection,
+		"let":  bson.M{"key": "$" + localField},
+		"pipeline": []bson.M{
+			{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$" + foreignField, "$$key"}},
+				bson.M{"$ne": bson.A{"$status", peringatan.StatusSelesai}},
+			}}}},
+			{"$sort": bson.D{{Key: "tingkat", Value: 1}, {Key: "tanggal", Value: -1}}},
+		},
+		"as": "peringatan",
+	}
+}
+
 func Allsoap(w http.ResponseWriter, r *http.Request) {
 	client, err := connectToDatabase()
 	if err != nil {
@@ -75,14 +95,21 @@ func Allsoap(w http.ResponseWriter, r *http.Request) {
 	pipeline := []bson.M{
 		{
 			"$project": bson.M{
-				"id_pasien":  1,
-				"datetime":   "$tglDatang",
-				"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 10}},
-				"id_layanan": bson.M{"$literal": "KB"},
-				"s":          1,
-				"o":          1,
-				"a":          1,
-				"p":          1,
+				"id_pasien":      1,
+				"datetime":       pasien.DateToString("$tglDatang", pasien.DateTimeFormat),
+				"tanggal":        pasien.DateToString("$tglDatang", "%Y-%m-%d"),
+				"id_layanan":     bson.M{"$literal": "KB"},
+				"s":              1,
+				"o":              1,
+				"a":              1,
+				"p":              1,
+				"vital":          1,
+				"diagnosis":      1,
+				"resep":          1,
+				"penulis":        1,
+				"dibuat_pada":    1,
+				"ditandatangani": 1,
+				"adendum":        1,
 			},
 		},
 		{
@@ -91,14 +118,21 @@ func Allsoap(w http.ResponseWriter, r *http.Request) {
 				"pipeline": []bson.M{
 					{
 						"$project": bson.M{
-							"id_pasien":  1,
-							"datetime":   "$soapAnc.tanggal",
-							"tanggal":    bson.M{"$substr": []interface{}{"$soapAnc.tanggal", 0, 10}},
-							"id_layanan": bson.M{"$literal": "Kehamilan"},
-							"s":          "$soapAnc.s",
-							"o":          "$soapAnc.o",
-							"a":          "$soapAnc.a",
-							"p":          "$soapAnc.p",
+							"id_pasien":      1,
+							"datetime":       pasien.DateToString("$tglDatang", pasien.DateTimeFormat),
+							"tanggal":        pasien.DateToString("$tglDatang", "%Y-%m-%d"),
+							"id_layanan":     bson.M{"$literal": "Kehamilan"},
+							"s":              1,
+							"o":              1,
+							"a":              1,
+							"p":              1,
+							"vital":          1,
+							"diagnosis":      1,
+							"resep":          1,
+							"penulis":        1,
+							"dibuat_pada":    1,
+							"ditandatangani": 1,
+							"adendum":        1,
 						},
 					},
 				},
@@ -110,19 +144,29 @@ func Allsoap(w http.ResponseWriter, r *http.Request) {
 				"pipeline": []bson.M{
 					{
 						"$project": bson.M{
-							"id_pasien":  1,
-							"datetime":   "$tglDatang",
-							"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 10}},
-							"id_layanan": bson.M{"$literal": "Imunisasi"},
-							"s":          1,
-							"o":          1,
-							"a":          1,
-							"p":          1,
+							"id_pasien":      1,
+							"datetime":       pasien.DateToString("$tglDatang", pasien.DateTimeFormat),
+							"tanggal":        pasien.DateToString("$tglDatang", "%Y-%m-%d"),
+							"id_layanan":     bson.M{"$literal": "Imunisasi"},
+							"s":              1,
+							"o":              1,
+							"a":              1,
+							"p":              1,
+							"vital":          1,
+							"diagnosis":      1,
+							"resep":          1,
+							"penulis":        1,
+							"dibuat_pada":    1,
+							"ditandatangani": 1,
+							"adendum":        1,
 						},
 					},
 				},
 			},
 		},
+		{
+			"$lookup": openPeringatan("_id", "id_sumber"),
+		},
 		{
 			"$sort": bson.M{
 				"datetime": 1,
@@ -133,14 +177,22 @@ func Allsoap(w http.ResponseWriter, r *http.Request) {
 				"_id": "$id_pasien",
 				"subRows": bson.M{
 					"$push": bson.M{
-						"id_soap":    "$_id",
-						"datetime":   "$datetime",
-						"tglDatang":  "$tanggal",
-						"id_layanan": "$id_layanan",
-						"s":          "$s",
-						"o":          "$o",
-						"a":          "$a",
-						"p":          "$p",
+						"id_soap":        "$_id",
+						"datetime":       "$datetime",
+						"tglDatang":      "$tanggal",
+						"id_layanan":     "$id_layanan",
+						"s":              "$s",
+						"o":              "$o",
+						"a":              "$a",
+						"p":              "$p",
+						"vital":          "$vital",
+						"diagnosis":      "$diagnosis",
+						"resep":          "$resep",
+						"penulis":        "$penulis",
+						"dibuat_pada":    "$dibuat_pada",
+						"ditandatangani": "$ditandatangani",
+						"adendum":        "$adendum",
+						"peringatan":     "$peringatan",
 					},
 				},
 			},
@@ -156,13 +208,19 @@ func Allsoap(w http.ResponseWriter, r *http.Request) {
 		{
 			"$unwind": "$pasien",
 		},
+		{
+			"$lookup": openPeringatan("_id", "id_pasien"),
+		},
 		{
 			"$project": bson.M{
 				"_id":       0,
 				"id_pasien": "$pasien.id_pasien",
 				"subRows":   1,
-				"noHP":      "$pasien.no_hp",
-				"name":      "$pasien.nama_pasien",
+				"pasien": bson.M{
+					"nama_pasien": "$pasien.nama_pasien",
+					"no_hp":       "$pasien.no_hp",
+				},
+				"peringatan": 1,
 			},
 		},
 	}
@@ -182,6 +240,16 @@ func Allsoap(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for i, result := range results {
+		// The name and phone number are decrypted under the paths they
+		// were encrypted for, then renamed for the frontend.
+		data, _ := result["pasien"].(bson.M)
+		if err := pasien.Decrypt(data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error decrypting pasien", err)
+			return
+		}
+		results[i]["name"] = data["nama_pasien"]
+		results[i]["noHP"] = data["no_hp"]
+		delete(results[i], "pasien")
 		if tanggal, ok := result["tanggal"].(string); ok {
 			indonesianDate, err := convertToIndonesianDate(tanggal)
 			if err != nil {
@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Kazengan/bidan-backend/sesi"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
@@ -78,7 +80,21 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	delete(user, "password")
-	jsonData, err := json.Marshal(map[string]interface{}{"message": "Login successful", "data": user, "statusCode": 200})
+
+	// The token identifies the bidan to the endpoints that record who did
+	// what, such as the SOAP notes.
+	id, _ := user["_id"].(primitive.ObjectID)
+	nama, _ := user["full_name"].(string)
+	role, _ := user["role"].(string)
+	token, err := sesi.Issue(sesi.Bidan{ID: id, Username: username, Nama: nama, Role: role})
+	if err != nil {
+		somethingwentwrong, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+		w.WriteHeader(500)
+		w.Write(somethingwentwrong)
+		return
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{"message": "Login successful", "data": user, "token": token, "statusCode": 200})
 	if err != nil {
 		somethingwentwrong, _ := json.Marshal(map[string]interface{}{"message": "Something went wrong"})
 		w.WriteHeader(500)
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -76,7 +77,7 @@ func Chart(w http.ResponseWriter, r *http.Request) {
 			{
 				"$project": bson.M{
 					"_id":        0,
-					"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+					"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 					"id_layanan": bson.M{"$literal": 0},
 				},
 			},
@@ -87,7 +88,7 @@ func Chart(w http.ResponseWriter, r *http.Request) {
 						{
 							"$project": bson.M{
 								"_id":        0,
-								"tanggal":    bson.M{"$substr": []interface{}{"$soapAnc.tanggal", 0, 7}},
+								"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 								"id_layanan": bson.M{"$literal": 1},
 							},
 						},
@@ -101,7 +102,7 @@ func Chart(w http.ResponseWriter, r *http.Request) {
 						{
 							"$project": bson.M{
 								"_id":        0,
-								"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+								"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 								"id_layanan": bson.M{"$literal": 2},
 							},
 						},
@@ -117,7 +118,7 @@ func Chart(w http.ResponseWriter, r *http.Request) {
 				{
 					"$project": bson.M{
 						"_id":        0,
-						"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+						"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 						"id_layanan": bson.M{"$literal": 0},
 					},
 				},
@@ -127,7 +128,7 @@ func Chart(w http.ResponseWriter, r *http.Request) {
 				{
 					"$project": bson.M{
 						"_id":        0,
-						"tanggal":    bson.M{"$substr": []interface{}{"$soapAnc.tanggal", 0, 7}},
+						"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 						"id_layanan": bson.M{"$literal": 1},
 					},
 				},
@@ -137,7 +138,7 @@ func Chart(w http.ResponseWriter, r *http.Request) {
 				{
 					"$project": bson.M{
 						"_id":        0,
-						"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+						"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 						"id_layanan": bson.M{"$literal": 2},
 					},
 				},
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -83,7 +84,7 @@ func Chartt(w http.ResponseWriter, r *http.Request) {
 		pipeline = []bson.M{
 			{
 				"$project": bson.M{
-					"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+					"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 					"id_layanan": bson.M{"$literal": 0},
 				},
 			},
@@ -93,7 +94,7 @@ func Chartt(w http.ResponseWriter, r *http.Request) {
 					"pipeline": []bson.M{
 						{
 							"$project": bson.M{
-								"tanggal":    bson.M{"$substr": []interface{}{"$soapAnc.tanggal", 0, 7}},
+								"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 								"id_layanan": bson.M{"$literal": 1},
 							},
 						},
@@ -106,7 +107,7 @@ func Chartt(w http.ResponseWriter, r *http.Request) {
 					"pipeline": []bson.M{
 						{
 							"$project": bson.M{
-								"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+								"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 								"id_layanan": bson.M{"$literal": 2},
 							},
 						},
@@ -120,7 +121,7 @@ func Chartt(w http.ResponseWriter, r *http.Request) {
 			pipeline = []bson.M{
 				{
 					"$project": bson.M{
-						"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+						"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 						"id_layanan": bson.M{"$literal": 0},
 					},
 				},
@@ -129,7 +130,7 @@ func Chartt(w http.ResponseWriter, r *http.Request) {
 			pipeline = []bson.M{
 				{
 					"$project": bson.M{
-						"tanggal":    bson.M{"$substr": []interface{}{"$soapAnc.tanggal", 0, 7}},
+						"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 						"id_layanan": bson.M{"$literal": 1},
 					},
 				},
@@ -138,7 +139,7 @@ func Chartt(w http.ResponseWriter, r *http.Request) {
 			pipeline = []bson.M{
 				{
 					"$project": bson.M{
-						"tanggal":    bson.M{"$substr": []interface{}{"$tglDatang", 0, 7}},
+						"tanggal":    pasien.DateToString("$tglDatang", "%Y-%m"),
 						"id_layanan": bson.M{"$literal": 2},
 					},
 				},
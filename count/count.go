@@ -3,12 +3,12 @@ package count
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -68,9 +68,9 @@ func CountHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentYear := time.Now().Year()
-	currentMonth := int(time.Now().Month())
-	strTanggal := fmt.Sprintf("%04d-%02d", currentYear, currentMonth)
+	now := time.Now().In(pasien.Jakarta)
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, pasien.Jakarta)
+	strTanggal := startOfMonth.Format("2006-01")
 
 	var collectionName string
 	var dateField string
@@ -91,7 +91,7 @@ func CountHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	collection := db.Collection(collectionName)
-	filterCriteria := bson.M{dateField: bson.M{"$regex": "^" + strTanggal}}
+	filterCriteria := bson.M{dateField: bson.M{"$gte": startOfMonth, "$lt": startOfMonth.AddDate(0, 1, 0)}}
 	cursor, err := collection.Find(context.Background(), filterCriteria)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error finding documents")
@@ -99,7 +99,7 @@ func CountHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cursor.Close(context.Background())
 
-	var lastUpdate string
+	var lastUpdate interface{}
 	var countData int64
 	for cursor.Next(context.Background()) {
 		countData++
@@ -109,13 +109,11 @@ func CountHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if date, ok := doc[dateField].(string); ok {
-			lastUpdate = date
-		}
+		lastUpdate = pasien.FormatDateTime(doc[dateField])
 	}
 
 	if countData == 0 {
-		lastUpdate = fmt.Sprintf("%s-01T00:00:00Z", strTanggal)
+		lastUpdate = startOfMonth.Format(time.RFC3339)
 	}
 
 	respondWithJSON(w, http.StatusOK, map[string]interface{}{"statusCode": 200, "message": "Success", "jumlah": countData, "lastUpdate": lastUpdate, "strTanggal": strTanggal})
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -67,11 +68,11 @@ func CountHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	collection := db.Collection(collectionName)
-	now := time.Now().UTC()
-	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	now := time.Now().In(pasien.Jakarta)
+	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, pasien.Jakarta)
 	endOfMonth := startOfMonth.AddDate(0, 1, 0)
 
-	filterCriteria := bson.M{dateField: bson.M{"$gte": startOfMonth.Format(time.RFC3339), "$lt": endOfMonth.Format(time.RFC3339)}}
+	filterCriteria := bson.M{dateField: bson.M{"$gte": startOfMonth, "$lt": endOfMonth}}
 	cursor, err := collection.Find(context.Background(), filterCriteria)
 	if err != nil {
 		somethingWentWrong, _ := json.Marshal(map[string]interface{}{"message": "Finding documents went wrong", "statusCode": 400})
@@ -91,15 +92,7 @@ func CountHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var docDate time.Time
-		if date, ok := doc[dateField].(string); ok {
-			docDate, err = time.Parse(time.RFC3339, date)
-			if err != nil {
-				somethingWentWrong, _ := json.Marshal(map[string]interface{}{"message": "Parsing date went wrong", "statusCode": 400})
-				w.Write(somethingWentWrong)
-				return
-			}
-		}
+		docDate, _ := pasien.AsTime(doc[dateField])
 		if docDate.After(lastUpdate) {
 			lastUpdate = docDate
 		}
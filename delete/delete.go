@@ -5,8 +5,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
-	"strconv"
 
+	"github.com/Kazengan/bidan-backend/eliminasi"
+	"github.com/Kazengan/bidan-backend/lab"
+	"github.com/Kazengan/bidan-backend/lampiran"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
+	"github.com/Kazengan/bidan-backend/persetujuan"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -25,7 +30,7 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 	defer client.Disconnect(context.Background())
 
 	idPasienStr := r.URL.Query().Get("id_pasien")
-	idPasienInt, err := strconv.Atoi(idPasienStr)
+	idPasienInt, err := pasien.ParseID(idPasienStr)
 	if err != nil {
 		jsonData, _ := json.Marshal(map[string]string{"message": "Invalid id_pasien"})
 		w.WriteHeader(http.StatusBadRequest)
@@ -82,6 +87,37 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := persetujuan.DeleteForPasien(context.Background(), db, idPasienInt); err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": "Error deleting persetujuan"})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(jsonData)
+		return
+	}
+	if _, err := lampiran.DeleteForPasien(context.Background(), db, idPasienInt); err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": "Error deleting lampiran"})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(jsonData)
+		return
+	}
+	if err := lab.DeleteForPasien(context.Background(), db, idPasienInt); err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": "Error deleting hasil lab"})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(jsonData)
+		return
+	}
+	if err := eliminasi.DeleteForPasien(context.Background(), db, idPasienInt); err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": "Error deleting tindak lanjut eliminasi"})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(jsonData)
+		return
+	}
+	if err := peringatan.DeleteForPasien(context.Background(), db, idPasienInt); err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": "Error deleting peringatan"})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(jsonData)
+		return
+	}
+
 	jsonData, _ := json.Marshal(map[string]string{"message": "Delete successful"})
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonData)
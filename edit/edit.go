@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -40,7 +42,7 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		id_pasien_int, err := strconv.Atoi(id_pasien_str)
+		id_pasien_int, err := pasien.ParseID(id_pasien_str)
 		if err != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "(GET) error converting id_pasien to integer"})
 			w.WriteHeader(http.StatusBadRequest)
@@ -66,9 +68,9 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 
 		db := client.Database("mydb")
 		filterData := bson.M{"id_pasien": id_pasien_int}
-		pasien := db.Collection("pasien").FindOne(context.Background(), filterData)
+		pasienResult := db.Collection("pasien").FindOne(context.Background(), filterData)
 
-		if pasien.Err() != nil {
+		if pasienResult.Err() != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "id_pasien tidak ditemukan"})
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(jsonData)
@@ -76,23 +78,32 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var pasienData bson.M
-		if err := pasien.Decode(&pasienData); err != nil {
+		if err := pasienResult.Decode(&pasienData); err != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "error decoding data"})
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(jsonData)
 			return
 		}
+		if err := pasien.Decrypt(pasienData); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(jsonData)
+			return
+		}
 
 		var returnData bson.M
 		if id_layanan_int == 0 {
 			returnData = bson.M{
 				"generalInformation": bson.M{
+					"nik":               pasienData["nik"],
+					"noRegister":        pasienData["no_register"],
+					"noJkn":             pasienData["no_jkn"],
 					"noFaskes":          pasienData["data_kb"].(bson.M)["no_faskes"],
 					"noSeriKartu":       pasienData["data_kb"].(bson.M)["no_seri_kartu"],
-					"tglDatang":         pasienData["tanggal_register"],
+					"tglDatang":         pasien.FormatDateTime(pasienData["tanggal_register"]),
 					"namaPeserta":       pasienData["nama_pasien"],
-					"tglLahir":          pasienData["tanggal_lahir"],
-					"usia":              pasienData["umur"],
+					"tglLahir":          pasien.FormatDate(pasienData["tanggal_lahir"]),
+					"usia":              pasien.Umur(pasienData, time.Now()),
 					"namaPasangan":      pasienData["nama_pasangan"],
 					"jenisPasangan":     pasienData["jenis_pasangan"],
 					"pendidikanAkhir":   pasienData["pendidikan"],
@@ -110,6 +121,9 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 		} else if id_layanan_int == 1 {
 			returnData = bson.M{
 				"generalInformation": bson.M{
+					"nik":             pasienData["nik"],
+					"noRegister":      pasienData["no_register"],
+					"noJkn":           pasienData["no_jkn"],
 					"agama":           pasienData["data_kehamilan"].(bson.M)["agama"],
 					"pekerjaan":       pasienData["data_kehamilan"].(bson.M)["pekerjaan"],
 					"desa":            pasienData["data_kehamilan"].(bson.M)["desa"],
@@ -118,16 +132,16 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 					"provinsi":        pasienData["data_kehamilan"].(bson.M)["provinsi"],
 					"rtrw":            pasienData["data_kehamilan"].(bson.M)["rtrw"],
 					"noIbu":           pasienData["data_kehamilan"].(bson.M)["no_ibu"],
-					"tanggalRegister": pasienData["tanggal_register"],
+					"tanggalRegister": pasien.FormatDateTime(pasienData["tanggal_register"]),
 					"namaLengkap":     pasienData["nama_pasien"],
-					"tanggalLahir":    pasienData["tanggal_lahir"],
-					"umur":            pasienData["umur"],
+					"tanggalLahir":    pasien.FormatDate(pasienData["tanggal_lahir"]),
+					"umur":            pasien.Umur(pasienData, time.Now()),
 					"namaSuami":       pasienData["nama_pasangan"],
 					"pendidikan":      pasienData["pendidikan"],
 					"alamatDomisili":  pasienData["alamat"],
 				},
 				"kunjunganNifas":                        pasienData["data_kehamilan"].(bson.M)["kunjungan_nifas"],
-				"mendeteksiFaktorResikoDanResikoTinggi": pasienData["data_kehamilan"].(bson.M)["faktor_resiko_resiko_tinggi"],
+				"mendeteksiFaktorResikoDanResikoTinggi": pasienData["data_kehamilan"].(bson.M)["faktor_risiko_dan_risiko_tinggi"],
 				"pemeriksaanPNC":                        pasienData["data_kehamilan"].(bson.M)["pemeriksaan_pnc"],
 				"persalinan":                            pasienData["data_kehamilan"].(bson.M)["persalinan"],
 				"rencanaPersalinan":                     pasienData["data_kehamilan"].(bson.M)["rencana_persalinan"],
@@ -139,22 +153,29 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 		} else if id_layanan_int == 2 {
 			returnData = bson.M{
 				"generalInformation": bson.M{
-					"nomorBayi": pasienData["nomor_bayi"],
-					"tglDatang": pasienData["tanggal_register"],
-					"nomor":     pasienData["nomor"],
-					"namaBayi":  pasienData["nama_pasien"],
-					"namaAyah":  pasienData["nama_ayah"],
-					"usiaAyah":  pasienData["umur_ayah"],
-					"namaIbu":   pasienData["nama_ibu"],
-					"usiaIbu":   pasienData["umur_ibu"],
-					"puskesmas": pasienData["puskesmas"],
-					"bidan":     pasienData["bidan"],
-					"alamat":    pasienData["alamat"],
-					"desa":      pasienData["desa"],
-					"kecamatan": pasienData["kecamatan"],
-					"kabupaten": pasienData["kabupaten"],
-					"provinsi":  pasienData["provinsi"],
-					"noHP":      pasienData["no_hp"],
+					"nik":          pasienData["nik"],
+					"noRegister":   pasienData["no_register"],
+					"noJkn":        pasienData["no_jkn"],
+					"nomorBayi":    pasienData["nomor_bayi"],
+					"tglDatang":    pasien.FormatDateTime(pasienData["tanggal_register"]),
+					"nomor":        pasienData["nomor"],
+					"namaBayi":     pasienData["nama_pasien"],
+					"tglLahir":     pasien.FormatDate(pasienData["tanggal_lahir"]),
+					"usia":         pasien.Umur(pasienData, time.Now()),
+					"namaAyah":     pasienData["nama_ayah"],
+					"usiaAyah":     pasien.UmurOrangTua(pasienData, "ayah", time.Now()),
+					"tglLahirAyah": pasien.FormatDate(pasienData["tanggal_lahir_ayah"]),
+					"namaIbu":      pasienData["nama_ibu"],
+					"usiaIbu":      pasien.UmurOrangTua(pasienData, "ibu", time.Now()),
+					"tglLahirIbu":  pasien.FormatDate(pasienData["tanggal_lahir_ibu"]),
+					"puskesmas":    pasienData["puskesmas"],
+					"bidan":        pasienData["bidan"],
+					"alamat":       pasienData["alamat"],
+					"desa":         pasienData["desa"],
+					"kecamatan":    pasienData["kecamatan"],
+					"kabupaten":    pasienData["kabupaten"],
+					"provinsi":     pasienData["provinsi"],
+					"noHP":         pasienData["no_hp"],
 				},
 				"detailBayi":                  pasienData["data_imunisasi"].(bson.M)["detail_bayi"],
 				"pemeriksaanNeonatus":         pasienData["data_imunisasi"].(bson.M)["pemeriksaan_neonatus"],
@@ -163,7 +184,8 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": returnData})
+		w.Header().Set("ETag", pasien.ETag(pasien.Version(pasienData)))
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": returnData, "version": pasien.Version(pasienData)})
 		w.WriteHeader(http.StatusOK)
 		w.Write(jsonData)
 		return
@@ -187,7 +209,7 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		id_pasien_int, err := strconv.Atoi(id_pasien_str)
+		id_pasien_int, err := pasien.ParseID(id_pasien_str)
 		if err != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "(POST) error converting id_pasien to integer"})
 			w.WriteHeader(http.StatusBadRequest)
@@ -202,6 +224,12 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 			w.Write(jsonData)
 			return
 		}
+		if _, known := pasien.LayananField[int(id_layanan_int)]; !known || id_layanan_int != float64(int(id_layanan_int)) {
+			jsonData, _ := json.Marshal(map[string]string{"message": "(POST) error id_layanan must be 0, 1 or 2"})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
 
 		data, ok := dataMap["data"].(map[string]interface{})
 		if !ok || len(data) == 0 {
@@ -213,6 +241,11 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 
 		db := client.Database("mydb")
 		targetPasien := bson.M{"id_pasien": id_pasien_int}
+		version, err := pasien.IfMatch(r)
+		if err != nil {
+			pasien.WriteUpdateError(w, err)
+			return
+		}
 		var dataPasien bson.M
 
 		if id_layanan_int == 0 {
@@ -248,42 +281,45 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 				"alamat":           data["generalInformation"].(map[string]interface{})["alamatDomisili"],
 				"no_hp":            data["section2"].(map[string]interface{})["noTelp"],
 				"data_kehamilan": bson.M{
-					"pekerjaan":                   data["generalInformation"].(map[string]interface{})["pekerjaan"],
-					"agama":                       data["generalInformation"].(map[string]interface{})["agama"],
-					"desa":                        data["generalInformation"].(map[string]interface{})["desa"],
-					"kabupaten":                   data["generalInformation"].(map[string]interface{})["kabupaten"],
-					"kecamatan":                   data["generalInformation"].(map[string]interface{})["kecamatan"],
-					"provinsi":                    data["generalInformation"].(map[string]interface{})["provinsi"],
-					"rtrw":                        data["generalInformation"].(map[string]interface{})["rtrw"],
-					"no_ibu":                      data["generalInformation"].(map[string]interface{})["noIbu"],
-					"kunjungan_nifas":             data["kunjunganNifas"],
-					"faktor_resiko_resiko_tinggi": data["mendeteksiFaktorResikoDanResikoTinggi"],
-					"pemeriksaan_pnc":             data["pemeriksaanPNC"],
-					"persalinan":                  data["persalinan"],
-					"rencana_persalinan":          data["rencanaPersalinan"],
-					"riwayat_kehamilan":           data["riwayatKehamilan"],
-					"skrining_tt":                 data["skriningTT"],
-					"section2":                    data["section2"],
+					"pekerjaan":                       data["generalInformation"].(map[string]interface{})["pekerjaan"],
+					"agama":                           data["generalInformation"].(map[string]interface{})["agama"],
+					"desa":                            data["generalInformation"].(map[string]interface{})["desa"],
+					"kabupaten":                       data["generalInformation"].(map[string]interface{})["kabupaten"],
+					"kecamatan":                       data["generalInformation"].(map[string]interface{})["kecamatan"],
+					"provinsi":                        data["generalInformation"].(map[string]interface{})["provinsi"],
+					"rtrw":                            data["generalInformation"].(map[string]interface{})["rtrw"],
+					"no_ibu":                          data["generalInformation"].(map[string]interface{})["noIbu"],
+					"kunjungan_nifas":                 data["kunjunganNifas"],
+					"faktor_risiko_dan_risiko_tinggi": data["mendeteksiFaktorResikoDanResikoTinggi"],
+					"pemeriksaan_pnc":                 data["pemeriksaanPNC"],
+					"persalinan":                      data["persalinan"],
+					"rencana_persalinan":              data["rencanaPersalinan"],
+					"riwayat_kehamilan":               data["riwayatKehamilan"],
+					"skrining_tt":                     data["skriningTT"],
+					"section2":                        data["section2"],
 				},
 			}
 		} else if id_layanan_int == 2 {
 			dataPasien = bson.M{
-				"nomor_bayi":       data["generalInformation"].(map[string]interface{})["nomorBayi"],
-				"tanggal_register": data["generalInformation"].(map[string]interface{})["tglDatang"],
-				"nomor":            data["generalInformation"].(map[string]interface{})["nomor"],
-				"nama_pasien":      data["generalInformation"].(map[string]interface{})["namaBayi"],
-				"nama_ayah":        data["generalInformation"].(map[string]interface{})["namaAyah"],
-				"umur_ayah":        data["generalInformation"].(map[string]interface{})["usiaAyah"],
-				"nama_ibu":         data["generalInformation"].(map[string]interface{})["namaIbu"],
-				"umur_ibu":         data["generalInformation"].(map[string]interface{})["usiaIbu"],
-				"puskesmas":        data["generalInformation"].(map[string]interface{})["puskesmas"],
-				"bidan":            data["generalInformation"].(map[string]interface{})["bidan"],
-				"alamat":           data["generalInformation"].(map[string]interface{})["alamat"],
-				"desa":             data["generalInformation"].(map[string]interface{})["desa"],
-				"kecamatan":        data["generalInformation"].(map[string]interface{})["kecamatan"],
-				"kabupaten":        data["generalInformation"].(map[string]interface{})["kabupaten"],
-				"provinsi":         data["generalInformation"].(map[string]interface{})["provinsi"],
-				"no_hp":            data["generalInformation"].(map[string]interface{})["noHP"],
+				"nomor_bayi":         data["generalInformation"].(map[string]interface{})["nomorBayi"],
+				"tanggal_register":   data["generalInformation"].(map[string]interface{})["tglDatang"],
+				"nomor":              data["generalInformation"].(map[string]interface{})["nomor"],
+				"nama_pasien":        data["generalInformation"].(map[string]interface{})["namaBayi"],
+				"tanggal_lahir":      data["generalInformation"].(map[string]interface{})["tglLahir"],
+				"nama_ayah":          data["generalInformation"].(map[string]interface{})["namaAyah"],
+				"umur_ayah":          data["generalInformation"].(map[string]interface{})["usiaAyah"],
+				"tanggal_lahir_ayah": data["generalInformation"].(map[string]interface{})["tglLahirAyah"],
+				"nama_ibu":           data["generalInformation"].(map[string]interface{})["namaIbu"],
+				"umur_ibu":           data["generalInformation"].(map[string]interface{})["usiaIbu"],
+				"tanggal_lahir_ibu":  data["generalInformation"].(map[string]interface{})["tglLahirIbu"],
+				"puskesmas":          data["generalInformation"].(map[string]interface{})["puskesmas"],
+				"bidan":              data["generalInformation"].(map[string]interface{})["bidan"],
+				"alamat":             data["generalInformation"].(map[string]interface{})["alamat"],
+				"desa":               data["generalInformation"].(map[string]interface{})["desa"],
+				"kecamatan":          data["generalInformation"].(map[string]interface{})["kecamatan"],
+				"kabupaten":          data["generalInformation"].(map[string]interface{})["kabupaten"],
+				"provinsi":           data["generalInformation"].(map[string]interface{})["provinsi"],
+				"no_hp":              data["generalInformation"].(map[string]interface{})["noHP"],
 				"data_imunisasi": bson.M{
 					"detail_bayi":                   data["detailBayi"],
 					"pemeriksaan_neonatus":          data["pemeriksaanNeonatus"],
@@ -293,12 +329,46 @@ func Edit(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		if _, err := db.Collection("pasien").UpdateOne(context.Background(), targetPasien, bson.M{"$set": dataPasien}); err != nil {
-			jsonData, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("error updating data for id_pasien=%d", id_pasien_int)})
-			w.WriteHeader(http.StatusInternalServerError)
+		general, _ := data["generalInformation"].(map[string]interface{})
+		identity, err := pasien.IdentityFields(general)
+		if err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write(jsonData)
 			return
 		}
+		if err := pasien.CheckNIKUnique(context.Background(), db.Collection("pasien"), identity["nik"], id_pasien_int); err != nil {
+			status := http.StatusInternalServerError
+			if err == pasien.ErrNIKExists {
+				status = http.StatusConflict
+			}
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(status)
+			w.Write(jsonData)
+			return
+		}
+		for key, value := range identity {
+			dataPasien[key] = value
+		}
+		if err := pasien.NormalizeDates(dataPasien); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
+		if err := pasien.NormalizePhones(dataPasien); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
+
+		updated, err := pasien.UpdateVersioned(context.Background(), db.Collection("pasien"), targetPasien, version, bson.M{"$set": dataPasien})
+		if err != nil {
+			pasien.WriteUpdateError(w, err)
+			return
+		}
+		w.Header().Set("ETag", pasien.ETag(pasien.Version(updated)))
 
 		jsonData, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("success updating data for id_pasien=%d", id_pasien_int)})
 		w.WriteHeader(http.StatusOK)
@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
+	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -73,7 +74,7 @@ func EditImunisasi(w http.ResponseWriter, r *http.Request) {
 		id_pasien_str = id_pasien
 	}
 
-	id_pasien, err := strconv.Atoi(id_pasien_str)
+	id_pasien, err := pasien.ParseID(id_pasien_str)
 	if err != nil {
 		jsonData, _ := json.Marshal(map[string]string{"message": "invalid id_pasien"})
 		w.WriteHeader(http.StatusBadRequest)
@@ -84,9 +85,9 @@ func EditImunisasi(w http.ResponseWriter, r *http.Request) {
 	data, ok := dataMap["data"].(map[string]interface{})
 	if !ok || len(data) == 0 {
 		filterData := bson.M{"id_pasien": id_pasien}
-		pasien := db.Collection("pasien").FindOne(context.Background(), filterData)
+		pasienResult := db.Collection("pasien").FindOne(context.Background(), filterData)
 
-		if pasien.Err() != nil {
+		if pasienResult.Err() != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "id_pasien tidak ditemukan"})
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(jsonData)
@@ -94,29 +95,42 @@ func EditImunisasi(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var pasienData bson.M
-		if err := pasien.Decode(&pasienData); err != nil {
+		if err := pasienResult.Decode(&pasienData); err != nil {
 			jsonData, _ := json.Marshal(map[string]interface{}{"message": "error decoding data", "error": err.Error()})
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(jsonData)
 			return
 		}
+		if err := pasien.Decrypt(pasienData); err != nil {
+			jsonData, _ := json.Marshal(map[string]interface{}{"message": "error decrypting data", "error": err.Error()})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(jsonData)
+			return
+		}
 
 		returnData := bson.M{
 			"generalInformation": bson.M{
-				"nomorBayi": pasienData["nomor_bayi"],
-				"nomor":     pasienData["nomor"],
-				"namaBayi":  pasienData["nama_pasien"],
-				"namaAyah":  pasienData["nama_ayah"],
-				"usiaAyah":  pasienData["umur_ayah"],
-				"namaIbu":   pasienData["nama_ibu"],
-				"usiaIbu":   pasienData["umur_ibu"],
-				"puskesmas": pasienData["puskesmas"],
-				"bidan":     pasienData["bidan"],
-				"alamat":    pasienData["alamat"],
-				"desa":      pasienData["desa"],
-				"kecamatan": pasienData["kecamatan"],
-				"kabupaten": pasienData["kabupaten"],
-				"provinsi":  pasienData["provinsi"],
+				"nik":          pasienData["nik"],
+				"noRegister":   pasienData["no_register"],
+				"noJkn":        pasienData["no_jkn"],
+				"nomorBayi":    pasienData["nomor_bayi"],
+				"nomor":        pasienData["nomor"],
+				"namaBayi":     pasienData["nama_pasien"],
+				"tglLahir":     pasien.FormatDate(pasienData["tanggal_lahir"]),
+				"usia":         pasien.Umur(pasienData, time.Now()),
+				"namaAyah":     pasienData["nama_ayah"],
+				"usiaAyah":     pasien.UmurOrangTua(pasienData, "ayah", time.Now()),
+				"tglLahirAyah": pasien.FormatDate(pasienData["tanggal_lahir_ayah"]),
+				"namaIbu":      pasienData["nama_ibu"],
+				"usiaIbu":      pasien.UmurOrangTua(pasienData, "ibu", time.Now()),
+				"tglLahirIbu":  pasien.FormatDate(pasienData["tanggal_lahir_ibu"]),
+				"puskesmas":    pasienData["puskesmas"],
+				"bidan":        pasienData["bidan"],
+				"alamat":       pasienData["alamat"],
+				"desa":         pasienData["desa"],
+				"kecamatan":    pasienData["kecamatan"],
+				"kabupaten":    pasienData["kabupaten"],
+				"provinsi":     pasienData["provinsi"],
 			},
 			"detailBayi":                  pasienData["data_imunisasi"].(bson.M)["detail_bayi"],
 			"pemeriksaanNeonatus":         pasienData["data_imunisasi"].(bson.M)["pemeriksaan_neonatus"],
@@ -124,27 +138,36 @@ func EditImunisasi(w http.ResponseWriter, r *http.Request) {
 			"pemeriksaanBalita":           pasienData["data_imunisasi"].(bson.M)["pemeriksaan_balita"],
 		}
 
-		jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": returnData})
+		w.Header().Set("ETag", pasien.ETag(pasien.Version(pasienData)))
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": returnData, "version": pasien.Version(pasienData)})
 		w.WriteHeader(http.StatusOK)
 		w.Write(jsonData)
 		return
 	} else {
 		targetPasien := bson.M{"id_pasien": id_pasien}
+		version, err := pasien.IfMatch(r)
+		if err != nil {
+			pasien.WriteUpdateError(w, err)
+			return
+		}
 		dataPasien := bson.M{
-			"nomor_bayi":  data["generalInformation"].(map[string]interface{})["nomorBayi"],
-			"nomor":       data["generalInformation"].(map[string]interface{})["nomor"],
-			"nama_pasien": data["generalInformation"].(map[string]interface{})["namaBayi"],
-			"nama_ayah":   data["generalInformation"].(map[string]interface{})["namaAyah"],
-			"umur_ayah":   data["generalInformation"].(map[string]interface{})["usiaAyah"],
-			"nama_ibu":    data["generalInformation"].(map[string]interface{})["namaIbu"],
-			"umur_ibu":    data["generalInformation"].(map[string]interface{})["usiaIbu"],
-			"puskesmas":   data["generalInformation"].(map[string]interface{})["puskesmas"],
-			"bidan":       data["generalInformation"].(map[string]interface{})["bidan"],
-			"alamat":      data["generalInformation"].(map[string]interface{})["alamat"],
-			"desa":        data["generalInformation"].(map[string]interface{})["desa"],
-			"kecamatan":   data["generalInformation"].(map[string]interface{})["kecamatan"],
-			"kabupaten":   data["generalInformation"].(map[string]interface{})["kabupaten"],
-			"provinsi":    data["generalInformation"].(map[string]interface{})["provinsi"],
+			"nomor_bayi":         data["generalInformation"].(map[string]interface{})["nomorBayi"],
+			"nomor":              data["generalInformation"].(map[string]interface{})["nomor"],
+			"nama_pasien":        data["generalInformation"].(map[string]interface{})["namaBayi"],
+			"tanggal_lahir":      data["generalInformation"].(map[string]interface{})["tglLahir"],
+			"nama_ayah":          data["generalInformation"].(map[string]interface{})["namaAyah"],
+			"umur_ayah":          data["generalInformation"].(map[string]interface{})["usiaAyah"],
+			"tanggal_lahir_ayah": data["generalInformation"].(map[string]interface{})["tglLahirAyah"],
+			"nama_ibu":           data["generalInformation"].(map[string]interface{})["namaIbu"],
+			"umur_ibu":           data["generalInformation"].(map[string]interface{})["usiaIbu"],
+			"tanggal_lahir_ibu":  data["generalInformation"].(map[string]interface{})["tglLahirIbu"],
+			"puskesmas":          data["generalInformation"].(map[string]interface{})["puskesmas"],
+			"bidan":              data["generalInformation"].(map[string]interface{})["bidan"],
+			"alamat":             data["generalInformation"].(map[string]interface{})["alamat"],
+			"desa":               data["generalInformation"].(map[string]interface{})["desa"],
+			"kecamatan":          data["generalInformation"].(map[string]interface{})["kecamatan"],
+			"kabupaten":          data["generalInformation"].(map[string]interface{})["kabupaten"],
+			"provinsi":           data["generalInformation"].(map[string]interface{})["provinsi"],
 			"data_imunisasi": bson.M{
 				"detail_bayi":                   data["detailBayi"],
 				"pemeriksaan_neonatus":          data["pemeriksaanNeonatus"],
@@ -153,12 +176,46 @@ func EditImunisasi(w http.ResponseWriter, r *http.Request) {
 			},
 		}
 
-		if _, err := db.Collection("pasien").UpdateOne(context.Background(), targetPasien, bson.M{"$set": dataPasien}); err != nil {
-			jsonData, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("error updating data for id_pasien=%d", id_pasien)})
-			w.WriteHeader(http.StatusInternalServerError)
+		general, _ := data["generalInformation"].(map[string]interface{})
+		identity, err := pasien.IdentityFields(general)
+		if err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
+		if err := pasien.CheckNIKUnique(context.Background(), db.Collection("pasien"), identity["nik"], id_pasien); err != nil {
+			status := http.StatusInternalServerError
+			if err == pasien.ErrNIKExists {
+				status = http.StatusConflict
+			}
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(status)
 			w.Write(jsonData)
 			return
 		}
+		for key, value := range identity {
+			dataPasien[key] = value
+		}
+		if err := pasien.NormalizeDates(dataPasien); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
+		if err := pasien.NormalizePhones(dataPasien); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
+
+		updated, err := pasien.UpdateVersioned(context.Background(), db.Collection("pasien"), targetPasien, version, bson.M{"$set": dataPasien})
+		if err != nil {
+			pasien.WriteUpdateError(w, err)
+			return
+		}
+		w.Header().Set("ETag", pasien.ETag(pasien.Version(updated)))
 
 		jsonData, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("changed id_pasien=%d data", id_pasien)})
 		w.WriteHeader(http.StatusOK)
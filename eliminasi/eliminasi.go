@@ -0,0 +1,466 @@
+package eliminasi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Kazengan/bidan-backend/lab"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pemeriksaan are the lab tests of the triple elimination of mother to
+// child transmission: HIV, syphilis and hepatitis B.
+var Pemeriksaan = []string{"hiv", "sifilis", "hbsag"}
+
+// Collection holds the follow-up of reactive screening results.
+const Collection = "tindak_lanjut_eliminasi"
+
+// Jenis of follow-up: treatment started here or a referral to the
+// puskesmas or hospital that treats it.
+const (
+	JenisTerapi  = "terapi"
+	JenisRujukan = "rujukan"
+)
+
+// Status of one screening in a pregnancy.
+const (
+	BelumDiperiksa        = "belum_diperiksa"
+	NonReaktif            = "non_reaktif"
+	ReaktifBelumDitangani = "reaktif_belum_ditangani"
+	ReaktifDitangani      = "reaktif_ditangani"
+)
+
+// lamaKehamilan bounds a pregnancy whose HPHT is unknown, counted from
+// registration, and the window after HPHT pasien.UsiaKehamilan accepts.
+const lamaKehamilan = 45 * 7 * 24 * time.Hour
+
+// TindakLanjut is the follow-up of a reactive result.
+type TindakLanjut struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id_tindak_lanjut"`
+	IDPasien    pasien.ID          `bson:"id_pasien" json:"id_pasien"`
+	Pemeriksaan string             `bson:"pemeriksaan" json:"pemeriksaan"`
+	Tanggal     time.Time          `bson:"tanggal" json:"tanggal"`
+	Jenis       string             `bson:"jenis" json:"jenis"`
+	Keterangan  string             `bson:"keterangan,omitempty" json:"keterangan,omitempty"`
+	Penulis     *sesi.Bidan        `bson:"penulis,omitempty" json:"penulis,omitempty"`
+	DibuatPada  time.Time          `bson:"dibuat_pada" json:"dibuat_pada"`
+}
+
+// Skrining is the state of one test in a pregnancy: the latest result of
+// it and, when that is reactive, the follow-up recorded since.
+type Skrining struct {
+	Pemeriksaan  string        `json:"pemeriksaan"`
+	Status       string        `json:"status"`
+	Hasil        *lab.Hasil    `json:"hasil,omitempty"`
+	TindakLanjut *TindakLanjut `json:"tindak_lanjut,omitempty"`
+}
+
+// Kehamilan is the triple elimination status of the pregnancy of a
+// Kehamilan patient. It starts at HPHT, or at registration when HPHT is
+// not recorded.
+type Kehamilan struct {
+	IDPasien      pasien.ID  `json:"id_pasien"`
+	Nama          string     `json:"nama"`
+	Desa          string     `json:"desa"`
+	HPHT          *time.Time `json:"hpht,omitempty"`
+	Mulai         time.Time  `json:"mulai"`
+	Skrining      []Skrining `json:"skrining"`
+	Lengkap       bool       `json:"lengkap"`
+	PerluTindakan bool       `json:"perlu_tindakan"`
+}
+
+// NormalizeDesa returns a desa name trimmed, single-spaced and capitalised
+// per word, so that "SUKA  MAJU" and "suka maju" are reported and filtered
+// as the same desa.
+func NormalizeDesa(desa string) string {
+	words := strings.Fields(strings.ToLower(desa))
+	for i, word := range words {
+		r, size := utf8.DecodeRuneInString(word)
+		words[i] = string(unicode.ToUpper(r)) + word[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+// mulai returns when the pregnancy of doc began, whether HPHT was known.
+func mulai(doc bson.M) (time.Time, *time.Time, bool) {
+	if hpht, ok := pasien.HPHT(doc); ok {
+		return hpht, &hpht, true
+	}
+	if registered, ok := pasien.AsTime(doc["tanggal_register"]); ok {
+		return registered, nil, true
+	}
+	return time.Time{}, nil, false
+}
+
+// Hamil reports whether the pregnancy of doc is still going on at t.
+func Hamil(doc bson.M, t time.Time) bool {
+	start, _, ok := mulai(doc)
+	return ok && !t.Before(start) && t.Sub(start) <= lamaKehamilan
+}
+
+// Status works out the screening status of the pregnancy of doc on day
+// sampai from the patient's lab results and follow-ups, both oldest first.
+// Results and follow-ups before the pregnancy or after sampai are ignored.
+func Status(doc bson.M, results []lab.Hasil, tindak []TindakLanjut, sampai time.Time) Kehamilan {
+	id, _ := pasien.IDFrom(doc["id_pasien"])
+	k := Kehamilan{IDPasien: id, Lengkap: true}
+	k.Nama, _ = doc["nama_pasien"].(string)
+	if desa, ok := pasien.Lookup(doc, "data_kehamilan.desa"); ok {
+		s, _ := desa.(string)
+		k.Desa = NormalizeDesa(s)
+	}
+	k.Mulai, k.HPHT, _ = mulai(doc)
+
+	within := func(t time.Time) bool {
+		return !t.Before(k.Mulai) && !t.After(sampai)
+	}
+	for _, kode := range Pemeriksaan {
+		s := Skrining{Pemeriksaan: kode, Status: BelumDiperiksa}
+		for i := range results {
+			if results[i].Pemeriksaan == kode && within(results[i].Tanggal) {
+				s.Hasil = &results[i]
+			}
+		}
+		switch {
+		case s.Hasil == nil:
+			k.Lengkap = false
+		case !s.Hasil.Abnormal:
+			s.Status = NonReaktif
+		default:
+			s.Status = ReaktifBelumDitangani
+			for i := range tindak {
+				if tindak[i].Pemeriksaan == kode && !tindak[i].Tanggal.Before(dayOf(s.Hasil.Tanggal)) && !tindak[i].Tanggal.After(sampai) {
+					s.Status = ReaktifDitangani
+					s.TindakLanjut = &tindak[i]
+					break
+				}
+			}
+		}
+		if s.Status == BelumDiperiksa || s.Status == ReaktifBelumDitangani {
+			k.PerluTindakan = true
+		}
+		k.Skrining = append(k.Skrining, s)
+	}
+	return k
+}
+
+func dayOf(t time.Time) time.Time {
+	y, m, d := t.In(pasien.Jakarta).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, pasien.Jakarta)
+}
+
+// ForPasien returns the follow-ups of the patients in ids by patient,
+// oldest first.
+func ForPasien(ctx context.Context, db *mongo.Database, ids []pasien.ID) (map[pasien.ID][]TindakLanjut, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "tanggal", Value: 1}})
+	cursor, err := db.Collection(Collection).Find(ctx, bson.M{"id_pasien": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var list []TindakLanjut
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	byPasien := map[pasien.ID][]TindakLanjut{}
+	for _, t := range list {
+		byPasien[t.IDPasien] = append(byPasien[t.IDPasien], t)
+	}
+	return byPasien, nil
+}
+
+// DeleteForPasien deletes every follow-up of a patient, for when the
+// patient is deleted or anonymised.
+func DeleteForPasien(ctx context.Context, db *mongo.Database, idPasien interface{}) error {
+	_, err := db.Collection(Collection).DeleteMany(ctx, bson.M{"id_pasien": idPasien})
+	return err
+}
+
+// Load returns the Kehamilan patients matching filter, decrypted, with
+// their triple elimination lab results and follow-ups.
+func Load(ctx context.Context, db *mongo.Database, filter bson.M, opts ...*options.FindOptions) ([]bson.M, map[pasien.ID][]lab.Hasil, map[pasien.ID][]TindakLanjut, error) {
+	query := bson.M{"data_kehamilan": bson.M{"$exists": true}, "anonymised_at": bson.M{"$exists": false}}
+	for key, value := range filter {
+		query[key] = value
+	}
+	docs, err := pasien.FindAll(ctx, db.Collection("pasien"), query, opts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(docs) == 0 {
+		return docs, map[pasien.ID][]lab.Hasil{}, map[pasien.ID][]TindakLanjut{}, nil
+	}
+	ids := make([]pasien.ID, 0, len(docs))
+	for _, doc := range docs {
+		if id, err := pasien.IDFrom(doc["id_pasien"]); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	results := map[pasien.ID][]lab.Hasil{}
+	for _, kode := range Pemeriksaan {
+		byPasien, err := lab.ForPasien(ctx, db, ids, kode)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for id, list := range byPasien {
+			results[id] = append(results[id], list...)
+		}
+	}
+	for id := range results {
+		list := results[id]
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Tanggal.Before(list[j].Tanggal) })
+	}
+	tindak, err := ForPasien(ctx, db, ids)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return docs, results, tindak, nil
+}
+
+// worklistBatch is how many patients Worklist reads at a time.
+const worklistBatch = 200
+
+// Worklist returns up to limit mothers pregnant at now who are not
+// screened for all three or have a reactive result without follow-up, in
+// id_pasien order starting after setelah, and the id_pasien to continue
+// after, 0 when there are no more. filter narrows the patients as in Load.
+// Whether a mother needs action depends on her encrypted results, so the
+// patients are read in batches until the page is full rather than all at
+// once; names are encrypted too, so the order is by id_pasien.
+func Worklist(ctx context.Context, db *mongo.Database, filter bson.M, setelah pasien.ID, limit int, now time.Time) ([]Kehamilan, pasien.ID, error) {
+	worklist := []Kehamilan{}
+	opts := options.Find().SetSort(bson.D{{Key: "id_pasien", Value: 1}}).SetLimit(worklistBatch)
+	for {
+		query := bson.M{"id_pasien": bson.M{"$gt": setelah}}
+		for key, value := range filter {
+			query[key] = value
+		}
+		docs, results, tindak, err := Load(ctx, db, query, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, doc := range docs {
+			idPasien, err := pasien.IDFrom(doc["id_pasien"])
+			if err != nil {
+				continue
+			}
+			setelah = idPasien
+			if !Hamil(doc, now) {
+				continue
+			}
+			if k := Status(doc, results[idPasien], tindak[idPasien], now); k.PerluTindakan {
+				worklist = append(worklist, k)
+			}
+			if len(worklist) == limit {
+				return worklist, idPasien, nil
+			}
+		}
+		if len(docs) < worklistBatch {
+			return worklist, 0, nil
+		}
+	}
+}
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Handler serves /api/eliminasi. GET ?id_pasien= returns the screening
+// status of the patient's pregnancy. GET without it is the worklist, see
+// Worklist, optionally of one ?desa=: ?limit= mothers (50 by default, at
+// most 100), and the next page with ?setelah= set to the setelah of the
+// response. POST records the follow-up of a reactive result. Both need the
+// bidan's token.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	// The status includes the HIV, syphilis and hepatitis B results, so
+	// reading it needs the bidan's token as much as recording follow-up.
+	bidan, err := sesi.FromRequest(r)
+	if err != nil {
+		respondWithError(w, soap.Status(err), err.Error())
+		return
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+	now := time.Now()
+
+	switch r.Method {
+	case http.MethodGet:
+		if id := r.URL.Query().Get("id_pasien"); id != "" {
+			idPasien, err := pasien.ParseID(id)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+				return
+			}
+			docs, results, tindak, err := Load(r.Context(), db, bson.M{"id_pasien": idPasien})
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Error executing query")
+				return
+			}
+			if len(docs) == 0 {
+				respondWithError(w, http.StatusNotFound, "pasien kehamilan tidak ditemukan")
+				return
+			}
+			k := Status(docs[0], results[idPasien], tindak[idPasien], now)
+			respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": k, "hamil": Hamil(docs[0], now)})
+			return
+		}
+
+		limit := 50
+		if s := r.URL.Query().Get("limit"); s != "" {
+			limit, err = strconv.Atoi(s)
+			if err != nil || limit < 1 || limit > 100 {
+				respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+				return
+			}
+		}
+		var setelah pasien.ID
+		if s := r.URL.Query().Get("setelah"); s != "" {
+			setelah, err = pasien.ParseID(s)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "invalid setelah")
+				return
+			}
+		}
+		filter := bson.M{}
+		if desa := pasien.NormalizeSearchValue("desa", r.URL.Query().Get("desa")); desa != "" {
+			filter["search.desa"] = desa
+		}
+
+		worklist, next, err := Worklist(r.Context(), db, filter, setelah, limit, now)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error executing query")
+			return
+		}
+		response := map[string]interface{}{"message": "success", "data": worklist}
+		if next != 0 {
+			response["setelah"] = next
+		}
+		respondWithJSON(w, http.StatusOK, response)
+
+	case http.MethodPost:
+		create(w, r, db, bidan)
+
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// request is the body of a POST.
+type request struct {
+	IDPasien    pasien.ID   `json:"id_pasien"`
+	Pemeriksaan string      `json:"pemeriksaan"`
+	Tanggal     interface{} `json:"tanggal"`
+	Jenis       string      `json:"jenis"`
+	Keterangan  string      `json:"keterangan"`
+}
+
+func (req request) validate() (TindakLanjut, error) {
+	var problems []string
+	t := TindakLanjut{
+		IDPasien:    req.IDPasien,
+		Pemeriksaan: strings.ToLower(strings.TrimSpace(req.Pemeriksaan)),
+		Jenis:       strings.ToLower(strings.TrimSpace(req.Jenis)),
+		Keterangan:  strings.TrimSpace(req.Keterangan),
+	}
+	if req.IDPasien <= 0 {
+		problems = append(problems, "id_pasien tidak valid")
+	}
+	valid := false
+	for _, kode := range Pemeriksaan {
+		valid = valid || kode == t.Pemeriksaan
+	}
+	if !valid {
+		problems = append(problems, "pemeriksaan harus salah satu dari "+strings.Join(Pemeriksaan, ", "))
+	}
+	if t.Jenis != JenisTerapi && t.Jenis != JenisRujukan {
+		problems = append(problems, "jenis harus terapi atau rujukan")
+	}
+	if tanggal, ok := pasien.AsTime(req.Tanggal); ok {
+		t.Tanggal = tanggal
+	} else {
+		problems = append(problems, "tanggal needed")
+	}
+	if len(problems) > 0 {
+		return t, soap.InvalidError(problems)
+	}
+	return t, nil
+}
+
+// create records a follow-up. It must be for a reactive result of the
+// current pregnancy, so a follow-up cannot be recorded ahead of the result.
+func create(w http.ResponseWriter, r *http.Request, db *mongo.Database, bidan sesi.Bidan) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	t, err := req.validate()
+	if err != nil {
+		respondWithError(w, soap.Status(err), err.Error())
+		return
+	}
+
+	docs, results, _, err := Load(r.Context(), db, bson.M{"id_pasien": t.IDPasien})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing query")
+		return
+	}
+	if len(docs) == 0 {
+		respondWithError(w, http.StatusNotFound, "pasien kehamilan tidak ditemukan")
+		return
+	}
+	k := Status(docs[0], results[t.IDPasien], nil, dayOf(t.Tanggal).AddDate(0, 0, 1))
+	for _, s := range k.Skrining {
+		if s.Pemeriksaan == t.Pemeriksaan && s.Status != ReaktifBelumDitangani {
+			respondWithError(w, http.StatusUnprocessableEntity, fmt.Sprintf("tidak ada hasil %s reaktif pada kehamilan ini sampai tanggal tindak lanjut", t.Pemeriksaan))
+			return
+		}
+	}
+
+	t.ID = primitive.NewObjectID()
+	t.Penulis = &bidan
+	t.DibuatPada = time.Now()
+	if _, err := db.Collection(Collection).InsertOne(r.Context(), t); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error inserting data to database")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{"message": "success", "data": t})
+}
@@ -0,0 +1,91 @@
+package eliminasi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/lab"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNormalizeDesa(t *testing.T) {
+	tests := map[string]string{
+		"Suka Maju":      "Suka Maju",
+		"  SUKA   MAJU ": "Suka Maju",
+		"suka maju":      "Suka Maju",
+		"\tsukamaju\n":   "Sukamaju",
+		"":               "",
+	}
+	for in, want := range tests {
+		if got := NormalizeDesa(in); got != want {
+			t.Errorf("NormalizeDesa(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func tanggal(s string) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02", s, pasien.Jakarta)
+	return t
+}
+
+func TestStatus(t *testing.T) {
+	doc := bson.M{
+		"id_pasien":        int64(5),
+		"nama_pasien":      "Siti",
+		"tanggal_register": tanggal("2024-02-01"),
+		"data_kehamilan":   bson.M{"desa": "SUKA  maju", "hpht": tanggal("2024-01-01")},
+	}
+	results := []lab.Hasil{
+		// Before this pregnancy.
+		{Pemeriksaan: "hiv", Tanggal: tanggal("2023-06-01"), Abnormal: true},
+		{Pemeriksaan: "hiv", Tanggal: tanggal("2024-02-01")},
+		{Pemeriksaan: "sifilis", Tanggal: tanggal("2024-02-01"), Abnormal: true},
+		// After the day the status is asked for.
+		{Pemeriksaan: "hbsag", Tanggal: tanggal("2024-04-01")},
+	}
+	tindak := []TindakLanjut{
+		// Before the result it would follow up.
+		{Pemeriksaan: "sifilis", Tanggal: tanggal("2024-01-20"), Jenis: JenisTerapi},
+	}
+
+	k := Status(doc, results, tindak, tanggal("2024-03-01"))
+	if k.IDPasien != 5 || k.Nama != "Siti" || k.Desa != "Suka Maju" || !k.Mulai.Equal(tanggal("2024-01-01")) || k.HPHT == nil {
+		t.Errorf("Status = %+v", k)
+	}
+	want := map[string]string{"hiv": NonReaktif, "sifilis": ReaktifBelumDitangani, "hbsag": BelumDiperiksa}
+	for _, s := range k.Skrining {
+		if s.Status != want[s.Pemeriksaan] {
+			t.Errorf("%s = %s, want %s", s.Pemeriksaan, s.Status, want[s.Pemeriksaan])
+		}
+	}
+	if k.Lengkap || !k.PerluTindakan {
+		t.Errorf("lengkap %v, perlu tindakan %v", k.Lengkap, k.PerluTindakan)
+	}
+
+	// Followed up on the day of the result, and hbsag screened.
+	tindak = append(tindak, TindakLanjut{Pemeriksaan: "sifilis", Tanggal: tanggal("2024-02-01"), Jenis: JenisRujukan})
+	k = Status(doc, results, tindak, tanggal("2024-05-01"))
+	for _, s := range k.Skrining {
+		if s.Pemeriksaan == "sifilis" && (s.Status != ReaktifDitangani || s.TindakLanjut.Jenis != JenisRujukan) {
+			t.Errorf("sifilis = %+v, want followed up by the referral", s)
+		}
+	}
+	if !k.Lengkap || k.PerluTindakan {
+		t.Errorf("lengkap %v, perlu tindakan %v", k.Lengkap, k.PerluTindakan)
+	}
+}
+
+func TestStatusWithoutHPHT(t *testing.T) {
+	doc := bson.M{"id_pasien": int64(6), "tanggal_register": tanggal("2024-02-01"), "data_kehamilan": bson.M{}}
+	k := Status(doc, []lab.Hasil{{Pemeriksaan: "hiv", Tanggal: tanggal("2024-01-15")}}, nil, tanggal("2024-03-01"))
+	if k.HPHT != nil || !k.Mulai.Equal(tanggal("2024-02-01")) {
+		t.Errorf("hpht %v, mulai %v, want the registration", k.HPHT, k.Mulai)
+	}
+	if k.Skrining[0].Status != BelumDiperiksa {
+		t.Errorf("hiv before registration counted: %+v", k.Skrining[0])
+	}
+	if !Hamil(doc, tanggal("2024-03-01")) || Hamil(doc, tanggal("2025-01-01")) {
+		t.Error("Hamil does not end 45 weeks after registration")
+	}
+}
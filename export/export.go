@@ -6,7 +6,12 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/Kazengan/bidan-backend/lab"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"github.com/Kazengan/bidan-backend/soap"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -42,6 +47,15 @@ func Export(w http.ResponseWriter, r *http.Request) {
 	idLayanan := reqBody.IdLayanan
 	dateRange := reqBody.Date
 
+	// The lab results in a Kehamilan export include HIV, syphilis and
+	// hepatitis B, so it needs the bidan's token.
+	if idLayanan == 1 {
+		if _, err := sesi.FromRequest(r); err != nil {
+			http.Error(w, err.Error(), soap.Status(err))
+			return
+		}
+	}
+
 	if dateRange == nil {
 		http.Error(w, "date not provided", http.StatusUnauthorized)
 		return
@@ -55,11 +69,20 @@ func Export(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Build the query
+	from, errFrom := pasien.ParseTanggal(dateFrom)
+	to, errTo := pasien.ParseTanggal(dateTo)
+	if errFrom != nil || errTo != nil {
+		http.Error(w, "invalid date range", http.StatusBadRequest)
+		return
+	}
+
+	// Build the query. Both ends are whole days in Jakarta time.
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, pasien.Jakarta)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, pasien.Jakarta).AddDate(0, 0, 1)
 	query := bson.M{
 		"tanggal_register": bson.M{
-			"$gte": dateFrom,
-			"$lte": dateTo,
+			"$gte": from,
+			"$lt":  to,
 		},
 	}
 
@@ -77,23 +100,32 @@ func Export(w http.ResponseWriter, r *http.Request) {
 
 	// Execute the query
 	collection := db.Collection("pasien")
-	cursor, err := collection.Find(context.TODO(), query)
+	documents, err := pasien.FindAll(context.TODO(), collection, query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Query error: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.TODO())
-
-	var documents []bson.M
-	if err = cursor.All(context.TODO(), &documents); err != nil {
-		http.Error(w, fmt.Sprintf("Cursor error: %s", err.Error()), http.StatusInternalServerError)
-		return
-	}
 
 	for i := range documents {
 		documents[i]["_id"] = documents[i]["_id"].(primitive.ObjectID).Hex()
 	}
 
+	// Kehamilan rows carry the antenatal lab results of the patient.
+	var hasilLab map[pasien.ID][]lab.Hasil
+	if idLayanan == 1 {
+		ids := make([]pasien.ID, 0, len(documents))
+		for _, doc := range documents {
+			if id, err := pasien.IDFrom(doc["id_pasien"]); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		hasilLab, err = lab.ForPasien(context.TODO(), db, ids, "")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Query error: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Process and format the data based on id_layanan
 	var formattedDocuments []bson.M
 	for _, doc := range documents {
@@ -102,12 +134,15 @@ func Export(w http.ResponseWriter, r *http.Request) {
 		case 0:
 			returnData = bson.M{
 				"generalInformation": bson.M{
+					"nik":               doc["nik"],
+					"noRegister":        doc["no_register"],
+					"noJkn":             doc["no_jkn"],
 					"noFaskes":          doc["data_kb"].(bson.M)["no_faskes"],
 					"noSeriKartu":       doc["data_kb"].(bson.M)["no_seri_kartu"],
-					"tglDatang":         doc["tanggal_register"],
+					"tglDatang":         pasien.FormatDateTime(doc["tanggal_register"]),
 					"namaPeserta":       doc["nama_pasien"],
-					"tglLahir":          doc["tanggal_lahir"],
-					"usia":              doc["umur"],
+					"tglLahir":          pasien.FormatDate(doc["tanggal_lahir"]),
+					"usia":              pasien.Umur(doc, time.Now()),
 					"namaPasangan":      doc["nama_pasangan"],
 					"jenisPasangan":     doc["jenis_pasangan"],
 					"pendidikanAkhir":   doc["pendidikan"],
@@ -124,6 +159,9 @@ func Export(w http.ResponseWriter, r *http.Request) {
 		case 1:
 			returnData = bson.M{
 				"generalInformation": bson.M{
+					"nik":             doc["nik"],
+					"noRegister":      doc["no_register"],
+					"noJkn":           doc["no_jkn"],
 					"agama":           doc["data_kehamilan"].(bson.M)["agama"],
 					"pekerjaan":       doc["data_kehamilan"].(bson.M)["pekerjaan"],
 					"desa":            doc["data_kehamilan"].(bson.M)["desa"],
@@ -132,16 +170,16 @@ func Export(w http.ResponseWriter, r *http.Request) {
 					"provinsi":        doc["data_kehamilan"].(bson.M)["provinsi"],
 					"rtrw":            doc["data_kehamilan"].(bson.M)["rtrw"],
 					"noIbu":           doc["data_kehamilan"].(bson.M)["no_ibu"],
-					"tanggalRegister": doc["tanggal_register"],
+					"tanggalRegister": pasien.FormatDateTime(doc["tanggal_register"]),
 					"namaLengkap":     doc["nama_pasien"],
-					"tanggalLahir":    doc["tanggal_lahir"],
-					"umur":            doc["umur"],
+					"tanggalLahir":    pasien.FormatDate(doc["tanggal_lahir"]),
+					"umur":            pasien.Umur(doc, time.Now()),
 					"namaSuami":       doc["nama_pasangan"],
 					"pendidikan":      doc["pendidikan"],
 					"alamatDomisili":  doc["alamat"],
 				},
 				"kunjunganNifas":                        doc["data_kehamilan"].(bson.M)["kunjungan_nifas"],
-				"mendeteksiFaktorResikoDanResikoTinggi": doc["data_kehamilan"].(bson.M)["faktor_resiko_resiko_tinggi"],
+				"mendeteksiFaktorResikoDanResikoTinggi": doc["data_kehamilan"].(bson.M)["faktor_risiko_dan_risiko_tinggi"],
 				"pemeriksaanPNC":                        doc["data_kehamilan"].(bson.M)["pemeriksaan_pnc"],
 				"persalinan":                            doc["data_kehamilan"].(bson.M)["persalinan"],
 				"rencanaPersalinan":                     doc["data_kehamilan"].(bson.M)["rencana_persalinan"],
@@ -149,24 +187,33 @@ func Export(w http.ResponseWriter, r *http.Request) {
 				"skriningTT":                            doc["data_kehamilan"].(bson.M)["skrining_tt"],
 				"section2":                              doc["data_kehamilan"].(bson.M)["section2"],
 			}
+			id, _ := pasien.IDFrom(doc["id_pasien"])
+			returnData["hasilLab"] = hasilLab[id]
 		case 2:
 			returnData = bson.M{
 				"generalInformation": bson.M{
-					"nomorBayi": doc["nomor_bayi"],
-					"nomor":     doc["nomor"],
-					"namaBayi":  doc["nama_pasien"],
-					"namaAyah":  doc["nama_ayah"],
-					"usiaAyah":  doc["umur_ayah"],
-					"namaIbu":   doc["nama_ibu"],
-					"usiaIbu":   doc["umur_ibu"],
-					"puskesmas": doc["puskesmas"],
-					"bidan":     doc["bidan"],
-					"alamat":    doc["alamat"],
-					"desa":      doc["desa"],
-					"kecamatan": doc["kecamatan"],
-					"kabupaten": doc["kabupaten"],
-					"provinsi":  doc["provinsi"],
-					"noHP":      doc["no_hp"],
+					"nik":          doc["nik"],
+					"noRegister":   doc["no_register"],
+					"noJkn":        doc["no_jkn"],
+					"nomorBayi":    doc["nomor_bayi"],
+					"nomor":        doc["nomor"],
+					"namaBayi":     doc["nama_pasien"],
+					"tglLahir":     pasien.FormatDate(doc["tanggal_lahir"]),
+					"usia":         pasien.Umur(doc, time.Now()),
+					"namaAyah":     doc["nama_ayah"],
+					"usiaAyah":     pasien.UmurOrangTua(doc, "ayah", time.Now()),
+					"tglLahirAyah": pasien.FormatDate(doc["tanggal_lahir_ayah"]),
+					"namaIbu":      doc["nama_ibu"],
+					"usiaIbu":      pasien.UmurOrangTua(doc, "ibu", time.Now()),
+					"tglLahirIbu":  pasien.FormatDate(doc["tanggal_lahir_ibu"]),
+					"puskesmas":    doc["puskesmas"],
+					"bidan":        doc["bidan"],
+					"alamat":       doc["alamat"],
+					"desa":         doc["desa"],
+					"kecamatan":    doc["kecamatan"],
+					"kabupaten":    doc["kabupaten"],
+					"provinsi":     doc["provinsi"],
+					"noHP":         doc["no_hp"],
 				},
 				"detailBayi":                  doc["data_imunisasi"].(bson.M)["detail_bayi"],
 				"pemeriksaanNeonatus":         doc["data_imunisasi"].(bson.M)["pemeriksaan_neonatus"],
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -18,7 +20,7 @@ func respondWithError(w http.ResponseWriter, statusCode int, message string) {
 	w.Write(jsonData)
 }
 
-func buildRegexQuery(keyword string, id_layanan int) bson.M {
+func buildRegexQuery(keyword string, id_layanan int) (bson.M, error) {
 	var existsField string
 	switch id_layanan {
 	case 0:
@@ -28,15 +30,55 @@ func buildRegexQuery(keyword string, id_layanan int) bson.M {
 	case 2:
 		existsField = "data_imunisasi"
 	default:
-		return nil
+		return nil, nil
 	}
 
+	// A 16-digit keyword is a NIK and is looked up exactly on its unique index.
+	if pasien.ValidateNIK(keyword, "") == nil {
+		candidates, err := pasien.Blind("nik", keyword)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{
+			"$and": []bson.M{
+				{existsField: bson.M{"$exists": true}},
+				{"nik": bson.M{"$in": candidates}},
+			},
+		}, nil
+	}
+
+	// A keyword that is a phone number is looked up exactly in E.164.
+	if noHP, err := pasien.NormalizePhone(keyword); err == nil {
+		candidates, err := pasien.Blind("no_hp", noHP)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{
+			"$and": []bson.M{
+				{existsField: bson.M{"$exists": true}},
+				{"no_hp": bson.M{"$in": candidates}},
+			},
+		}, nil
+	}
+
+	// An encrypted name can only be found from the start of one of its
+	// words, through its blind prefixes.
+	nama := bson.M{"nama_pasien": bson.M{"$regex": regexp.QuoteMeta(keyword), "$options": "i"}}
+	if pasien.IsEncrypted("nama_pasien") {
+		clause, err := pasien.PrefixFilter("nama", keyword)
+		if err != nil {
+			return nil, err
+		}
+		if clause != nil {
+			nama = clause
+		}
+	}
 	return bson.M{
 		"$and": []bson.M{
 			{existsField: bson.M{"$exists": true}},
-			{"nama_pasien": bson.M{"$regex": keyword, "$options": "i"}},
+			nama,
 		},
-	}
+	}, nil
 }
 
 func PasienPerLayanan(w http.ResponseWriter, r *http.Request) {
@@ -60,7 +102,11 @@ func PasienPerLayanan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	regexquery := buildRegexQuery(keyword, id_layanan)
+	regexquery, err := buildRegexQuery(keyword, id_layanan)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if regexquery == nil {
 		respondWithError(w, http.StatusInternalServerError, "Under construction")
 		return
@@ -75,7 +121,7 @@ func PasienPerLayanan(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cursor.Close(context.Background())
 
-	finalList := []int{}
+	finalList := []pasien.ID{}
 	for cursor.Next(context.Background()) {
 		var result bson.M
 		if err := cursor.Decode(&result); err != nil {
@@ -83,12 +129,12 @@ func PasienPerLayanan(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if idPasien, ok := result["id_pasien"].(int64); ok {
-			finalList = append(finalList, int(idPasien))
-		} else {
-			respondWithError(w, http.StatusInternalServerError, "Failed to convert id_pasien to int")
+		idPasien, err := pasien.IDFrom(result["id_pasien"])
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		finalList = append(finalList, idPasien)
 	}
 
 	jsonData, _ := json.Marshal(map[string]interface{}{"message": "Success", "id_pasien": finalList})
@@ -6,8 +6,9 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strconv"
 
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/persetujuan"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -29,7 +30,7 @@ func GetPasien(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id_pasien := r.URL.Query().Get("id_pasien")
-	id_pasien_int, err := strconv.Atoi(id_pasien)
+	id_pasien_int, err := pasien.ParseID(id_pasien)
 	if err != nil {
 		message := map[string]string{"message": "Invalid id_pasien", "statusCode": "400"}
 		jsonData, _ := json.Marshal(message)
@@ -55,8 +56,7 @@ func GetPasien(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	coll := client.Database("mydb").Collection("pasien")
-	var result bson.M
-	err = coll.FindOne(context.Background(), bson.D{{"id_pasien", id_pasien_int}}).Decode(&result)
+	result, err := pasien.FindOne(context.Background(), coll, bson.D{{Key: "id_pasien", Value: id_pasien_int}})
 
 	if err == mongo.ErrNoDocuments {
 		message := map[string]string{"message": "No user found", "statusCode": "200"}
@@ -70,6 +70,17 @@ func GetPasien(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
+	consents, err := persetujuan.ForPasien(context.Background(), client.Database("mydb"), id_pasien_int)
+	if err != nil {
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": "Error finding persetujuan", "statusCode": 500})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(jsonData)
+		return
+	}
+	result["persetujuan"] = consents
+
+	w.Header().Set("ETag", pasien.ETag(pasien.Version(result)))
+	pasien.FormatDates(result)
 	jsonData, err := json.Marshal(map[string]interface{}{"message": "Success", "data": result, "statusCode": "200"})
 	if err != nil {
 		somethingwentwrong, _ := json.Marshal(map[string]interface{}{"message": "Something went wrong", "statusCode": 400})
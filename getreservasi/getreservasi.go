@@ -5,8 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"time"
 
-
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -44,11 +45,20 @@ func GetReservasi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	hari, err := pasien.ParseTanggal(tanggal)
+	if err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": "tanggal tidak valid"})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonData)
+		return
+	}
+	hari = time.Date(hari.Year(), hari.Month(), hari.Day(), 0, 0, 0, 0, pasien.Jakarta)
+
 	db := client.Database("mydb")
 	collection := db.Collection("reservasi_layanan")
 
 	filter := bson.M{
-		"hariReservasi": tanggal,
+		"hariReservasi": bson.M{"$gte": hari, "$lt": hari.AddDate(0, 0, 1)},
 	}
 
 	cursor, err := collection.Find(context.Background(), filter)
@@ -74,6 +84,10 @@ func GetReservasi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for _, result := range results {
+		result["hariReservasi"] = pasien.FormatDate(result["hariReservasi"])
+	}
+
 	//if not empty return the data
 	jsonData, _ := json.Marshal(map[string]interface{}{"message": "Success", "data": results})
 	w.Write(jsonData)
@@ -2,7 +2,11 @@ module github.com/Kazengan/bidan-backend
 
 go 1.22
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/joho/godotenv v1.5.1
+	go.mongodb.org/mongo-driver v1.15.0
+	golang.org/x/crypto v0.17.0
+)
 
 require (
 	github.com/golang/snappy v0.0.1 // indirect
@@ -12,8 +16,6 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	go.mongodb.org/mongo-driver v1.15.0 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-)
\ No newline at end of file
+)
@@ -7,12 +7,13 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 func Helper(w http.ResponseWriter, r *http.Request) {
@@ -56,7 +57,7 @@ func Helper(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id_pasien_int, err := strconv.Atoi(id_pasien_str)
+	id_pasien_int, err := pasien.ParseID(id_pasien_str)
 	if err != nil {
 		jsonData, _ := json.Marshal(map[string]string{"message": "error converting id_pasien to integer"})
 		w.WriteHeader(http.StatusBadRequest)
@@ -71,9 +72,9 @@ func Helper(w http.ResponseWriter, r *http.Request) {
 	data, ok := dataMap["data"].(map[string]interface{})
 	if !ok || len(data) == 0 {
 		filterData = bson.M{"id_pasien": id_pasien_int}
-		pasien := db.Collection("pasien").FindOne(context.Background(), filterData)
+		pasienResult := db.Collection("pasien").FindOne(context.Background(), filterData)
 
-		if pasien.Err() != nil {
+		if pasienResult.Err() != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "id_pasien tidak ditemukan"})
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(jsonData)
@@ -81,21 +82,30 @@ func Helper(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var pasienData bson.M
-		if err := pasien.Decode(&pasienData); err != nil {
+		if err := pasienResult.Decode(&pasienData); err != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "error decoding data"})
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(jsonData)
 			return
 		}
+		if err := pasien.Decrypt(pasienData); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(jsonData)
+			return
+		}
 
 		returnData := bson.M{
 			"generalInformation": bson.M{
+				"nik":               pasienData["nik"],
+				"noRegister":        pasienData["no_register"],
+				"noJkn":             pasienData["no_jkn"],
 				"noFaskes":          pasienData["data_kb"].(bson.M)["no_faskes"],
 				"noSeriKartu":       pasienData["data_kb"].(bson.M)["no_seri_kartu"],
-				"tglDatang":         pasienData["tanggal_register"],
+				"tglDatang":         pasien.FormatDateTime(pasienData["tanggal_register"]),
 				"namaPeserta":       pasienData["nama_pasien"],
-				"tglLahir":          pasienData["tanggal_lahir"],
-				"usia":              pasienData["umur"],
+				"tglLahir":          pasien.FormatDate(pasienData["tanggal_lahir"]),
+				"usia":              pasien.Umur(pasienData, time.Now()),
 				"namaPasangan":      pasienData["nama_pasangan"],
 				"jenisPasangan":     pasienData["jenis_pasangan"],
 				"pendidikanAkhir":   pasienData["pendidikan"],
@@ -109,13 +119,19 @@ func Helper(w http.ResponseWriter, r *http.Request) {
 			"penapisanKB":      pasienData["data_kb"].(bson.M)["penapisan_kb"],
 		}
 
-		jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": returnData})
+		w.Header().Set("ETag", pasien.ETag(pasien.Version(pasienData)))
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": returnData, "version": pasien.Version(pasienData)})
 		w.WriteHeader(http.StatusOK)
 		w.Write(jsonData)
 		return
 	}
 
 	targetPasien = bson.M{"id_pasien": id_pasien_int}
+	version, err := pasien.IfMatch(r)
+	if err != nil {
+		pasien.WriteUpdateError(w, err)
+		return
+	}
 	dataPasien = bson.M{
 		"tanggal_register":   data["generalInformation"].(map[string]interface{})["tglDatang"],
 		"nama_pasien":        data["generalInformation"].(map[string]interface{})["namaPeserta"],
@@ -137,12 +153,46 @@ func Helper(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	if _, err := db.Collection("pasien").UpdateOne(context.Background(), targetPasien, bson.M{"$set": dataPasien}); err != nil {
-		jsonData, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("error updating data for id_pasien=%d", id_pasien_int)})
-		w.WriteHeader(http.StatusInternalServerError)
+	general, _ := data["generalInformation"].(map[string]interface{})
+	identity, err := pasien.IdentityFields(general)
+	if err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
 		w.Write(jsonData)
 		return
 	}
+	if err := pasien.CheckNIKUnique(context.Background(), db.Collection("pasien"), identity["nik"], id_pasien_int); err != nil {
+		status := http.StatusInternalServerError
+		if err == pasien.ErrNIKExists {
+			status = http.StatusConflict
+		}
+		jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+		w.WriteHeader(status)
+		w.Write(jsonData)
+		return
+	}
+	for key, value := range identity {
+		dataPasien[key] = value
+	}
+	if err := pasien.NormalizeDates(dataPasien); err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonData)
+		return
+	}
+	if err := pasien.NormalizePhones(dataPasien); err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonData)
+		return
+	}
+
+	updated, err := pasien.UpdateVersioned(context.Background(), db.Collection("pasien"), targetPasien, version, bson.M{"$set": dataPasien})
+	if err != nil {
+		pasien.WriteUpdateError(w, err)
+		return
+	}
+	w.Header().Set("ETag", pasien.ETag(pasien.Version(updated)))
 
 	jsonData, _ := json.Marshal(map[string]string{"message": fmt.Sprintf("changed id_pasien=%d data", id_pasien_int)})
 	w.WriteHeader(http.StatusOK)
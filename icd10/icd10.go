@@ -0,0 +1,133 @@
+package icd10
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// data is the bundled master table, see icd10.tsv. To use a fuller list,
+// replace the file with one in the same format; codes already stored in
+// SOAP records keep the title they were written with.
+//
+//go:embed icd10.tsv
+var data string
+
+// Kode is one ICD-10 code with its title.
+type Kode struct {
+	Kode string `bson:"kode" json:"kode"`
+	Nama string `bson:"nama" json:"nama"`
+}
+
+var (
+	loadOnce sync.Once
+	table    []Kode
+	byKode   map[string]Kode
+)
+
+func load() {
+	byKode = map[string]Kode{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kode, nama, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		k := Kode{Kode: Normalize(kode), Nama: strings.TrimSpace(nama)}
+		table = append(table, k)
+		byKode[k.Kode] = k
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i].Kode < table[j].Kode })
+}
+
+// Normalize writes a code the way the table does: upper case with the dot
+// after the category, so "o210" and "O21.0" are the same code.
+func Normalize(kode string) string {
+	kode = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(kode), " ", ""))
+	if len(kode) > 3 && !strings.Contains(kode, ".") {
+		kode = kode[:3] + "." + kode[3:]
+	}
+	return kode
+}
+
+// Lookup returns the code kode is written for, if it is in the table.
+func Lookup(kode string) (Kode, bool) {
+	loadOnce.Do(load)
+	k, ok := byKode[Normalize(kode)]
+	return k, ok
+}
+
+// Search returns up to limit codes matching q: codes starting with it
+// first, then titles containing every word of it.
+func Search(q string, limit int) []Kode {
+	loadOnce.Do(load)
+	q = strings.TrimSpace(q)
+	result := []Kode{}
+	if q == "" {
+		return result
+	}
+
+	prefix := Normalize(q)
+	words := strings.Fields(strings.ToLower(q))
+	var byTitle []Kode
+	for _, k := range table {
+		if strings.HasPrefix(k.Kode, prefix) {
+			result = append(result, k)
+			continue
+		}
+		nama := strings.ToLower(k.Nama)
+		all := true
+		for _, word := range words {
+			if !strings.Contains(nama, word) {
+				all = false
+				break
+			}
+		}
+		if all {
+			byTitle = append(byTitle, k)
+		}
+	}
+	result = append(result, byTitle...)
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Handler serves GET /api/icd10?q=&limit= for the diagnosis picker of the
+// SOAP form. limit defaults to 20 and is at most 100.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithJSON(w, http.StatusMethodNotAllowed, map[string]string{"message": "Method not allowed"})
+		return
+	}
+	limit := 20
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": Search(r.URL.Query().Get("q"), limit)})
+}
@@ -0,0 +1,279 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/sesi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Header is the request header carrying the client-chosen key.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader is set on responses served from a stored result.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// TTL is how long a key and its response are kept.
+const TTL = 24 * time.Hour
+
+// Lease is how long a request holds its key while it runs. A retry that
+// arrives after the lease ran out takes the key over, so a request that
+// crashed or timed out does not block its key for the whole TTL.
+const Lease = 2 * time.Minute
+
+// replayHeaders are the response headers stored with the body and sent
+// again on a replay.
+var replayHeaders = []string{"Content-Type", "ETag", "Location", "Last-Modified"}
+
+const (
+	stateProcessing = "processing"
+	stateDone       = "done"
+	maxKeyLength    = 255
+)
+
+var (
+	indexMu   sync.Mutex
+	indexDone bool
+)
+
+type record struct {
+	ID          string    `bson:"_id"`
+	Path        string    `bson:"path"`
+	Key         string    `bson:"key"`
+	Subject     string    `bson:"subject"`
+	RequestHash string    `bson:"request_hash"`
+	State       string    `bson:"state"`
+	Owner       string    `bson:"owner,omitempty"`
+	LeaseUntil  time.Time `bson:"lease_until,omitempty"`
+	Status      int       `bson:"status,omitempty"`
+	// ContentType is only set on records stored before Headers was.
+	ContentType string            `bson:"content_type,omitempty"`
+	Headers     map[string]string `bson:"headers,omitempty"`
+	Body        []byte            `bson:"body,omitempty"`
+	CreatedAt   time.Time         `bson:"created_at"`
+}
+
+// recorder passes the response through to the client while keeping a copy
+// of it to store under the key.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	if indexDone {
+		return nil
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("created_at_ttl").SetExpireAfterSeconds(int32(TTL.Seconds())),
+	})
+	if err != nil {
+		return err
+	}
+	indexDone = true
+	return nil
+}
+
+// subject is who a key belongs to: the bidan of the session, or anonim for
+// the public endpoints. Two bidan choosing the same key do not share it.
+func subject(r *http.Request) string {
+	if bidan, err := sesi.FromRequest(r); err == nil {
+		return "bidan:" + bidan.ID.Hex()
+	}
+	return "anonim"
+}
+
+// replayable reports whether a response is a result to replay: a success, or a
+// 409 the business rules gave. Other errors, such as 401 for an expired
+// session or 422 for a missing consent, may go away, so the key is
+// released for the client to retry with it.
+func replayable(status int) bool {
+	return status >= 200 && status < 300 || status == http.StatusConflict
+}
+
+// Handle makes a create endpoint safe to retry. When a POST carries an
+// Idempotency-Key, the first request with that key runs next and its
+// response is stored; any later request of the same subject with the same
+// key and body gets the stored response back instead of creating a second
+// record. Reusing a key for a different body is rejected with 422, and a
+// replay that arrives while the first request is still running gets 409
+// until its Lease runs out, after which the replay runs in its place. Only
+// what replayable accepts is kept. Other methods pass straight through.
+func Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key terlalu panjang")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "error reading request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+
+		ctx := context.Background()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error connecting to database")
+			return
+		}
+		defer client.Disconnect(ctx)
+
+		collection := client.Database("mydb").Collection("idempotency_keys")
+		if err := ensureIndexes(ctx, collection); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error creating idempotency index")
+			return
+		}
+
+		who := subject(r)
+		id := r.URL.Path + " " + who + " " + key
+		now := time.Now()
+		claim := record{
+			ID:          id,
+			Path:        r.URL.Path,
+			Key:         key,
+			Subject:     who,
+			RequestHash: hex.EncodeToString(hash[:]),
+			State:       stateProcessing,
+			Owner:       primitive.NewObjectID().Hex(),
+			LeaseUntil:  now.Add(Lease),
+			CreatedAt:   now,
+		}
+		_, err = collection.InsertOne(ctx, claim)
+		if mongo.IsDuplicateKeyError(err) {
+			taken, err := takeOver(ctx, collection, claim)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "error saving Idempotency-Key")
+				return
+			}
+			if !taken {
+				replay(ctx, w, collection, claim)
+				return
+			}
+		} else if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error saving Idempotency-Key")
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next(rec, r)
+
+		// Only the request still holding the key settles it: one whose
+		// lease ran out and was taken over leaves it to the one that took it.
+		owned := bson.M{"_id": id, "owner": claim.Owner}
+		if !replayable(rec.status) {
+			collection.DeleteOne(ctx, owned)
+			return
+		}
+		headers := map[string]string{}
+		for _, name := range replayHeaders {
+			if value := rec.Header().Get(name); value != "" {
+				headers[name] = value
+			}
+		}
+		collection.UpdateOne(ctx, owned, bson.M{"$set": bson.M{
+			"state":   stateDone,
+			"status":  rec.status,
+			"headers": headers,
+			"body":    rec.body.Bytes(),
+		}})
+	}
+}
+
+// takeOver gives claim the key of a request with the same body that is
+// still processing after its lease ran out. It reports whether it did.
+func takeOver(ctx context.Context, collection *mongo.Collection, claim record) (bool, error) {
+	filter := bson.M{
+		"_id":          claim.ID,
+		"request_hash": claim.RequestHash,
+		"state":        stateProcessing,
+		"lease_until":  bson.M{"$lt": claim.CreatedAt},
+	}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"owner":       claim.Owner,
+		"lease_until": claim.LeaseUntil,
+		"created_at":  claim.CreatedAt,
+	}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func replay(ctx context.Context, w http.ResponseWriter, collection *mongo.Collection, claim record) {
+	var stored record
+	err := collection.FindOne(ctx, bson.M{"_id": claim.ID}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		// The first request failed and released the key in the meantime.
+		respondWithError(w, http.StatusConflict, "request dengan Idempotency-Key ini gagal, silakan kirim ulang")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error reading Idempotency-Key")
+		return
+	}
+	if stored.RequestHash != claim.RequestHash {
+		respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key sudah dipakai untuk request yang berbeda")
+		return
+	}
+	if stored.State != stateDone {
+		respondWithError(w, http.StatusConflict, "request dengan Idempotency-Key ini masih diproses")
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	for name, value := range stored.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Kazengan/bidan-backend/sesi"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReplayable(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusOK:                  true,
+		http.StatusCreated:             true,
+		http.StatusConflict:            true,
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusUnprocessableEntity: false,
+		http.StatusInternalServerError: false,
+		0:                              false,
+	} {
+		if got := replayable(status); got != want {
+			t.Errorf("replayable(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestSubjectPerBidan(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "rahasia")
+	request := func(b sesi.Bidan) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/soap", nil)
+		token, err := sesi.Issue(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+	a := subject(request(sesi.Bidan{ID: primitive.NewObjectID(), Username: "a"}))
+	b := subject(request(sesi.Bidan{ID: primitive.NewObjectID(), Username: "b"}))
+	if a == b {
+		t.Errorf("two bidan share the subject %q", a)
+	}
+	if anon := subject(httptest.NewRequest(http.MethodPost, "/api/reservasi", nil)); anon != "anonim" {
+		t.Errorf("subject without a session is %q", anon)
+	}
+}
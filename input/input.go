@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -21,25 +22,6 @@ func connectToDatabase() (*mongo.Client, error) {
 	return client, nil
 }
 
-func getNextPasien(db *mongo.Database) (uint64, error) {
-	collection := db.Collection("pasien_counter")
-	filter := bson.M{}
-	update := bson.M{"$inc": bson.M{"seq_value": 1}}
-	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	var updatedCounter bson.M
-	err := collection.FindOneAndUpdate(context.Background(), filter, update, options).Decode(&updatedCounter)
-	if err != nil {
-		return 0, err
-	}
-
-	sequenceValue, ok := updatedCounter["seq_value"].(int64)
-	if !ok {
-		return 0, fmt.Errorf("sequence_value is not of type int64, sequence_value: %v, sequence_value type: %T", updatedCounter["sequence_value"], updatedCounter["sequence_value"])
-	}
-
-	return uint64(sequenceValue), nil
-}
-
 func respondWithError(w http.ResponseWriter, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	jsonData, _ := json.Marshal(map[string]string{"message": message})
@@ -82,9 +64,18 @@ func Input(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	nextIDPasien, err := getNextPasien(db)
+	general, _ := data["generalInformation"].(map[string]interface{})
+	identity, err := pasien.IdentityFields(general)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("error getting next pasien id: %v", err))
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := pasien.CheckNIKUnique(context.Background(), collection, identity["nik"], nil); err != nil {
+		if err == pasien.ErrNIKExists {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "error checking NIK")
 		return
 	}
 
@@ -93,7 +84,6 @@ func Input(w http.ResponseWriter, r *http.Request) {
 	switch idLayananInt {
 	case 0:
 		dataPasien = bson.M{
-			"id_pasien":          nextIDPasien,
 			"tanggal_register":   data["generalInformation"].(map[string]interface{})["tglDatang"],
 			"nama_pasien":        data["generalInformation"].(map[string]interface{})["namaPeserta"],
 			"tanggal_lahir":      data["generalInformation"].(map[string]interface{})["tglLahir"],
@@ -117,7 +107,6 @@ func Input(w http.ResponseWriter, r *http.Request) {
 
 	case 1:
 		dataPasien = bson.M{
-			"id_pasien":        nextIDPasien,
 			"tanggal_register": data["generalInformation"].(map[string]interface{})["tanggalRegister"],
 			"nama_pasien":      data["generalInformation"].(map[string]interface{})["namaLengkap"],
 			"tanggal_lahir":    data["generalInformation"].(map[string]interface{})["tanggalLahir"],
@@ -127,43 +116,45 @@ func Input(w http.ResponseWriter, r *http.Request) {
 			"alamat":           data["generalInformation"].(map[string]interface{})["alamatDomisili"],
 			"no_hp":            data["section2"].(map[string]interface{})["noTelp"],
 			"data_kehamilan": bson.M{
-				"pekerjaan":                   data["generalInformation"].(map[string]interface{})["pekerjaan"],
-				"agama":                       data["generalInformation"].(map[string]interface{})["agama"],
-				"desa":                        data["generalInformation"].(map[string]interface{})["desa"],
-				"kabupaten":                   data["generalInformation"].(map[string]interface{})["kabupaten"],
-				"kecamatan":                   data["generalInformation"].(map[string]interface{})["kecamatan"],
-				"provinsi":                    data["generalInformation"].(map[string]interface{})["provinsi"],
-				"rtrw":                        data["generalInformation"].(map[string]interface{})["rtrw"],
-				"no_ibu":                      data["generalInformation"].(map[string]interface{})["noIbu"],
-				"kunjungan_nifas":             data["kunjunganNifas"],
-				"faktor_resiko_resiko_tinggi": data["mendeteksiFaktorResikoDanResikoTinggi"],
-				"pemeriksaan_pnc":             data["pemeriksaanPNC"],
-				"persalinan":                  data["persalinan"],
-				"rencana_persalinan":          data["rencanaPersalinan"],
-				"riwayat_kehamilan":           data["riwayatKehamilan"],
-				"skrining_tt":                 data["skriningTT"],
-				"section2":                    data["section2"],
+				"pekerjaan":                       data["generalInformation"].(map[string]interface{})["pekerjaan"],
+				"agama":                           data["generalInformation"].(map[string]interface{})["agama"],
+				"desa":                            data["generalInformation"].(map[string]interface{})["desa"],
+				"kabupaten":                       data["generalInformation"].(map[string]interface{})["kabupaten"],
+				"kecamatan":                       data["generalInformation"].(map[string]interface{})["kecamatan"],
+				"provinsi":                        data["generalInformation"].(map[string]interface{})["provinsi"],
+				"rtrw":                            data["generalInformation"].(map[string]interface{})["rtrw"],
+				"no_ibu":                          data["generalInformation"].(map[string]interface{})["noIbu"],
+				"kunjungan_nifas":                 data["kunjunganNifas"],
+				"faktor_risiko_dan_risiko_tinggi": data["mendeteksiFaktorResikoDanResikoTinggi"],
+				"pemeriksaan_pnc":                 data["pemeriksaanPNC"],
+				"persalinan":                      data["persalinan"],
+				"rencana_persalinan":              data["rencanaPersalinan"],
+				"riwayat_kehamilan":               data["riwayatKehamilan"],
+				"skrining_tt":                     data["skriningTT"],
+				"section2":                        data["section2"],
 			},
 		}
 	case 2:
 		dataPasien = bson.M{
-			"tanggal_register": data["generalInformation"].(map[string]interface{})["tglDatang"],
-			"id_pasien":        nextIDPasien,
-			"nomor_bayi":       data["generalInformation"].(map[string]interface{})["nomorBayi"],
-			"nomor":            data["generalInformation"].(map[string]interface{})["nomor"],
-			"nama_pasien":      data["generalInformation"].(map[string]interface{})["namaBayi"],
-			"nama_ayah":        data["generalInformation"].(map[string]interface{})["namaAyah"],
-			"umur_ayah":        data["generalInformation"].(map[string]interface{})["usiaAyah"],
-			"nama_ibu":         data["generalInformation"].(map[string]interface{})["namaIbu"],
-			"umur_ibu":         data["generalInformation"].(map[string]interface{})["usiaIbu"],
-			"puskesmas":        data["generalInformation"].(map[string]interface{})["puskesmas"],
-			"bidan":            data["generalInformation"].(map[string]interface{})["bidan"],
-			"alamat":           data["generalInformation"].(map[string]interface{})["alamat"],
-			"desa":             data["generalInformation"].(map[string]interface{})["desa"],
-			"kecamatan":        data["generalInformation"].(map[string]interface{})["kecamatan"],
-			"kabupaten":        data["generalInformation"].(map[string]interface{})["kabupaten"],
-			"provinsi":         data["generalInformation"].(map[string]interface{})["provinsi"],
-			"no_hp":            data["generalInformation"].(map[string]interface{})["noHP"],
+			"tanggal_register":   data["generalInformation"].(map[string]interface{})["tglDatang"],
+			"nomor_bayi":         data["generalInformation"].(map[string]interface{})["nomorBayi"],
+			"nomor":              data["generalInformation"].(map[string]interface{})["nomor"],
+			"nama_pasien":        data["generalInformation"].(map[string]interface{})["namaBayi"],
+			"tanggal_lahir":      data["generalInformation"].(map[string]interface{})["tglLahir"],
+			"nama_ayah":          data["generalInformation"].(map[string]interface{})["namaAyah"],
+			"umur_ayah":          data["generalInformation"].(map[string]interface{})["usiaAyah"],
+			"tanggal_lahir_ayah": data["generalInformation"].(map[string]interface{})["tglLahirAyah"],
+			"nama_ibu":           data["generalInformation"].(map[string]interface{})["namaIbu"],
+			"umur_ibu":           data["generalInformation"].(map[string]interface{})["usiaIbu"],
+			"tanggal_lahir_ibu":  data["generalInformation"].(map[string]interface{})["tglLahirIbu"],
+			"puskesmas":          data["generalInformation"].(map[string]interface{})["puskesmas"],
+			"bidan":              data["generalInformation"].(map[string]interface{})["bidan"],
+			"alamat":             data["generalInformation"].(map[string]interface{})["alamat"],
+			"desa":               data["generalInformation"].(map[string]interface{})["desa"],
+			"kecamatan":          data["generalInformation"].(map[string]interface{})["kecamatan"],
+			"kabupaten":          data["generalInformation"].(map[string]interface{})["kabupaten"],
+			"provinsi":           data["generalInformation"].(map[string]interface{})["provinsi"],
+			"no_hp":              data["generalInformation"].(map[string]interface{})["noHP"],
 			"data_imunisasi": bson.M{
 				"detail_bayi":                   data["detailBayi"],
 				"pemeriksaan_neonatus":          data["pemeriksaanNeonatus"],
@@ -176,15 +167,34 @@ func Input(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = collection.InsertOne(context.Background(), dataPasien)
+	for key, value := range identity {
+		dataPasien[key] = value
+	}
+	if err := pasien.NormalizeDates(dataPasien); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := pasien.NormalizePhones(dataPasien); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dataPasien["search"] = pasien.SearchFields(dataPasien)
+	dataPasien[pasien.VersionField] = int64(1)
+
+	nextIDPasien, err := pasien.Insert(context.Background(), db, int(idLayananInt), dataPasien)
+	if mongo.IsDuplicateKeyError(err) {
+		respondWithError(w, http.StatusConflict, pasien.ErrNIKExists.Error())
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "error inserting data into database")
 		return
 	}
 
 	response := map[string]interface{}{
-		"message": "success",
-		"id":      nextIDPasien,
+		"message":     "success",
+		"id":          nextIDPasien,
+		"no_register": dataPasien[pasien.RegisterField],
 	}
 
 	jsonData, err := json.Marshal(response)
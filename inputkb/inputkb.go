@@ -3,35 +3,16 @@ package inputkb
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func getNextPasien(db *mongo.Database) (uint64, error) {
-	collection := db.Collection("pasien_counter")
-	filter := bson.M{}
-	update := bson.M{"$inc": bson.M{"seq_value": 1}}
-	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	var updatedCounter bson.M
-	err := collection.FindOneAndUpdate(context.Background(), filter, update, options).Decode(&updatedCounter)
-	if err != nil {
-		return 0, err
-	}
-
-	sequenceValue, ok := updatedCounter["seq_value"].(int64)
-	if !ok {
-		return 0, fmt.Errorf("sequence_value is not of type int64, sequence_value: %v, sequence_value type: %T", updatedCounter["sequence_value"], updatedCounter["sequence_value"])
-	}
-
-	return uint64(sequenceValue), nil
-}
-
 func InputKB(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	err := godotenv.Load()
@@ -75,16 +56,26 @@ func InputKB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	next_id_pasien, err := getNextPasien(db)
+	general, _ := data["generalInformation"].(map[string]interface{})
+	identity, err := pasien.IdentityFields(general)
 	if err != nil {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error() + "\naaaaaaaa"))
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonData)
+		return
+	}
+	if err := pasien.CheckNIKUnique(context.Background(), collection, identity["nik"], nil); err != nil {
+		status := http.StatusInternalServerError
+		if err == pasien.ErrNIKExists {
+			status = http.StatusConflict
+		}
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+		w.WriteHeader(status)
+		w.Write(jsonData)
 		return
 	}
 
 	dataPasien := bson.M{
-		"id_pasien":          next_id_pasien,
 		"tanggal_register":   data["generalInformation"].(map[string]interface{})["tglDatang"],
 		"nama_pasien":        data["generalInformation"].(map[string]interface{})["namaPeserta"],
 		"tanggal_lahir":      data["generalInformation"].(map[string]interface{})["tglLahir"],
@@ -105,14 +96,39 @@ func InputKB(w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	if _, err := collection.InsertOne(context.Background(), dataPasien); err != nil {
+	for key, value := range identity {
+		dataPasien[key] = value
+	}
+	if err := pasien.NormalizeDates(dataPasien); err != nil {
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonData)
+		return
+	}
+	if err := pasien.NormalizePhones(dataPasien); err != nil {
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonData)
+		return
+	}
+	dataPasien["search"] = pasien.SearchFields(dataPasien)
+	dataPasien[pasien.VersionField] = int64(1)
+
+	next_id_pasien, err := pasien.Insert(context.Background(), db, pasien.LayananKB, dataPasien)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			jsonData, _ := json.Marshal(map[string]interface{}{"message": pasien.ErrNIKExists.Error()})
+			w.WriteHeader(http.StatusConflict)
+			w.Write(jsonData)
+			return
+		}
 		jsonData, _ := json.Marshal(map[string]interface{}{"message": "error inserting data"})
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write(jsonData)
 		return
 	}
 
-	jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "id_pasien": next_id_pasien})
+	jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "id_pasien": next_id_pasien, "no_register": dataPasien[pasien.RegisterField]})
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonData)
 
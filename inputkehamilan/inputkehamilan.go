@@ -7,31 +7,13 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func getNextPasien(db *mongo.Database) (uint64, error) {
-	collection := db.Collection("pasien_counter")
-	filter := bson.M{}
-	update := bson.M{"$inc": bson.M{"seq_value": 1}}
-	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	var updatedCounter bson.M
-	err := collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&updatedCounter)
-	if err != nil {
-		return 0, err
-	}
-
-	sequenceValue, ok := updatedCounter["seq_value"].(int64)
-	if !ok {
-		return 0, fmt.Errorf("sequence_value is not of type int64, sequence_value: %v, sequence_value type: %T", updatedCounter["seq_value"], updatedCounter["seq_value"])
-	}
-
-	return uint64(sequenceValue), nil
-}
-
 func InputKehamilan(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -66,47 +48,74 @@ func InputKehamilan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	nextIDPasien, err := getNextPasien(db)
+	general, _ := data["generalInformation"].(map[string]interface{})
+	identity, err := pasien.IdentityFields(general)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"message": "error getting next pasien id: %v"}`, err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf(`{"message": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := pasien.CheckNIKUnique(context.Background(), collection, identity["nik"], nil); err != nil {
+		if err == pasien.ErrNIKExists {
+			http.Error(w, fmt.Sprintf(`{"message": %q}`, err.Error()), http.StatusConflict)
+			return
+		}
+		http.Error(w, `{"message": "error checking NIK"}`, http.StatusInternalServerError)
 		return
 	}
 
 	dataPasien := bson.M{
-		"id_pasien":          nextIDPasien,
-		"tanggal_register":   data["generalInformation"].(map[string]interface{})["tanggalRegister"],
-		"nama_pasien":        data["generalInformation"].(map[string]interface{})["namaLengkap"],
-		"tanggal_lahir":      data["generalInformation"].(map[string]interface{})["tanggalLahir"],
-		"umur":               data["generalInformation"].(map[string]interface{})["umur"],
-		"nama_pasangan":      data["generalInformation"].(map[string]interface{})["namaSuami"],
-		"pendidikan":         data["generalInformation"].(map[string]interface{})["pendidikan"],
-		"alamat":             data["generalInformation"].(map[string]interface{})["alamatDomisili"],
+		"tanggal_register": data["generalInformation"].(map[string]interface{})["tanggalRegister"],
+		"nama_pasien":      data["generalInformation"].(map[string]interface{})["namaLengkap"],
+		"tanggal_lahir":    data["generalInformation"].(map[string]interface{})["tanggalLahir"],
+		"umur":             data["generalInformation"].(map[string]interface{})["umur"],
+		"nama_pasangan":    data["generalInformation"].(map[string]interface{})["namaSuami"],
+		"pendidikan":       data["generalInformation"].(map[string]interface{})["pendidikan"],
+		"alamat":           data["generalInformation"].(map[string]interface{})["alamatDomisili"],
 		"data_kehamilan": bson.M{
-			"pekerjaan":                   data["generalInformation"].(map[string]interface{})["pekerjaan"],
-			"agama":                       data["generalInformation"].(map[string]interface{})["agama"],
-			"desa":                        data["generalInformation"].(map[string]interface{})["desa"],
-			"kabupaten":                   data["generalInformation"].(map[string]interface{})["kabupaten"],
-			"kecamatan":                   data["generalInformation"].(map[string]interface{})["kecamatan"],
-			"provinsi":                    data["generalInformation"].(map[string]interface{})["provinsi"],
-			"rtrw":                        data["generalInformation"].(map[string]interface{})["rtrw"],
-			"no_ibu":                      data["generalInformation"].(map[string]interface{})["noIbu"],
-			"kunjungan_nifas":             data["kunjunganNifas"],
-			"faktor_resiko_resiko_tinggi": data["mendeteksiFaktorResikoDanResikoTinggi"],
-			"pemeriksaan_pnc":             data["pemeriksaanPNC"],
-			"persalinan":                  data["persalinan"],
-			"rencana_persalinan":          data["rencanaPersalinan"],
-			"riwayat_kehamilan":           data["riwayatKehamilan"],
-			"skrining_tt":                 data["skriningTT"],
-			"section2":                    data["section2"],
+			"pekerjaan":                       data["generalInformation"].(map[string]interface{})["pekerjaan"],
+			"agama":                           data["generalInformation"].(map[string]interface{})["agama"],
+			"desa":                            data["generalInformation"].(map[string]interface{})["desa"],
+			"kabupaten":                       data["generalInformation"].(map[string]interface{})["kabupaten"],
+			"kecamatan":                       data["generalInformation"].(map[string]interface{})["kecamatan"],
+			"provinsi":                        data["generalInformation"].(map[string]interface{})["provinsi"],
+			"rtrw":                            data["generalInformation"].(map[string]interface{})["rtrw"],
+			"no_ibu":                          data["generalInformation"].(map[string]interface{})["noIbu"],
+			"kunjungan_nifas":                 data["kunjunganNifas"],
+			"faktor_risiko_dan_risiko_tinggi": data["mendeteksiFaktorResikoDanResikoTinggi"],
+			"pemeriksaan_pnc":                 data["pemeriksaanPNC"],
+			"persalinan":                      data["persalinan"],
+			"rencana_persalinan":              data["rencanaPersalinan"],
+			"riwayat_kehamilan":               data["riwayatKehamilan"],
+			"skrining_tt":                     data["skriningTT"],
+			"section2":                        data["section2"],
 		},
 	}
 
-	if _, err := collection.InsertOne(context.Background(), dataPasien); err != nil {
+	for key, value := range identity {
+		dataPasien[key] = value
+	}
+	if err := pasien.NormalizeDates(dataPasien); err != nil {
+		http.Error(w, fmt.Sprintf(`{"message": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err := pasien.NormalizePhones(dataPasien); err != nil {
+		http.Error(w, fmt.Sprintf(`{"message": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	dataPasien["search"] = pasien.SearchFields(dataPasien)
+	dataPasien[pasien.VersionField] = int64(1)
+
+	nextIDPasien, err := pasien.Insert(context.Background(), db, pasien.LayananKehamilan, dataPasien)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, fmt.Sprintf(`{"message": %q}`, pasien.ErrNIKExists.Error()), http.StatusConflict)
+			return
+		}
 		http.Error(w, `{"message": "error inserting data"}`, http.StatusInternalServerError)
 		return
 	}
 
-	response := map[string]interface{}{"message": "success", "id_pasien": nextIDPasien}
+	response := map[string]interface{}{"message": "success", "id_pasien": nextIDPasien, "no_register": dataPasien[pasien.RegisterField]}
 	jsonData, _ := json.Marshal(response)
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonData)
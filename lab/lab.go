@@ -0,0 +1,531 @@
+package lab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one document per result of one test.
+const Collection = "hasil_lab"
+
+// Pemeriksaan is a laboratory test. A numeric test has a unit, a plausible
+// range outside which a value is a typing mistake, and a normal range
+// (NormalMin or NormalMax may be nil for a one-sided range). A qualitative
+// test has the values it can take and which of them are normal. The
+// results of a Rahasia test are encrypted like the protected pasien fields.
+type Pemeriksaan struct {
+	Kode      string   `json:"kode"`
+	Nama      string   `json:"nama"`
+	Satuan    string   `json:"satuan,omitempty"`
+	Min       float64  `json:"-"`
+	Max       float64  `json:"-"`
+	NormalMin *float64 `json:"normal_min,omitempty"`
+	NormalMax *float64 `json:"normal_max,omitempty"`
+	Pilihan   []string `json:"pilihan,omitempty"`
+	Normal    []string `json:"normal,omitempty"`
+	Rahasia   bool     `json:"rahasia,omitempty"`
+}
+
+func batas(v float64) *float64 {
+	return &v
+}
+
+// Katalog lists the antenatal tests. The normal ranges are those for a
+// pregnant woman: anaemia below Hb 11 g/dL, gestational diabetes from a
+// fasting glucose of 92 mg/dL or a random glucose of 200 mg/dL.
+var Katalog = []Pemeriksaan{
+	{Kode: "hb", Nama: "Hemoglobin", Satuan: "g/dL", Min: 3, Max: 25, NormalMin: batas(11)},
+	{Kode: "golongan_darah", Nama: "Golongan darah", Pilihan: []string{"A", "B", "AB", "O"}, Normal: []string{"A", "B", "AB", "O"}},
+	{Kode: "rhesus", Nama: "Rhesus", Pilihan: []string{"positif", "negatif"}, Normal: []string{"positif"}},
+	{Kode: "protein_urine", Nama: "Protein urine", Pilihan: []string{"negatif", "+1", "+2", "+3", "+4"}, Normal: []string{"negatif"}},
+	{Kode: "glukosa_sewaktu", Nama: "Gula darah sewaktu", Satuan: "mg/dL", Min: 20, Max: 800, NormalMin: batas(70), NormalMax: batas(199)},
+	{Kode: "glukosa_puasa", Nama: "Gula darah puasa", Satuan: "mg/dL", Min: 20, Max: 600, NormalMin: batas(70), NormalMax: batas(91)},
+	{Kode: "hiv", Nama: "HIV", Pilihan: []string{"non reaktif", "reaktif"}, Normal: []string{"non reaktif"}, Rahasia: true},
+	{Kode: "sifilis", Nama: "Sifilis", Pilihan: []string{"non reaktif", "reaktif"}, Normal: []string{"non reaktif"}, Rahasia: true},
+	{Kode: "hbsag", Nama: "HBsAg", Pilihan: []string{"non reaktif", "reaktif"}, Normal: []string{"non reaktif"}, Rahasia: true},
+}
+
+// pilihanAlias maps other ways the labs write a qualitative result, in
+// lower case, to the value of Pilihan.
+var pilihanAlias = map[string]string{
+	"nonreaktif": "non reaktif",
+	"nr":         "non reaktif",
+	"r":          "reaktif",
+	"neg":        "negatif",
+	"-":          "negatif",
+	"pos":        "positif",
+	"+":          "positif",
+	"1+":         "+1",
+	"2+":         "+2",
+	"3+":         "+3",
+	"4+":         "+4",
+}
+
+func compact(s string) string {
+	return strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(strings.TrimSpace(s)))
+}
+
+// Find returns the test with kode.
+func Find(kode string) (Pemeriksaan, bool) {
+	for _, p := range Katalog {
+		if p.Kode == strings.ToLower(strings.TrimSpace(kode)) {
+			return p, true
+		}
+	}
+	return Pemeriksaan{}, false
+}
+
+// Rujukan is the reference range of p as it is printed.
+func (p Pemeriksaan) Rujukan() string {
+	switch {
+	case p.Pilihan != nil:
+		return strings.Join(p.Normal, " / ")
+	case p.NormalMin != nil && p.NormalMax != nil:
+		return fmt.Sprintf("%v-%v %s", *p.NormalMin, *p.NormalMax, p.Satuan)
+	case p.NormalMin != nil:
+		return fmt.Sprintf(">= %v %s", *p.NormalMin, p.Satuan)
+	case p.NormalMax != nil:
+		return fmt.Sprintf("<= %v %s", *p.NormalMax, p.Satuan)
+	}
+	return ""
+}
+
+// Hasil is the result of one test of a patient, optionally taken at a SOAP
+// visit. Numeric results are in Angka, qualitative ones in Teks. Abnormal
+// is set when the result is outside Rujukan.
+type Hasil struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id_hasil_lab"`
+	IDPasien    pasien.ID           `bson:"id_pasien" json:"id_pasien"`
+	IDSoap      *primitive.ObjectID `bson:"id_soap,omitempty" json:"id_soap,omitempty"`
+	Tanggal     time.Time           `bson:"tanggal" json:"tanggal"`
+	Pemeriksaan string              `bson:"pemeriksaan" json:"pemeriksaan"`
+	Nama        string              `bson:"nama" json:"nama"`
+	Angka       *float64            `bson:"angka,omitempty" json:"angka,omitempty"`
+	Teks        string              `bson:"teks,omitempty" json:"teks,omitempty"`
+	Satuan      string              `bson:"satuan,omitempty" json:"satuan,omitempty"`
+	Rujukan     string              `bson:"rujukan" json:"rujukan"`
+	Abnormal    bool                `bson:"abnormal" json:"abnormal"`
+	Keterangan  string              `bson:"keterangan,omitempty" json:"keterangan,omitempty"`
+	Penulis     *sesi.Bidan         `bson:"penulis,omitempty" json:"penulis,omitempty"`
+	DibuatPada  time.Time           `bson:"dibuat_pada" json:"dibuat_pada"`
+	Rahasia     string              `bson:"rahasia,omitempty" json:"-"`
+}
+
+// nilaiRahasia is the part of the result of a Rahasia test that is stored
+// encrypted. Abnormal is in it because it alone tells a reactive result.
+type nilaiRahasia struct {
+	Angka    *float64 `bson:"angka,omitempty"`
+	Teks     string   `bson:"teks,omitempty"`
+	Abnormal bool     `bson:"abnormal"`
+}
+
+// rahasiaPath binds the ciphertext to its own document, so it cannot be
+// copied to another patient's result.
+func (h Hasil) rahasiaPath() string {
+	return Collection + "." + h.ID.Hex() + ".rahasia"
+}
+
+// Seal returns h as it is stored: for a Rahasia test the value and the flag
+// are moved into the encrypted Rahasia. With encryption off, or when h is
+// already sealed, h is returned as it is. h must have its ID.
+func (h Hasil) Seal() (Hasil, error) {
+	p, ok := Find(h.Pemeriksaan)
+	if !ok || !p.Rahasia || h.Rahasia != "" {
+		return h, nil
+	}
+	sealed, ok, err := pasien.Encrypt(h.rahasiaPath(), nilaiRahasia{Angka: h.Angka, Teks: h.Teks, Abnormal: h.Abnormal})
+	if err != nil || !ok {
+		return h, err
+	}
+	h.Angka, h.Teks, h.Abnormal, h.Rahasia = nil, "", false, sealed
+	return h, nil
+}
+
+// Open returns h with the value and flag of a sealed result decrypted.
+func (h Hasil) Open() (Hasil, error) {
+	if h.Rahasia == "" {
+		return h, nil
+	}
+	value, err := pasien.DecryptValue(h.rahasiaPath(), h.Rahasia)
+	if err != nil {
+		return h, err
+	}
+	raw, err := bson.Marshal(value)
+	if err != nil {
+		return h, err
+	}
+	var nilai nilaiRahasia
+	if err := bson.Unmarshal(raw, &nilai); err != nil {
+		return h, err
+	}
+	h.Angka, h.Teks, h.Abnormal, h.Rahasia = nilai.Angka, nilai.Teks, nilai.Abnormal, ""
+	return h, nil
+}
+
+// observasi is the result as seen by the danger-sign rules.
+func (h Hasil) observasi() peringatan.Observasi {
+	o := peringatan.Observasi{
+		IDPasien: h.IDPasien,
+		Sumber:   peringatan.SumberLab,
+		IDSumber: h.ID,
+		Tanggal:  h.Tanggal,
+		Angka:    map[string]float64{},
+		Teks:     map[string]string{},
+	}
+	if h.Angka != nil {
+		o.Angka[h.Pemeriksaan] = *h.Angka
+	} else {
+		o.Teks[h.Pemeriksaan] = h.Teks
+	}
+	return o
+}
+
+// nilai sets the result of h from value, a number for a numeric test or
+// one of the values of a qualitative one, and flags it.
+func (h *Hasil) nilai(p Pemeriksaan, value interface{}) error {
+	h.Pemeriksaan, h.Nama, h.Satuan, h.Rujukan = p.Kode, p.Nama, p.Satuan, p.Rujukan()
+
+	if p.Pilihan != nil {
+		s, _ := value.(string)
+		s = strings.ToLower(strings.TrimSpace(s))
+		if alias, ok := pilihanAlias[s]; ok {
+			s = alias
+		} else if alias, ok := pilihanAlias[compact(s)]; ok {
+			s = alias
+		}
+		key := compact(s)
+		for _, pilihan := range p.Pilihan {
+			if compact(pilihan) == key {
+				h.Teks = pilihan
+				h.Abnormal = !contains(p.Normal, pilihan)
+				return nil
+			}
+		}
+		return fmt.Errorf("%s harus salah satu dari %s", p.Kode, strings.Join(p.Pilihan, ", "))
+	}
+
+	var number float64
+	switch v := value.(type) {
+	case float64:
+		number = v
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(strings.ReplaceAll(v, ",", ".")), 64)
+		if err != nil {
+			return fmt.Errorf("%s %q bukan angka", p.Kode, v)
+		}
+		number = n
+	default:
+		return fmt.Errorf("%s harus berupa angka", p.Kode)
+	}
+	if math.IsNaN(number) || number < p.Min || number > p.Max {
+		return fmt.Errorf("%s %v di luar rentang %v-%v %s", p.Kode, number, p.Min, p.Max, p.Satuan)
+	}
+	h.Angka = &number
+	h.Abnormal = (p.NormalMin != nil && number < *p.NormalMin) || (p.NormalMax != nil && number > *p.NormalMax)
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// request is the body of a POST: the results of one sample, taken on
+// tanggal and optionally at the SOAP visit id_soap.
+type request struct {
+	IDPasien   pasien.ID   `json:"id_pasien"`
+	IDSoap     string      `json:"id_soap"`
+	Tanggal    interface{} `json:"tanggal"`
+	Keterangan string      `json:"keterangan"`
+	Hasil      []struct {
+		Pemeriksaan string      `json:"pemeriksaan"`
+		Nilai       interface{} `json:"nilai"`
+		Keterangan  string      `json:"keterangan"`
+	} `json:"hasil"`
+}
+
+// validate returns the results of req, each one without its ID.
+func (req request) validate() ([]Hasil, error) {
+	var problems []string
+	var base Hasil
+	base.IDPasien = req.IDPasien
+	if req.IDPasien <= 0 {
+		problems = append(problems, "id_pasien tidak valid")
+	}
+	if req.IDSoap != "" {
+		id, err := primitive.ObjectIDFromHex(req.IDSoap)
+		if err != nil {
+			problems = append(problems, "id_soap tidak valid")
+		}
+		base.IDSoap = &id
+	}
+	if t, ok := pasien.AsTime(req.Tanggal); ok {
+		base.Tanggal = t
+	} else {
+		problems = append(problems, "tanggal needed")
+	}
+	if len(req.Hasil) == 0 {
+		problems = append(problems, "hasil needed")
+	}
+
+	var results []Hasil
+	seen := map[string]bool{}
+	for _, item := range req.Hasil {
+		p, ok := Find(item.Pemeriksaan)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("pemeriksaan %q tidak dikenal", item.Pemeriksaan))
+			continue
+		}
+		if seen[p.Kode] {
+			problems = append(problems, fmt.Sprintf("%s diisi lebih dari sekali", p.Kode))
+			continue
+		}
+		seen[p.Kode] = true
+		h := base
+		if err := h.nilai(p, item.Nilai); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		h.Keterangan = strings.TrimSpace(item.Keterangan)
+		if h.Keterangan == "" {
+			h.Keterangan = strings.TrimSpace(req.Keterangan)
+		}
+		results = append(results, h)
+	}
+	if len(problems) > 0 {
+		return nil, soap.InvalidError(problems)
+	}
+	return results, nil
+}
+
+// ForPasien returns the results of the patients in ids by patient, oldest
+// first. With pemeriksaan set only that test is returned, which is the
+// trend of it.
+func ForPasien(ctx context.Context, db *mongo.Database, ids []pasien.ID, pemeriksaan string) (map[pasien.ID][]Hasil, error) {
+	filter := bson.M{"id_pasien": bson.M{"$in": ids}}
+	if pemeriksaan != "" {
+		filter["pemeriksaan"] = pemeriksaan
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "tanggal", Value: 1}, {Key: "dibuat_pada", Value: 1}})
+	cursor, err := db.Collection(Collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var list []Hasil
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	byPasien := map[pasien.ID][]Hasil{}
+	for _, h := range list {
+		h, err := h.Open()
+		if err != nil {
+			return nil, err
+		}
+		byPasien[h.IDPasien] = append(byPasien[h.IDPasien], h)
+	}
+	return byPasien, nil
+}
+
+// Terakhir returns the latest result of every test in results, which must
+// be oldest first, keyed by test.
+func Terakhir(results []Hasil) map[string]Hasil {
+	latest := map[string]Hasil{}
+	for _, h := range results {
+		latest[h.Pemeriksaan] = h
+	}
+	return latest
+}
+
+// DeleteForPasien deletes every result of a patient, for when the patient
+// is deleted or anonymised.
+func DeleteForPasien(ctx context.Context, db *mongo.Database, idPasien interface{}) error {
+	_, err := db.Collection(Collection).DeleteMany(ctx, bson.M{"id_pasien": idPasien})
+	return err
+}
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Handler serves /api/lab. GET ?id_pasien= lists a patient's results
+// oldest first with the latest of every test; &pemeriksaan= gives the
+// trend of one test. GET without id_pasien returns the test catalogue.
+// POST records the results of one sample, DELETE ?id_hasil_lab= removes a
+// result entered by mistake. Everything but the catalogue needs the
+// bidan's token.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Query().Get("id_pasien") == "" {
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": Katalog})
+		return
+	}
+
+	// The results include HIV, syphilis and hepatitis B, so reading them
+	// needs the bidan's token as much as writing them.
+	bidan, err := sesi.FromRequest(r)
+	if err != nil {
+		respondWithError(w, soap.Status(err), err.Error())
+		return
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	switch r.Method {
+	case http.MethodGet:
+		idPasien, err := pasien.ParseID(r.URL.Query().Get("id_pasien"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+			return
+		}
+		pemeriksaan := r.URL.Query().Get("pemeriksaan")
+		if pemeriksaan != "" {
+			p, ok := Find(pemeriksaan)
+			if !ok {
+				respondWithError(w, http.StatusBadRequest, "invalid pemeriksaan")
+				return
+			}
+			pemeriksaan = p.Kode
+		}
+		byPasien, err := ForPasien(r.Context(), db, []pasien.ID{idPasien}, pemeriksaan)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error executing query")
+			return
+		}
+		results := byPasien[idPasien]
+		if results == nil {
+			results = []Hasil{}
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": results, "terakhir": Terakhir(results)})
+
+	case http.MethodPost:
+		create(w, r, db, bidan)
+
+	case http.MethodDelete:
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id_hasil_lab"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_hasil_lab")
+			return
+		}
+		result, err := db.Collection(Collection).DeleteOne(r.Context(), bson.M{"_id": id})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error deleting data")
+			return
+		}
+		if result.DeletedCount == 0 {
+			respondWithError(w, http.StatusNotFound, "hasil lab tidak ditemukan")
+			return
+		}
+		if err := peringatan.Close(r.Context(), db, id, bidan); err != nil {
+			log.Printf("peringatan: lab %s: %v", id.Hex(), err)
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Delete successful"})
+
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func create(w http.ResponseWriter, r *http.Request, db *mongo.Database, bidan sesi.Bidan) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	results, err := req.validate()
+	if err != nil {
+		respondWithError(w, soap.Status(err), err.Error())
+		return
+	}
+
+	count, err := db.Collection("pasien").CountDocuments(r.Context(), bson.M{"id_pasien": req.IDPasien})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error finding pasien")
+		return
+	}
+	if count == 0 {
+		respondWithError(w, http.StatusNotFound, "id_pasien tidak ditemukan")
+		return
+	}
+	if id := results[0].IDSoap; id != nil {
+		visit, err := soap.Find(r.Context(), db, *id)
+		if errors.Is(err, soap.ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "id_soap tidak ditemukan")
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "error finding soap")
+			return
+		}
+		if visit.IDPasien != req.IDPasien {
+			respondWithError(w, http.StatusBadRequest, "id_soap bukan kunjungan pasien ini")
+			return
+		}
+	}
+
+	now := time.Now()
+	docs := make([]interface{}, len(results))
+	for i := range results {
+		results[i].ID = primitive.NewObjectID()
+		results[i].Penulis = &bidan
+		results[i].DibuatPada = now
+		sealed, err := results[i].Seal()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error encrypting data")
+			return
+		}
+		docs[i] = sealed
+	}
+	if _, err := db.Collection(Collection).InsertMany(r.Context(), docs); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error inserting data to database")
+		return
+	}
+	for _, h := range results {
+		peringatan.Raise(r.Context(), db, h.observasi())
+	}
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{"message": "success", "data": results})
+}
@@ -0,0 +1,396 @@
+package lampiran
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one metadata document per attachment.
+const Collection = "lampiran"
+
+// AllowedTypes maps the content types accepted for upload to the extension
+// the file is stored with. The type is sniffed from the content, the one
+// sent by the client is ignored.
+var AllowedTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/pdf": ".pdf",
+}
+
+// defaultMaxSize is the upload limit when LAMPIRAN_MAX_SIZE (bytes) is not
+// set. A phone photo of a Buku KIA page is 2-5 MB.
+const defaultMaxSize = 10 << 20
+
+// Lampiran is the metadata of one attachment.
+type Lampiran struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	IDPasien     pasien.ID          `bson:"id_pasien" json:"id_pasien"`
+	NamaFile     string             `bson:"nama_file" json:"nama_file"`
+	ContentType  string             `bson:"content_type" json:"content_type"`
+	Ukuran       int64              `bson:"ukuran" json:"ukuran"`
+	Keterangan   string             `bson:"keterangan,omitempty" json:"keterangan,omitempty"`
+	Key          string             `bson:"key" json:"-"`
+	ThumbnailKey string             `bson:"thumbnail_key,omitempty" json:"-"`
+	Thumbnail    bool               `bson:"-" json:"thumbnail"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func maxSize() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("LAMPIRAN_MAX_SIZE"), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return defaultMaxSize
+}
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Handler serves /api/lampiran:
+//
+//	POST   multipart form with file, id_pasien and optional keterangan
+//	GET    ?id_pasien=  lists the attachments of a patient
+//	GET    ?id=         downloads one, add &thumbnail=true for the thumbnail
+//	DELETE ?id=         deletes one
+func Handler(w http.ResponseWriter, r *http.Request) {
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	storage, err := StorageFor(db)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost:
+		upload(w, r, db, storage)
+	case r.Method == http.MethodGet && r.URL.Query().Get("id") != "":
+		download(w, r, db, storage)
+	case r.Method == http.MethodGet:
+		list(w, r, db)
+	case r.Method == http.MethodDelete:
+		remove(w, r, db, storage)
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func upload(w http.ResponseWriter, r *http.Request, db *mongo.Database, storage Storage) {
+	limit := maxSize()
+	// The form fields and multipart headers need a little room on top of
+	// the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("ukuran file maksimal %d byte", limit))
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "body harus multipart/form-data")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	idPasien, err := pasien.ParseID(r.FormValue("id_pasien"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "file needed")
+		return
+	}
+	defer file.Close()
+	if header.Size > limit {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("ukuran file maksimal %d byte", limit))
+		return
+	}
+
+	lampiran, err := store(r.Context(), db, storage, Lampiran{
+		IDPasien:   idPasien,
+		NamaFile:   header.Filename,
+		Ukuran:     header.Size,
+		Keterangan: r.FormValue("keterangan"),
+	}, file)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrUnsupportedType):
+			status = http.StatusUnsupportedMediaType
+		case errors.Is(err, ErrBadImage), errors.Is(err, ErrImageTooLarge), errors.Is(err, ErrEmpty):
+			status = http.StatusBadRequest
+		case errors.Is(err, pasien.ErrNotFound):
+			status = http.StatusNotFound
+		}
+		respondWithError(w, status, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{"message": "success", "data": lampiran})
+}
+
+// ErrUnsupportedType is returned for content other than AllowedTypes.
+var ErrUnsupportedType = errors.New("tipe file tidak didukung, gunakan JPEG, PNG atau PDF")
+
+// ErrBadImage is returned for a JPEG or PNG that does not decode.
+var ErrBadImage = errors.New("gambar tidak bisa dibaca")
+
+// ErrEmpty is returned for an empty file.
+var ErrEmpty = errors.New("file kosong")
+
+// ErrTooLarge is returned by Save for data over the size limit.
+var ErrTooLarge = errors.New("ukuran file melebihi batas")
+
+// store checks the content of file, saves it with its thumbnail and
+// inserts the metadata. lampiran carries the id_pasien, file name, size and
+// description; the rest is filled in.
+func store(ctx context.Context, db *mongo.Database, storage Storage, lampiran Lampiran, file io.ReadSeeker) (Lampiran, error) {
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if n == 0 {
+		return lampiran, ErrEmpty
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return lampiran, err
+	}
+	contentType := http.DetectContentType(sniff[:n])
+	ext, ok := AllowedTypes[contentType]
+	if !ok {
+		return lampiran, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	count, err := db.Collection("pasien").CountDocuments(ctx, bson.M{"id_pasien": lampiran.IDPasien})
+	if err != nil {
+		return lampiran, err
+	}
+	if count == 0 {
+		return lampiran, pasien.ErrNotFound
+	}
+
+	lampiran.ID = primitive.NewObjectID()
+	lampiran.NamaFile = filepath.Base(lampiran.NamaFile)
+	lampiran.ContentType = contentType
+	lampiran.CreatedAt = time.Now()
+	lampiran.Key = lampiran.ID.Hex() + ext
+
+	var thumbnail []byte
+	if contentType != "application/pdf" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return lampiran, err
+		}
+		thumbnail, err = Thumbnail(file)
+		if errors.Is(err, ErrImageTooLarge) {
+			return lampiran, err
+		}
+		if err != nil {
+			return lampiran, ErrBadImage
+		}
+		lampiran.ThumbnailKey = lampiran.ID.Hex() + ".thumb.jpg"
+		lampiran.Thumbnail = true
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return lampiran, err
+	}
+	if err := storage.Save(ctx, lampiran.Key, file); err != nil {
+		return lampiran, fmt.Errorf("error saving file: %v", err)
+	}
+	if thumbnail != nil {
+		if err := storage.Save(ctx, lampiran.ThumbnailKey, bytes.NewReader(thumbnail)); err != nil {
+			deleteFiles(ctx, storage, lampiran)
+			return lampiran, fmt.Errorf("error saving thumbnail: %v", err)
+		}
+	}
+	if _, err := db.Collection(Collection).InsertOne(ctx, lampiran); err != nil {
+		deleteFiles(ctx, storage, lampiran)
+		return lampiran, fmt.Errorf("error inserting data to database: %v", err)
+	}
+	return lampiran, nil
+}
+
+// Save stores data as an attachment of a patient, with the same checks as
+// an upload. Other subsystems use it for images they receive inline, such as
+// signatures.
+func Save(ctx context.Context, db *mongo.Database, idPasien pasien.ID, namaFile, keterangan string, data []byte) (Lampiran, error) {
+	if int64(len(data)) > maxSize() {
+		return Lampiran{}, ErrTooLarge
+	}
+	storage, err := StorageFor(db)
+	if err != nil {
+		return Lampiran{}, err
+	}
+	return store(ctx, db, storage, Lampiran{
+		IDPasien:   idPasien,
+		NamaFile:   namaFile,
+		Ukuran:     int64(len(data)),
+		Keterangan: keterangan,
+	}, bytes.NewReader(data))
+}
+
+func list(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	idPasien, err := pasien.ParseID(r.URL.Query().Get("id_pasien"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+		return
+	}
+	opts := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := db.Collection(Collection).Find(r.Context(), bson.M{"id_pasien": idPasien}, opts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing query")
+		return
+	}
+	lampiran := []Lampiran{}
+	if err := cursor.All(r.Context(), &lampiran); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error decoding results")
+		return
+	}
+	for i := range lampiran {
+		lampiran[i].Thumbnail = lampiran[i].ThumbnailKey != ""
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": lampiran})
+}
+
+func find(r *http.Request, db *mongo.Database) (Lampiran, int, error) {
+	var lampiran Lampiran
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		return lampiran, http.StatusBadRequest, errors.New("invalid id")
+	}
+	err = db.Collection(Collection).FindOne(r.Context(), bson.M{"_id": id}).Decode(&lampiran)
+	if err == mongo.ErrNoDocuments {
+		return lampiran, http.StatusNotFound, errors.New("lampiran tidak ditemukan")
+	}
+	if err != nil {
+		return lampiran, http.StatusInternalServerError, err
+	}
+	return lampiran, http.StatusOK, nil
+}
+
+func download(w http.ResponseWriter, r *http.Request, db *mongo.Database, storage Storage) {
+	lampiran, status, err := find(r, db)
+	if err != nil {
+		respondWithError(w, status, err.Error())
+		return
+	}
+
+	key, contentType := lampiran.Key, lampiran.ContentType
+	filename := lampiran.NamaFile
+	if r.URL.Query().Get("thumbnail") == "true" {
+		if lampiran.ThumbnailKey == "" {
+			respondWithError(w, http.StatusNotFound, "lampiran ini tidak punya thumbnail")
+			return
+		}
+		key, contentType = lampiran.ThumbnailKey, "image/jpeg"
+		filename = "thumbnail-" + filename
+	}
+
+	reader, err := storage.Open(r.Context(), key)
+	if errors.Is(err, ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error opening file")
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("lampiran %s: error sending file: %v", lampiran.ID.Hex(), err)
+	}
+}
+
+func remove(w http.ResponseWriter, r *http.Request, db *mongo.Database, storage Storage) {
+	lampiran, status, err := find(r, db)
+	if err != nil {
+		respondWithError(w, status, err.Error())
+		return
+	}
+	if _, err := db.Collection(Collection).DeleteOne(r.Context(), bson.M{"_id": lampiran.ID}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error deleting lampiran")
+		return
+	}
+	deleteFiles(r.Context(), storage, lampiran)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Delete successful"})
+}
+
+// deleteFiles removes the stored bytes of an attachment. A failure only
+// leaves an unreferenced file behind, so it is logged and not returned.
+func deleteFiles(ctx context.Context, storage Storage, lampiran Lampiran) {
+	for _, key := range []string{lampiran.Key, lampiran.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := storage.Delete(ctx, key); err != nil {
+			log.Printf("lampiran %s: error deleting %s: %v", lampiran.ID.Hex(), key, err)
+		}
+	}
+}
+
+// DeleteForPasien deletes every attachment of a patient, for when the
+// patient is deleted or anonymised.
+func DeleteForPasien(ctx context.Context, db *mongo.Database, idPasien interface{}) (int, error) {
+	storage, err := StorageFor(db)
+	if err != nil {
+		return 0, err
+	}
+	cursor, err := db.Collection(Collection).Find(ctx, bson.M{"id_pasien": idPasien})
+	if err != nil {
+		return 0, err
+	}
+	var lampiran []Lampiran
+	if err := cursor.All(ctx, &lampiran); err != nil {
+		return 0, err
+	}
+	if _, err := db.Collection(Collection).DeleteMany(ctx, bson.M{"id_pasien": idPasien}); err != nil {
+		return 0, err
+	}
+	for _, l := range lampiran {
+		deleteFiles(ctx, storage, l)
+	}
+	return len(lampiran), nil
+}
@@ -0,0 +1,139 @@
+package lampiran
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotExist is returned by Storage.Open for a key that was never saved or
+// has been deleted.
+var ErrNotExist = errors.New("file lampiran tidak ditemukan")
+
+// Storage keeps the bytes of attachments and thumbnails under a key chosen
+// by this package. The metadata lives in the lampiran collection either way.
+type Storage interface {
+	Save(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalStorage stores every key as a file in Dir.
+type LocalStorage struct {
+	Dir string
+}
+
+func (s LocalStorage) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("key lampiran tidak valid: %q", key)
+	}
+	return filepath.Join(s.Dir, key), nil
+}
+
+func (s LocalStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+func (s LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
+
+func (s LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// bucketName is the GridFS bucket, i.e. the lampiran_fs.files and
+// lampiran_fs.chunks collections.
+const bucketName = "lampiran_fs"
+
+// GridFSStorage stores every key as a GridFS file whose _id is the key.
+type GridFSStorage struct {
+	Bucket *gridfs.Bucket
+}
+
+func (s GridFSStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	return s.Bucket.UploadFromStreamWithID(key, key, r)
+}
+
+func (s GridFSStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	stream, err := s.Bucket.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s GridFSStorage) Delete(ctx context.Context, key string) error {
+	err := s.Bucket.DeleteContext(ctx, key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ErrNoDir is returned when local storage is selected without an absolute
+// LAMPIRAN_DIR. A relative directory would depend on where the host starts
+// the process and could end up inside the deployed app.
+var ErrNoDir = errors.New("LAMPIRAN_DIR harus diisi dengan path absolut untuk penyimpanan lokal")
+
+// StorageFor returns the storage selected by LAMPIRAN_STORAGE: "gridfs", or
+// "local" (the default) in LAMPIRAN_DIR, which must be an absolute path.
+func StorageFor(db *mongo.Database) (Storage, error) {
+	switch os.Getenv("LAMPIRAN_STORAGE") {
+	case "", "local":
+		dir := os.Getenv("LAMPIRAN_DIR")
+		if !filepath.IsAbs(dir) {
+			return nil, ErrNoDir
+		}
+		return LocalStorage{Dir: dir}, nil
+	case "gridfs":
+		bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucketName))
+		if err != nil {
+			return nil, err
+		}
+		return GridFSStorage{Bucket: bucket}, nil
+	}
+	return nil, fmt.Errorf("LAMPIRAN_STORAGE %q tidak dikenal, gunakan local atau gridfs", os.Getenv("LAMPIRAN_STORAGE"))
+}
@@ -0,0 +1,81 @@
+package lampiran
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// thumbnailSize is the longest side of a thumbnail in pixels.
+const thumbnailSize = 256
+
+// maxPixels bounds the width × height of an image that is decoded. A
+// small compressed file can declare huge dimensions and decoding it would
+// allocate them all; a 50 megapixel photo is larger than any phone camera
+// or scanner page the clinic uses.
+const maxPixels = 50_000_000
+
+// ErrImageTooLarge is returned for an image with more than maxPixels.
+var ErrImageTooLarge = errors.New("resolusi gambar terlalu besar")
+
+// Thumbnail decodes a JPEG or PNG and returns a JPEG scaled down so its
+// longest side is at most thumbnailSize. Each thumbnail pixel is the average
+// of the source pixels it covers, which keeps text on a scanned page
+// readable better than nearest-neighbour sampling. The dimensions are read
+// from the header first and images over maxPixels are not decoded.
+func Thumbnail(r io.ReadSeeker) ([]byte, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailSize || height > thumbnailSize {
+		if width >= height {
+			width, height = thumbnailSize, max(1, height*thumbnailSize/bounds.Dx())
+		} else {
+			width, height = max(1, width*thumbnailSize/bounds.Dy()), thumbnailSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			// Transparent pixels are laid over white, JPEG has no alpha.
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					white := uint64(0xffff - ca)
+					r, g, b, n = r+uint64(cr)+white, g+uint64(cg)+white, b+uint64(cb)+white, n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), 0xffff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,167 @@
+package laporandiagnosis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// visits is the pipeline selecting the coded diagnoses of the visits of
+// one layanan in [dari, sampai).
+func visits(layanan int, dari, sampai time.Time) []bson.M {
+	return []bson.M{
+		{"$match": bson.M{
+			"tglDatang": bson.M{"$gte": dari, "$lt": sampai},
+			"diagnosis": bson.M{"$exists": true, "$ne": bson.A{}},
+		}},
+		{"$project": bson.M{"_id": 0, "id_pasien": 1, "diagnosis": 1, "id_layanan": bson.M{"$literal": layanan}}},
+	}
+}
+
+// tanggal reads the dari or sampai parameter, def when it is not given.
+func tanggal(r *http.Request, key string, def time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+	t, err := pasien.ParseTanggal(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s", key)
+	}
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, pasien.Jakarta), nil
+}
+
+// LaporanDiagnosis returns the most frequent ICD-10 diagnoses of the SOAP
+// visits from ?dari= to ?sampai= inclusive, by default the current month.
+// ?id_layanan= (0 KB, 1 Kehamilan, 2 Imunisasi) limits it to one layanan;
+// ?limit= is the number of diagnoses, 10 by default. Every diagnosis of a
+// visit is counted, with the number of visits and of distinct patients.
+func LaporanDiagnosis(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().In(pasien.Jakarta)
+	y, m, d := now.Date()
+	dari, err := tanggal(r, "dari", time.Date(y, m, 1, 0, 0, 0, 0, pasien.Jakarta))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sampai, err := tanggal(r, "sampai", time.Date(y, m, d, 0, 0, 0, 0, pasien.Jakarta))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if sampai.Before(dari) {
+		respondWithError(w, http.StatusBadRequest, "sampai tidak boleh sebelum dari")
+		return
+	}
+
+	limit := 10
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	var layanan []int
+	if s := r.URL.Query().Get("id_layanan"); s != "" {
+		l, err := strconv.Atoi(s)
+		if _, ok := soap.Collections[l]; err != nil || !ok {
+			respondWithError(w, http.StatusBadRequest, "Invalid id_layanan")
+			return
+		}
+		layanan = []int{l}
+	} else {
+		for l := range soap.Collections {
+			layanan = append(layanan, l)
+		}
+		sort.Ints(layanan)
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	// sampai is inclusive, so the range ends at the midnight after it.
+	end := sampai.AddDate(0, 0, 1)
+	pipeline := visits(layanan[0], dari, end)
+	for _, l := range layanan[1:] {
+		pipeline = append(pipeline, bson.M{"$unionWith": bson.M{"coll": soap.Collections[l], "pipeline": visits(l, dari, end)}})
+	}
+	pipeline = append(pipeline,
+		bson.M{"$unwind": "$diagnosis"},
+		bson.M{"$group": bson.M{
+			"_id":     "$diagnosis.kode",
+			"nama":    bson.M{"$first": "$diagnosis.nama"},
+			"jumlah":  bson.M{"$sum": 1},
+			"pasien":  bson.M{"$addToSet": "$id_pasien"},
+			"layanan": bson.M{"$addToSet": "$id_layanan"},
+		}},
+		bson.M{"$sort": bson.D{{Key: "jumlah", Value: -1}, {Key: "_id", Value: 1}}},
+		bson.M{"$limit": limit},
+		bson.M{"$project": bson.M{
+			"_id":           0,
+			"kode":          "$_id",
+			"nama":          1,
+			"jumlah":        1,
+			"jumlah_pasien": bson.M{"$size": "$pasien"},
+			"id_layanan":    "$layanan",
+		}},
+	)
+
+	cursor, err := db.Collection(soap.Collections[layanan[0]]).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing aggregation")
+		return
+	}
+	results := []bson.M{}
+	if err := cursor.All(r.Context(), &results); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error decoding results")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "success",
+		"dari":    pasien.FormatTanggal(dari),
+		"sampai":  pasien.FormatTanggal(sampai),
+		"data":    results,
+	})
+}
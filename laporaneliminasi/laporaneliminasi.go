@@ -0,0 +1,193 @@
+package laporaneliminasi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/eliminasi"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Cakupan counts one test over the mothers of a desa.
+type Cakupan struct {
+	Diperiksa        int     `json:"diperiksa"`
+	Persen           float64 `json:"persen"`
+	Reaktif          int     `json:"reaktif"`
+	ReaktifDitangani int     `json:"reaktif_ditangani"`
+}
+
+// Baris is the coverage of one desa.
+type Baris struct {
+	Desa          string              `json:"desa"`
+	IbuHamil      int                 `json:"ibu_hamil"`
+	Lengkap       int                 `json:"lengkap"`
+	PersenLengkap float64             `json:"persen_lengkap"`
+	Pemeriksaan   map[string]*Cakupan `json:"pemeriksaan"`
+}
+
+func newBaris(desa string) *Baris {
+	b := &Baris{Desa: desa, Pemeriksaan: map[string]*Cakupan{}}
+	for _, kode := range eliminasi.Pemeriksaan {
+		b.Pemeriksaan[kode] = &Cakupan{}
+	}
+	return b
+}
+
+func (b *Baris) add(k eliminasi.Kehamilan) {
+	b.IbuHamil++
+	if k.Lengkap {
+		b.Lengkap++
+	}
+	for _, s := range k.Skrining {
+		c := b.Pemeriksaan[s.Pemeriksaan]
+		switch s.Status {
+		case eliminasi.NonReaktif:
+			c.Diperiksa++
+		case eliminasi.ReaktifBelumDitangani:
+			c.Diperiksa++
+			c.Reaktif++
+		case eliminasi.ReaktifDitangani:
+			c.Diperiksa++
+			c.Reaktif++
+			c.ReaktifDitangani++
+		}
+	}
+}
+
+func persen(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)*1000/float64(total)) / 10
+}
+
+func (b *Baris) hitung() {
+	b.PersenLengkap = persen(b.Lengkap, b.IbuHamil)
+	for _, c := range b.Pemeriksaan {
+		c.Persen = persen(c.Diperiksa, b.IbuHamil)
+	}
+}
+
+// LaporanEliminasi reports the triple elimination coverage of ?bulan=
+// (yyyy-mm, by default the current month) by desa, spelled variants of a
+// name merged by eliminasi.NormalizeDesa. The mothers counted are
+// those with an antenatal visit in the month, screened at any time of the
+// same pregnancy up to the end of the month.
+func LaporanEliminasi(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().In(pasien.Jakarta)
+	dari := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, pasien.Jakarta)
+	if bulan := r.URL.Query().Get("bulan"); bulan != "" {
+		t, err := time.ParseInLocation("2006-01", bulan, pasien.Jakarta)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid bulan, gunakan yyyy-mm")
+			return
+		}
+		dari = t
+	}
+	sampai := dari.AddDate(0, 1, 0)
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	// The first visit of every mother in the month.
+	pipeline := []bson.M{
+		{"$match": bson.M{"tglDatang": bson.M{"$gte": dari, "$lt": sampai}}},
+		{"$group": bson.M{"_id": "$id_pasien", "kunjungan": bson.M{"$min": "$tglDatang"}}},
+	}
+	cursor, err := db.Collection(soap.Collections[pasien.LayananKehamilan]).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing aggregation")
+		return
+	}
+	var visits []struct {
+		IDPasien  pasien.ID `bson:"_id"`
+		Kunjungan time.Time `bson:"kunjungan"`
+	}
+	if err := cursor.All(r.Context(), &visits); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error decoding results")
+		return
+	}
+	ids := make([]pasien.ID, len(visits))
+	kunjungan := map[pasien.ID]time.Time{}
+	for i, v := range visits {
+		ids[i] = v.IDPasien
+		kunjungan[v.IDPasien] = v.Kunjungan
+	}
+
+	docs, results, tindak, err := eliminasi.Load(r.Context(), db, bson.M{"id_pasien": bson.M{"$in": ids}})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing query")
+		return
+	}
+
+	total := newBaris("Total")
+	byDesa := map[string]*Baris{}
+	for _, doc := range docs {
+		id, _ := pasien.IDFrom(doc["id_pasien"])
+		// A visit outside the recorded pregnancy belongs to an earlier
+		// one that cannot be assessed any more.
+		if !eliminasi.Hamil(doc, kunjungan[id]) {
+			continue
+		}
+		k := eliminasi.Status(doc, results[id], tindak[id], sampai.Add(-time.Nanosecond))
+		b, ok := byDesa[k.Desa]
+		if !ok {
+			b = newBaris(k.Desa)
+			byDesa[k.Desa] = b
+		}
+		b.add(k)
+		total.add(k)
+	}
+
+	rows := []*Baris{}
+	for _, b := range byDesa {
+		b.hitung()
+		rows = append(rows, b)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Desa < rows[j].Desa })
+	total.hitung()
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "success",
+		"bulan":   dari.Format("2006-01"),
+		"data":    rows,
+		"total":   total,
+	})
+}
@@ -17,20 +17,37 @@ import (
 	"github.com/Kazengan/bidan-backend/edit"
 	"github.com/Kazengan/bidan-backend/editimunisasi"
 	"github.com/Kazengan/bidan-backend/editkb"
+	"github.com/Kazengan/bidan-backend/eliminasi"
 	"github.com/Kazengan/bidan-backend/export"
 	"github.com/Kazengan/bidan-backend/findpasien"
 	"github.com/Kazengan/bidan-backend/getbidan"
 	"github.com/Kazengan/bidan-backend/getpasien"
 	"github.com/Kazengan/bidan-backend/getreservasi"
 	"github.com/Kazengan/bidan-backend/helper"
+	"github.com/Kazengan/bidan-backend/icd10"
+	"github.com/Kazengan/bidan-backend/idempotency"
 	"github.com/Kazengan/bidan-backend/input"
 	"github.com/Kazengan/bidan-backend/inputimunisasi"
 	"github.com/Kazengan/bidan-backend/inputkb"
 	"github.com/Kazengan/bidan-backend/inputkehamilan"
+	"github.com/Kazengan/bidan-backend/lab"
+	"github.com/Kazengan/bidan-backend/lampiran"
+	"github.com/Kazengan/bidan-backend/laporandiagnosis"
+	"github.com/Kazengan/bidan-backend/laporaneliminasi"
+	"github.com/Kazengan/bidan-backend/migrate"
+	"github.com/Kazengan/bidan-backend/obat"
+	"github.com/Kazengan/bidan-backend/patchpasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
+	"github.com/Kazengan/bidan-backend/persetujuan"
 	"github.com/Kazengan/bidan-backend/registbidan"
 	"github.com/Kazengan/bidan-backend/registpasien"
 	"github.com/Kazengan/bidan-backend/reservasi"
+	"github.com/Kazengan/bidan-backend/retensi"
+	"github.com/Kazengan/bidan-backend/riwayatobat"
+	"github.com/Kazengan/bidan-backend/searchpasien"
+	"github.com/Kazengan/bidan-backend/sesi"
 	"github.com/Kazengan/bidan-backend/soap"
+	"github.com/Kazengan/bidan-backend/soapentry"
 	"github.com/Kazengan/bidan-backend/soapimunisasi"
 	"github.com/Kazengan/bidan-backend/soapkb"
 	"github.com/Kazengan/bidan-backend/soapkehamilan"
@@ -38,9 +55,29 @@ import (
 	"github.com/Kazengan/bidan-backend/tableimunisasi"
 	"github.com/Kazengan/bidan-backend/tablekb"
 	"github.com/Kazengan/bidan-backend/tablekehamilan"
+	"github.com/Kazengan/bidan-backend/templat"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate.Run(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "retensi" {
+		if err := retensi.Command(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := migrate.OnStartup(); err != nil {
+		log.Fatal(err)
+	}
+	if err := sesi.CheckSecret(); err != nil {
+		log.Fatal(err)
+	}
+
 	listenAddr := ":8080"
 	if val, ok := os.LookupEnv("PORT"); ok {
 		listenAddr = ":" + val
@@ -58,27 +95,42 @@ func main() {
 	http.HandleFunc("/api/editkb", editkb.EditKb)
 	http.HandleFunc("/api/editimunisasi", editimunisasi.EditImunisasi)
 	http.HandleFunc("/api/edit", edit.Edit)
+	http.HandleFunc("/api/patchpasien", patchpasien.PatchPasien)
 	http.HandleFunc("/api/findpasien", findpasien.PasienPerLayanan)
-	http.HandleFunc("/api/inputkb", inputkb.InputKB)
-	http.HandleFunc("/api/input", input.Input)
-	http.HandleFunc("/api/soap", soap.Soap)
+	http.HandleFunc("/api/searchpasien", searchpasien.SearchPasien)
+	http.HandleFunc("/api/inputkb", idempotency.Handle(inputkb.InputKB))
+	http.HandleFunc("/api/input", idempotency.Handle(input.Input))
+	http.HandleFunc("/api/soap", idempotency.Handle(soap.Soap))
 
-	http.HandleFunc("/api/soapkb", soapkb.SoapKB)
-	http.HandleFunc("/api/soapimunisasi", soapimunisasi.SoapImunisasi)
-	http.HandleFunc("/api/soapkehamilan", soapkehamilan.SoapKehamilan)
+	http.HandleFunc("/api/soapentry", soapentry.SoapEntry)
+	http.HandleFunc("/api/soapkb", idempotency.Handle(soapkb.SoapKB))
+	http.HandleFunc("/api/soapimunisasi", idempotency.Handle(soapimunisasi.SoapImunisasi))
+	http.HandleFunc("/api/soapkehamilan", idempotency.Handle(soapkehamilan.SoapKehamilan))
 	http.HandleFunc("/api/tablekb", tablekb.TableKB)
 	http.HandleFunc("/api/table", table.Table)
 	http.HandleFunc("/api/tableimunisasi", tableimunisasi.TableImunisasi)
 	http.HandleFunc("/api/tablekehamilan", tablekehamilan.TableKehamilan)
-	http.HandleFunc("/api/inputkehamilan", inputkehamilan.InputKehamilan)
-	http.HandleFunc("/api/inputimunisasi", inputimunisasi.InputImunisasi)
+	http.HandleFunc("/api/inputkehamilan", idempotency.Handle(inputkehamilan.InputKehamilan))
+	http.HandleFunc("/api/inputimunisasi", idempotency.Handle(inputimunisasi.InputImunisasi))
 	http.HandleFunc("/api/getbidan", getallbidan.GetAllBidan)
 	http.HandleFunc("/api/deletebidan", deletebidan.DeleteBidan)
 	http.HandleFunc("/api/registbidan", registbidan.RegistBidan)
 	http.HandleFunc("/api/registpasien", registpasien.RegistPasien)
-	http.HandleFunc("/api/reservasi", reservasi.Reservasi)
+	http.HandleFunc("/api/reservasi", idempotency.Handle(reservasi.Reservasi))
 	http.HandleFunc("/api/helper", helper.Helper)
 	http.HandleFunc("/api/export", export.Export)
+	http.HandleFunc("/api/lampiran", lampiran.Handler)
+	http.HandleFunc("/api/persetujuan", idempotency.Handle(persetujuan.Handler))
+	http.HandleFunc("/api/templat", idempotency.Handle(templat.Handler))
+	http.HandleFunc("/api/icd10", icd10.Handler)
+	http.HandleFunc("/api/laporandiagnosis", laporandiagnosis.LaporanDiagnosis)
+	http.HandleFunc("/api/obat", obat.Handler)
+	http.HandleFunc("/api/riwayatobat", riwayatobat.RiwayatObat)
+	http.HandleFunc("/api/lab", idempotency.Handle(lab.Handler))
+	http.HandleFunc("/api/eliminasi", idempotency.Handle(eliminasi.Handler))
+	http.HandleFunc("/api/laporaneliminasi", laporaneliminasi.LaporanEliminasi)
+	http.HandleFunc("/api/peringatan", peringatan.Handler)
+	http.HandleFunc("/retensi", retensi.Timer)
 
 	log.Printf("Listening on %s\n", listenAddr)
 	log.Fatal(http.ListenAndServe(listenAddr, nil))
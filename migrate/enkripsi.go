@@ -0,0 +1,91 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// encryptPasien encrypts the sensitive fields of every pasien with the
+// active key. Run it again ("redo enkripsi") after a key rotation or a change
+// of ENCRYPTED_FIELDS: documents already encrypted as configured are
+// skipped, the others are decrypted and encrypted again.
+func encryptPasien(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	keyring, err := pasien.Keys()
+	if err != nil {
+		return report, err
+	}
+	if keyring == nil {
+		report.Problems = append(report.Problems, "ENCRYPTION_KEY_FILE tidak diatur, tidak ada yang dienkripsi; jalankan redo enkripsi setelah kunci dibuat")
+	}
+
+	collection := db.Collection("pasien")
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return report, err
+		}
+		report.Scanned++
+
+		version := pasien.Version(doc)
+		stored, changed, err := pasien.Reencrypt(doc)
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v: %v", doc["id_pasien"], err))
+			continue
+		}
+		if !changed {
+			continue
+		}
+		report.Updated++
+		if dryRun {
+			continue
+		}
+
+		// The version is matched but not bumped: the content is the same,
+		// and an edit made meanwhile must not be overwritten.
+		filter := pasien.VersionFilter(bson.M{"_id": stored["_id"]}, version)
+		result, err := collection.ReplaceOne(ctx, filter, stored)
+		if err != nil {
+			return report, err
+		}
+		if result.MatchedCount == 0 {
+			report.Updated--
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v diubah selama migrasi, jalankan lagi", doc["id_pasien"]))
+		}
+	}
+	return report, cursor.Err()
+}
+
+// rotateKey adds a new active key to ENCRYPTION_KEY_FILE and re-encrypts
+// every pasien and sensitive lab result with it.
+func rotateKey(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	path := os.Getenv("ENCRYPTION_KEY_FILE")
+	if path == "" {
+		return fmt.Errorf("ENCRYPTION_KEY_FILE tidak diatur")
+	}
+	if !dryRun {
+		id, err := pasien.GenerateKey(path)
+		if err != nil {
+			return err
+		}
+		log.Printf("rotate-key: active key is now %s", id)
+	}
+	for _, name := range []string{"enkripsi", "hasillab"} {
+		if err := Redo(ctx, db, name, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	faktorRisikoLama = "data_kehamilan.faktor_resiko_resiko_tinggi"
+	faktorRisikoBaru = "data_kehamilan.faktor_risiko_dan_risiko_tinggi"
+)
+
+// renameFaktorRisiko moves data_kehamilan.faktor_resiko_resiko_tinggi to
+// faktor_risiko_dan_risiko_tinggi, the name the handlers write since. A
+// document that somehow has both is reported and left for a human to merge.
+func renameFaktorRisiko(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	collection := db.Collection("pasien")
+
+	cursor, err := collection.Find(ctx, bson.M{faktorRisikoLama: bson.M{"$exists": true}})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return report, err
+		}
+		report.Scanned++
+
+		if _, ok := pasien.Lookup(doc, faktorRisikoBaru); ok {
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v: %s and %s both set, not renamed", doc["id_pasien"], faktorRisikoLama, faktorRisikoBaru))
+			continue
+		}
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		update := bson.M{
+			"$rename": bson.M{faktorRisikoLama: faktorRisikoBaru},
+			"$inc":    bson.M{pasien.VersionField: int64(1)},
+		}
+		result, err := collection.UpdateOne(ctx, pasien.VersionFilter(bson.M{"_id": doc["_id"]}, pasien.Version(doc)), update)
+		if err != nil {
+			return report, err
+		}
+		if result.MatchedCount == 0 {
+			report.Updated--
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v changed while migrating, run redo faktorrisiko again", doc["id_pasien"]))
+		}
+	}
+	return report, cursor.Err()
+}
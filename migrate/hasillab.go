@@ -0,0 +1,74 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Kazengan/bidan-backend/lab"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// encryptHasilLab seals the results of the Rahasia lab tests that were
+// stored in plaintext, and seals again those written with an older key.
+// Like enkripsi it is run again after a key rotation.
+func encryptHasilLab(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	keyring, err := pasien.Keys()
+	if err != nil {
+		return report, err
+	}
+	if keyring == nil {
+		report.Problems = append(report.Problems, "ENCRYPTION_KEY_FILE tidak diatur, tidak ada yang dienkripsi; jalankan redo hasillab setelah kunci dibuat")
+		return report, nil
+	}
+
+	var rahasia []string
+	for _, p := range lab.Katalog {
+		if p.Rahasia {
+			rahasia = append(rahasia, p.Kode)
+		}
+	}
+	collection := db.Collection(lab.Collection)
+	cursor, err := collection.Find(ctx, bson.M{"pemeriksaan": bson.M{"$in": rahasia}})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var h lab.Hasil
+		if err := cursor.Decode(&h); err != nil {
+			return report, err
+		}
+		report.Scanned++
+
+		if h.Rahasia != "" {
+			current, err := pasien.CurrentKey(h.Rahasia)
+			if err != nil {
+				return report, err
+			}
+			if current {
+				continue
+			}
+		}
+		opened, err := h.Open()
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s %s: %v", lab.Collection, h.ID.Hex(), err))
+			continue
+		}
+		sealed, err := opened.Seal()
+		if err != nil {
+			return report, err
+		}
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": h.ID}, sealed); err != nil {
+			return report, err
+		}
+	}
+	return report, cursor.Err()
+}
@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// idPasienCollections lists the collections holding an id_pasien that must
+// be stored as int64, the type pasien.ID marshals to: pasien, the SOAP
+// collections and the archive of deleted SOAP records. The SOAP handlers
+// used to store the int from strconv.Atoi and the generic soap endpoint the
+// raw JSON number.
+func idPasienCollections() []string {
+	return append(append([]string{"pasien"}, soap.CollectionNames()...), soap.ArsipCollection)
+}
+
+var wrongIDType = bson.M{"id_pasien": bson.M{"$exists": true, "$not": bson.M{"$type": "long"}}}
+
+// normalizeIDPasien converts every id_pasien that is not already a long.
+func normalizeIDPasien(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	for _, name := range idPasienCollections() {
+		collection := db.Collection(name)
+		count, err := collection.CountDocuments(ctx, wrongIDType)
+		if err != nil {
+			return report, err
+		}
+		report.Scanned += int(count)
+		if dryRun || count == 0 {
+			report.Updated += int(count)
+			continue
+		}
+
+		update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"id_pasien": bson.M{"$convert": bson.M{"input": "$id_pasien", "to": "long", "onError": "$id_pasien"}},
+		}}}}
+		result, err := collection.UpdateMany(ctx, wrongIDType, update)
+		if err != nil {
+			return report, err
+		}
+		report.Updated += int(result.ModifiedCount)
+	}
+
+	for _, name := range idPasienCollections() {
+		remaining, err := db.Collection(name).CountDocuments(ctx, wrongIDType)
+		if err != nil {
+			return report, err
+		}
+		if remaining > 0 && !dryRun {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %d id_pasien bukan angka dan tidak bisa dikonversi", name, remaining))
+		}
+	}
+	return report, nil
+}
+
+// VerifyIDs prints, for pasien and every SOAP collection, how many
+// id_pasien values are stored with each BSON type. With repair set the ones
+// that are not a long are converted the same way the idpasien migration
+// does.
+func VerifyIDs(ctx context.Context, db *mongo.Database, repair bool) error {
+	mismatched := false
+	for _, name := range idPasienCollections() {
+		cursor, err := db.Collection(name).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{"_id": bson.M{"$type": "$id_pasien"}, "count": bson.M{"$sum": 1}}}},
+			{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		})
+		if err != nil {
+			return err
+		}
+		var types []struct {
+			Type  string `bson:"_id"`
+			Count int    `bson:"count"`
+		}
+		if err := cursor.All(ctx, &types); err != nil {
+			return err
+		}
+		for _, t := range types {
+			status := "ok"
+			if t.Type != "long" {
+				status = "mismatch"
+				mismatched = true
+			}
+			log.Printf("%-16s %-10s %6d %s", name, t.Type, t.Count, status)
+		}
+	}
+
+	if !mismatched {
+		log.Println("verify-ids: every id_pasien is a long")
+		return nil
+	}
+	if !repair {
+		log.Println("verify-ids: run with -repair to convert the mismatched ids")
+		return nil
+	}
+	report, err := normalizeIDPasien(ctx, db, false)
+	if err != nil {
+		return err
+	}
+	log.Printf("verify-ids: repaired %d of %d", report.Updated, report.Scanned)
+	for _, problem := range report.Problems {
+		log.Printf("  %s", problem)
+	}
+	return nil
+}
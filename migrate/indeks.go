@@ -0,0 +1,96 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ensureIndexes builds nik_unique: without it two patients created at the
+// same time can get the same NIK, because pasien.CheckNIKUnique only reads.
+// The index is on pasien.NIKBlindField, which is set here for the patients
+// stored before it existed. NIKs are compared by their plaintext, so two
+// encrypted under different keys are still the same NIK. A NIK that is
+// already duplicated keeps the index from being built; those are reported
+// and the migration fails until a human has merged or corrected the
+// patients.
+func ensureIndexes(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	collection := db.Collection("pasien")
+	opts := options.Find().SetProjection(bson.M{"id_pasien": 1, "nik": 1, pasien.NIKBlindField: 1, pasien.VersionField: 1})
+	cursor, err := collection.Find(ctx, bson.M{"nik": bson.M{"$type": "string"}}, opts)
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	type stale struct {
+		id      interface{}
+		version int64
+		blind   string
+	}
+	var missing []stale
+	byBlind := map[string][]interface{}{}
+	var order []string
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return report, err
+		}
+		report.Scanned++
+		version := pasien.Version(doc)
+		stored := doc[pasien.NIKBlindField]
+		if err := pasien.Decrypt(doc); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v: %v", doc["id_pasien"], err))
+			continue
+		}
+		nik, _ := doc["nik"].(string)
+		blind, err := pasien.BlindIndex("nik", nik)
+		if err != nil {
+			return report, err
+		}
+		if _, ok := byBlind[blind]; !ok {
+			order = append(order, blind)
+		}
+		byBlind[blind] = append(byBlind[blind], doc["id_pasien"])
+		if stored != blind {
+			missing = append(missing, stale{doc["_id"], version, blind})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return report, err
+	}
+
+	duplicates := 0
+	for _, blind := range order {
+		if ids := byBlind[blind]; len(ids) > 1 {
+			duplicates++
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v have the same NIK", ids))
+		}
+	}
+	report.Updated = len(missing)
+	if dryRun {
+		return report, nil
+	}
+	if duplicates > 0 {
+		return report, fmt.Errorf("%d NIK are registered more than once: %v", duplicates, report.Problems)
+	}
+
+	// The version is matched but not bumped: nik itself is unchanged.
+	for _, s := range missing {
+		filter := pasien.VersionFilter(bson.M{"_id": s.id}, s.version)
+		result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{pasien.NIKBlindField: s.blind}})
+		if err != nil {
+			return report, err
+		}
+		if result.MatchedCount == 0 {
+			report.Updated--
+			report.Problems = append(report.Problems, fmt.Sprintf("pasien %v changed while migrating, run redo indeks again", s.id))
+		}
+	}
+	return report, pasien.EnsureNIKIndex(ctx, db)
+}
@@ -0,0 +1,331 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report is what a job prints when it finishes. Problems lists documents the
+// job could not fix by itself and that need a human to look at them.
+type Report struct {
+	Scanned  int
+	Updated  int
+	Problems []string
+}
+
+// Job changes the documents of a database. With dryRun set a job must only
+// count and report, never write. Jobs must be safe to run again: documents
+// that are already in the new shape are skipped.
+type Job func(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error)
+
+// Migration is one versioned step of the schema. Versions are applied in
+// ascending order and each is recorded in schema_migrations once it ran.
+type Migration struct {
+	Version int
+	Name    string
+	Up      Job
+}
+
+// migrations lists every migration in the order they are applied. Append
+// new ones at the end with the next version; never renumber or remove one
+// that has been released.
+var migrations = []Migration{
+	{Version: 1, Name: "search", Up: backfillSearch},
+	{Version: 2, Name: "umur", Up: backfillUmur},
+	{Version: 3, Name: "noregister", Up: backfillNoRegister},
+	{Version: 4, Name: "tanggal", Up: convertTanggal},
+	{Version: 5, Name: "idpasien", Up: normalizeIDPasien},
+	{Version: 6, Name: "enkripsi", Up: encryptPasien},
+	{Version: 7, Name: "telepon", Up: normalizeTelepon},
+	{Version: 8, Name: "soap", Up: typeSoap},
+	{Version: 9, Name: "faktorrisiko", Up: renameFaktorRisiko},
+	{Version: 10, Name: "hasillab", Up: encryptHasilLab},
+	{Version: 11, Name: "indeks", Up: ensureIndexes},
+	{Version: 12, Name: "nama", Up: encryptNama},
+}
+
+// Collection records the applied migrations, one document per version, plus
+// a lock document while migrations run.
+const Collection = "schema_migrations"
+
+const lockID = "lock"
+
+// lockTimeout is how long a lock left behind by a crashed run blocks others.
+const lockTimeout = 30 * time.Minute
+
+// ErrLocked is returned when another process is applying migrations.
+var ErrLocked = errors.New("migrations are being applied by another process")
+
+// Applied is the record of a migration in schema_migrations.
+type Applied struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+	Scanned   int       `bson:"scanned"`
+	Updated   int       `bson:"updated"`
+	Problems  []string  `bson:"problems,omitempty"`
+}
+
+// store keeps the lock and the record of applied migrations. It is
+// schema_migrations in a database; the tests use one in memory.
+type store interface {
+	lock(ctx context.Context) error
+	unlock(ctx context.Context)
+	applied(ctx context.Context) (map[int]Applied, error)
+	record(ctx context.Context, applied Applied) error
+}
+
+// mongoStore is the store in the Collection of a database.
+type mongoStore struct {
+	db *mongo.Database
+}
+
+func (s mongoStore) applied(ctx context.Context) (map[int]Applied, error) {
+	cursor, err := s.db.Collection(Collection).Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	var records []Applied
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := map[int]Applied{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+func (s mongoStore) record(ctx context.Context, applied Applied) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.db.Collection(Collection).ReplaceOne(ctx, bson.M{"_id": applied.Version}, applied, opts)
+	return err
+}
+
+func (s mongoStore) lock(ctx context.Context) error {
+	collection := s.db.Collection(Collection)
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": lockID, "locked_at": bson.M{"$lt": time.Now().Add(-lockTimeout)}})
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	_, err = collection.InsertOne(ctx, bson.M{"_id": lockID, "locked_at": time.Now(), "host": host})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrLocked
+	}
+	return err
+}
+
+func (s mongoStore) unlock(ctx context.Context) {
+	if _, err := s.db.Collection(Collection).DeleteOne(ctx, bson.M{"_id": lockID}); err != nil {
+		log.Printf("migrate: error releasing lock: %v", err)
+	}
+}
+
+// AppliedVersions returns the recorded migrations by version.
+func AppliedVersions(ctx context.Context, db *mongo.Database) (map[int]Applied, error) {
+	return mongoStore{db}.applied(ctx)
+}
+
+// Pending returns the migrations that have not been recorded yet, in order.
+func Pending(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	return pending(ctx, mongoStore{db}, migrations)
+}
+
+// pending returns the migrations of list that s has no record of, in
+// version order.
+func pending(ctx context.Context, s store, list []Migration) ([]Migration, error) {
+	applied, err := s.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range list {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	return pending, nil
+}
+
+// apply runs one migration and, unless dryRun, records it in s.
+func apply(ctx context.Context, s store, db *mongo.Database, migration Migration, dryRun bool) error {
+	report, err := migration.Up(ctx, db, dryRun)
+	if err != nil {
+		return fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+	}
+
+	log.Printf("migration %d %s: scanned %d, updated %d, dry-run %v", migration.Version, migration.Name, report.Scanned, report.Updated, dryRun)
+	for _, problem := range report.Problems {
+		log.Printf("  %s", problem)
+	}
+	if dryRun {
+		return nil
+	}
+
+	return s.record(ctx, Applied{
+		Version:   migration.Version,
+		Name:      migration.Name,
+		AppliedAt: time.Now(),
+		Scanned:   report.Scanned,
+		Updated:   report.Updated,
+		Problems:  report.Problems,
+	})
+}
+
+// Up applies every pending migration in version order and stops at the
+// first one that fails. With dryRun set the pending migrations only report
+// what they would change and nothing is recorded.
+func Up(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	return up(ctx, mongoStore{db}, db, migrations, dryRun)
+}
+
+func up(ctx context.Context, s store, db *mongo.Database, list []Migration, dryRun bool) error {
+	if !dryRun {
+		if err := s.lock(ctx); err != nil {
+			return err
+		}
+		defer s.unlock(ctx)
+	}
+
+	pending, err := pending(ctx, s, list)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		log.Println("migrate: database is up to date")
+		return nil
+	}
+	for _, migration := range pending {
+		if err := apply(ctx, s, db, migration, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redo runs a single migration again by name, applied or not. The jobs are
+// idempotent, so this is how a partially fixed collection is finished.
+func Redo(ctx context.Context, db *mongo.Database, name string, dryRun bool) error {
+	return redo(ctx, mongoStore{db}, db, migrations, name, dryRun)
+}
+
+func redo(ctx context.Context, s store, db *mongo.Database, list []Migration, name string, dryRun bool) error {
+	for _, migration := range list {
+		if migration.Name != name {
+			continue
+		}
+		if !dryRun {
+			if err := s.lock(ctx); err != nil {
+				return err
+			}
+			defer s.unlock(ctx)
+		}
+		return apply(ctx, s, db, migration, dryRun)
+	}
+	return fmt.Errorf("unknown migration %q, %s", name, usage())
+}
+
+// Status prints every migration and when it was applied.
+func Status(ctx context.Context, db *mongo.Database) error {
+	applied, err := AppliedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		record, ok := applied[migration.Version]
+		if !ok {
+			log.Printf("%3d %-12s pending", migration.Version, migration.Name)
+			continue
+		}
+		log.Printf("%3d %-12s applied %s (updated %d, problems %d)", migration.Version, migration.Name, record.AppliedAt.Format(time.RFC3339), record.Updated, len(record.Problems))
+	}
+	return nil
+}
+
+// OnStartup applies pending migrations when MIGRATE_ON_STARTUP is "true".
+// A migration already running elsewhere is not an error: this instance
+// starts without waiting for it.
+func OnStartup() error {
+	godotenv.Load()
+	if os.Getenv("MIGRATE_ON_STARTUP") != "true" {
+		return nil
+	}
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	err = Up(context.Background(), client.Database("mydb"), false)
+	if errors.Is(err, ErrLocked) {
+		log.Printf("migrate: %v, skipping", err)
+		return nil
+	}
+	return err
+}
+
+func connect() (*mongo.Client, error) {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+	return client, nil
+}
+
+func usage() string {
+	names := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		names = append(names, migration.Name)
+	}
+	return fmt.Sprintf("usage: main migrate [-dry-run] up | status | redo <%v> | rotate-key | [-repair] verify-ids", names)
+}
+
+// Run is the entry point for "main migrate ...".
+func Run(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	repair := fs.Bool("repair", false, "with verify-ids, convert the mismatched ids")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New(usage())
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	switch command := fs.Arg(0); {
+	case command == "up" && fs.NArg() == 1:
+		return Up(context.Background(), db, *dryRun)
+	case command == "status" && fs.NArg() == 1:
+		return Status(context.Background(), db)
+	case command == "redo" && fs.NArg() == 2:
+		return Redo(context.Background(), db, fs.Arg(1), *dryRun)
+	case command == "rotate-key" && fs.NArg() == 1:
+		return rotateKey(context.Background(), db, *dryRun)
+	case command == "verify-ids" && fs.NArg() == 1:
+		return VerifyIDs(context.Background(), db, *repair && !*dryRun)
+	}
+	return errors.New(usage())
+}
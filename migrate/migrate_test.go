@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memStore is a store kept in memory.
+type memStore struct {
+	locked  bool
+	records map[int]Applied
+}
+
+func newMemStore() *memStore {
+	return &memStore{records: map[int]Applied{}}
+}
+
+func (s *memStore) lock(ctx context.Context) error {
+	if s.locked {
+		return ErrLocked
+	}
+	s.locked = true
+	return nil
+}
+
+func (s *memStore) unlock(ctx context.Context) {
+	s.locked = false
+}
+
+func (s *memStore) applied(ctx context.Context) (map[int]Applied, error) {
+	applied := map[int]Applied{}
+	for version, record := range s.records {
+		applied[version] = record
+	}
+	return applied, nil
+}
+
+func (s *memStore) record(ctx context.Context, applied Applied) error {
+	s.records[applied.Version] = applied
+	return nil
+}
+
+// recorder builds migrations that append their version to ran when they
+// run.
+type recorder struct {
+	ran []int
+}
+
+func (r *recorder) migration(version int, name string) Migration {
+	return Migration{Version: version, Name: name, Up: func(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+		r.ran = append(r.ran, version)
+		return Report{Scanned: version * 10, Updated: version}, nil
+	}}
+}
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	names := map[string]bool{}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", migration.Name, migration.Version, i+1)
+		}
+		if names[migration.Name] {
+			t.Errorf("migration name %q is used twice", migration.Name)
+		}
+		names[migration.Name] = true
+		if migration.Up == nil {
+			t.Errorf("migration %q has no Up", migration.Name)
+		}
+	}
+}
+
+func TestUpAppliesInVersionOrder(t *testing.T) {
+	r := &recorder{}
+	list := []Migration{r.migration(2, "b"), r.migration(1, "a"), r.migration(3, "c")}
+	s := newMemStore()
+
+	if err := up(context.Background(), s, nil, list, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(r.ran, want) {
+		t.Errorf("ran %v, want %v", r.ran, want)
+	}
+	if s.locked {
+		t.Error("lock not released")
+	}
+}
+
+func TestUpRecordsVersion(t *testing.T) {
+	r := &recorder{}
+	s := newMemStore()
+
+	if err := up(context.Background(), s, nil, []Migration{r.migration(1, "a"), r.migration(2, "b")}, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.records) != 2 {
+		t.Fatalf("recorded %d migrations, want 2", len(s.records))
+	}
+	record := s.records[2]
+	if record.Version != 2 || record.Name != "b" || record.Scanned != 20 || record.Updated != 2 || record.AppliedAt.IsZero() {
+		t.Errorf("record of version 2 is %+v", record)
+	}
+}
+
+func TestUpIsIdempotent(t *testing.T) {
+	r := &recorder{}
+	list := []Migration{r.migration(1, "a"), r.migration(2, "b")}
+	s := newMemStore()
+
+	if err := up(context.Background(), s, nil, list, false); err != nil {
+		t.Fatal(err)
+	}
+	first := s.records[1]
+	if err := up(context.Background(), s, nil, list, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(r.ran, want) {
+		t.Errorf("ran %v after two runs, want %v", r.ran, want)
+	}
+	if !reflect.DeepEqual(s.records[1], first) {
+		t.Errorf("record of version 1 changed on re-run: %+v, was %+v", s.records[1], first)
+	}
+
+	// A migration added later is the only one the next run applies.
+	list = append(list, r.migration(3, "c"))
+	if err := up(context.Background(), s, nil, list, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(r.ran, want) {
+		t.Errorf("ran %v, want %v", r.ran, want)
+	}
+}
+
+func TestUpStopsAtFailure(t *testing.T) {
+	r := &recorder{}
+	failing := Migration{Version: 2, Name: "b", Up: func(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+		return Report{}, errors.New("boom")
+	}}
+	s := newMemStore()
+
+	err := up(context.Background(), s, nil, []Migration{r.migration(1, "a"), failing, r.migration(3, "c")}, false)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if want := []int{1}; !reflect.DeepEqual(r.ran, want) {
+		t.Errorf("ran %v, want %v", r.ran, want)
+	}
+	if _, ok := s.records[2]; ok {
+		t.Error("failed migration was recorded")
+	}
+	if _, ok := s.records[1]; !ok {
+		t.Error("migration before the failure was not recorded")
+	}
+}
+
+func TestDryRunRecordsNothing(t *testing.T) {
+	r := &recorder{}
+	s := newMemStore()
+
+	if err := up(context.Background(), s, nil, []Migration{r.migration(1, "a")}, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.ran) != 1 {
+		t.Errorf("dry run ran %v", r.ran)
+	}
+	if len(s.records) != 0 {
+		t.Errorf("dry run recorded %v", s.records)
+	}
+}
+
+func TestUpLocked(t *testing.T) {
+	r := &recorder{}
+	s := newMemStore()
+	s.locked = true
+
+	err := up(context.Background(), s, nil, []Migration{r.migration(1, "a")}, false)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want ErrLocked", err)
+	}
+	if len(r.ran) != 0 {
+		t.Errorf("ran %v while locked", r.ran)
+	}
+}
+
+func TestRedoRunsAppliedMigration(t *testing.T) {
+	r := &recorder{}
+	list := []Migration{r.migration(1, "a")}
+	s := newMemStore()
+
+	if err := up(context.Background(), s, nil, list, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := redo(context.Background(), s, nil, list, "a", false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 1}; !reflect.DeepEqual(r.ran, want) {
+		t.Errorf("ran %v, want %v", r.ran, want)
+	}
+	if err := redo(context.Background(), s, nil, list, "x", false); err == nil {
+		t.Error("redo of an unknown migration succeeded")
+	}
+}
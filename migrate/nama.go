@@ -0,0 +1,19 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// encryptNama encrypts the names of the patients stored before names were
+// in pasien.EncryptedFields, storing their search keys as blind prefixes,
+// and rebuilds the text index without them.
+func encryptNama(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	report, err := encryptPasien(ctx, db, dryRun)
+	if err != nil || dryRun {
+		return report, err
+	}
+	return report, pasien.EnsureSearchIndexes(ctx, db)
+}
@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// layananOf returns the layanan a pasien document was registered for.
+func layananOf(doc bson.M) (int, bool) {
+	for _, layanan := range []int{pasien.LayananKB, pasien.LayananKehamilan, pasien.LayananImunisasi} {
+		if _, ok := doc[pasien.LayananField[layanan]]; ok {
+			return layanan, true
+		}
+	}
+	return 0, false
+}
+
+// backfillNoRegister gives every pasien without a register number one, in
+// id_pasien order, counting in the year of its tanggal_register. Patients
+// whose tanggal_register cannot be parsed are numbered in the current year
+// and reported.
+func backfillNoRegister(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	collection := db.Collection("pasien")
+
+	filter := bson.M{pasien.RegisterField: bson.M{"$exists": false}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "id_pasien", Value: 1}}))
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	// In a dry run the counters are simulated so the report shows the
+	// numbers that would be assigned.
+	simulated := map[string]int64{}
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return report, err
+		}
+		report.Scanned++
+
+		layanan, ok := layananOf(doc)
+		if !ok {
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v: tidak terdaftar di layanan manapun", doc["id_pasien"]))
+			continue
+		}
+
+		year, ok := pasien.RegisterYear(doc)
+		if !ok {
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v: tanggal_register %v tidak dikenali, memakai tahun %d", doc["id_pasien"], doc["tanggal_register"], year))
+		}
+
+		var noRegister string
+		if dryRun {
+			key := fmt.Sprintf("%d-%d", layanan, year)
+			if _, ok := simulated[key]; !ok {
+				var counter bson.M
+				err := db.Collection("register_counter").FindOne(ctx, bson.M{"_id": key}).Decode(&counter)
+				if err != nil && err != mongo.ErrNoDocuments {
+					return report, err
+				}
+				simulated[key], _ = counter["seq_value"].(int64)
+			}
+			simulated[key]++
+			noRegister, err = pasien.FormatRegister(layanan, year, simulated[key])
+		} else {
+			noRegister, err = pasien.NextRegister(ctx, db, layanan, year)
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		update := bson.M{"$set": bson.M{
+			pasien.RegisterField: noRegister,
+			"search.no_register": pasien.NormalizeSearchValue("no_register", noRegister),
+		}}
+		if _, err := collection.UpdateByID(ctx, doc["_id"], update); err != nil {
+			return report, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return report, err
+	}
+
+	if !dryRun {
+		if err := pasien.EnsureSearchIndexes(ctx, db); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
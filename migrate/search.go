@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillSearch writes the normalised "search" sub-document on pasien
+// documents created before searchpasien existed and builds its indexes.
+func backfillSearch(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	collection := db.Collection("pasien")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return report, err
+		}
+		report.Scanned++
+		if err := pasien.Decrypt(doc); err != nil {
+			return report, err
+		}
+
+		search := pasien.SearchFields(doc)
+		if stored, ok := doc["search"].(bson.M); ok && reflect.DeepEqual(stored, search) {
+			continue
+		}
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		update, err := pasien.Encrypted(bson.M{"search": search})
+		if err != nil {
+			return report, err
+		}
+		if _, err := collection.UpdateByID(ctx, doc["_id"], bson.M{"$set": update}); err != nil {
+			return report, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return report, err
+	}
+
+	if !dryRun {
+		if err := pasien.EnsureSearchIndexes(ctx, db); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
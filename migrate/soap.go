@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// typeSoap rewrites SOAP records into the typed shape, see convertSoap:
+// S/O/A/P at the root for every layanan, the visit date in tglDatang, measurements
+// under vital and every other form field under lainnya. Documents that
+// already have vital are done; anonymised ones are left alone. Records
+// without a usable id_pasien or date are reported and not touched.
+func typeSoap(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+
+	for _, c := range soapCollections {
+		collection := db.Collection(c.collection)
+		filter := bson.M{"vital": bson.M{"$exists": false}, "anonymised_at": bson.M{"$exists": false}}
+		cursor, err := collection.Find(ctx, filter)
+		if err != nil {
+			return report, err
+		}
+
+		for cursor.Next(ctx) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return report, err
+			}
+			report.Scanned++
+
+			record, problems := convertSoap(c.layanan, doc)
+			if record.IDPasien == 0 || record.TglDatang.IsZero() {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s %v: %v, not converted", collection.Name(), doc["_id"], problems))
+				continue
+			}
+			if len(problems) > 0 {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s %v: %v, kept under lainnya", collection.Name(), doc["_id"], problems))
+			}
+
+			report.Updated++
+			if dryRun {
+				continue
+			}
+			if _, err := collection.ReplaceOne(ctx, bson.M{"_id": doc["_id"]}, record); err != nil {
+				cursor.Close(ctx)
+				return report, err
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,302 @@
+package migrate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The conversion typeSoap does is kept here as it was when the migration
+// was written, so that later changes to soap.Record and its parser do not
+// change what an old database is migrated into.
+
+// soapCollections are the SOAP collections typeSoap converts, in order.
+var soapCollections = []struct {
+	layanan    int
+	collection string
+}{
+	{pasien.LayananKB, "soap_kb"},
+	{pasien.LayananKehamilan, "soap_kehamilan"},
+	{pasien.LayananImunisasi, "soap_imunisasi"},
+}
+
+type soapVital struct {
+	Sistolik     *float64 `bson:"sistolik,omitempty"`
+	Diastolik    *float64 `bson:"diastolik,omitempty"`
+	BeratBadan   *float64 `bson:"beratBadan,omitempty"`
+	TinggiBadan  *float64 `bson:"tinggiBadan,omitempty"`
+	Suhu         *float64 `bson:"suhu,omitempty"`
+	Nadi         *float64 `bson:"nadi,omitempty"`
+	TinggiFundus *float64 `bson:"tinggiFundus,omitempty"`
+	DJJ          *float64 `bson:"djj,omitempty"`
+	Hb           *float64 `bson:"hb,omitempty"`
+}
+
+func (v *soapVital) field(key string) **float64 {
+	switch key {
+	case "sistolik":
+		return &v.Sistolik
+	case "diastolik":
+		return &v.Diastolik
+	case "beratBadan":
+		return &v.BeratBadan
+	case "tinggiBadan":
+		return &v.TinggiBadan
+	case "suhu":
+		return &v.Suhu
+	case "nadi":
+		return &v.Nadi
+	case "tinggiFundus":
+		return &v.TinggiFundus
+	case "djj":
+		return &v.DJJ
+	case "hb":
+		return &v.Hb
+	}
+	return nil
+}
+
+type soapRecord struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty"`
+	IDPasien  pasien.ID              `bson:"id_pasien"`
+	IDLayanan int                    `bson:"id_layanan"`
+	TglDatang time.Time              `bson:"tglDatang"`
+	S         string                 `bson:"s"`
+	O         string                 `bson:"o"`
+	A         string                 `bson:"a"`
+	P         string                 `bson:"p"`
+	Vital     soapVital              `bson:"vital"`
+	Tindakan  string                 `bson:"tindakan,omitempty"`
+	Lainnya   map[string]interface{} `bson:"lainnya,omitempty"`
+}
+
+var soapVitalRanges = []struct {
+	key       string
+	aliases   []string
+	min, max  float64
+	unit      string
+	whole     bool
+	kehamilan bool
+}{
+	{key: "sistolik", min: 50, max: 260, unit: "mmHg", whole: true},
+	{key: "diastolik", min: 30, max: 160, unit: "mmHg", whole: true},
+	{key: "beratBadan", aliases: []string{"bb"}, min: 0.3, max: 250, unit: "kg"},
+	{key: "tinggiBadan", aliases: []string{"tb", "panjangBadan", "pb"}, min: 20, max: 230, unit: "cm"},
+	{key: "suhu", min: 30, max: 45, unit: "°C"},
+	{key: "nadi", min: 30, max: 250, unit: "x/menit", whole: true},
+	{key: "tinggiFundus", aliases: []string{"tfu"}, min: 5, max: 50, unit: "cm", kehamilan: true},
+	{key: "djj", min: 60, max: 220, unit: "x/menit", whole: true, kehamilan: true},
+	{key: "hb", min: 3, max: 25, unit: "g/dL"},
+}
+
+func soapNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case string:
+		s := strings.TrimSpace(strings.ReplaceAll(v, ",", "."))
+		if fields := strings.Fields(s); len(fields) == 2 {
+			s = fields[0]
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+	return 0, fmt.Errorf("bukan angka")
+}
+
+func soapEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	s, ok := value.(string)
+	return ok && strings.TrimSpace(s) == ""
+}
+
+func soapMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case bson.M:
+		return v, true
+	case bson.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+// soapSource reads a stored record field by field, remembering every key
+// read so the rest can go to lainnya.
+type soapSource struct {
+	layers []map[string]interface{}
+	used   []map[string]bool
+}
+
+func (s *soapSource) take(keys ...string) (interface{}, bool) {
+	var found interface{}
+	ok := false
+	for i, layer := range s.layers {
+		for _, key := range keys {
+			value, present := layer[key]
+			if !present {
+				continue
+			}
+			s.used[i][key] = true
+			if !ok && !soapEmpty(value) {
+				found, ok = value, true
+			}
+		}
+	}
+	return found, ok
+}
+
+func (s *soapSource) text(keys ...string) string {
+	value, ok := s.take(keys...)
+	if !ok {
+		return ""
+	}
+	if str, isString := value.(string); isString {
+		return strings.TrimSpace(str)
+	}
+	return fmt.Sprint(value)
+}
+
+func (s *soapSource) rest() map[string]interface{} {
+	rest := map[string]interface{}{}
+	for i, layer := range s.layers {
+		for key, value := range layer {
+			if !s.used[i][key] && !soapEmpty(value) {
+				rest[key] = value
+			}
+		}
+	}
+	return rest
+}
+
+func (s *soapSource) vital(layanan int) (vital soapVital, rejected map[string]interface{}, problems []string) {
+	rejected = map[string]interface{}{}
+
+	if td, ok := s.take("tekananDarah", "td"); ok {
+		parts := strings.Split(strings.TrimSuffix(strings.TrimSpace(fmt.Sprint(td)), "mmHg"), "/")
+		sistolik, errS := soapNumber(strings.TrimSpace(parts[0]))
+		var diastolik float64
+		errD := errS
+		if len(parts) == 2 {
+			diastolik, errD = soapNumber(strings.TrimSpace(parts[1]))
+		}
+		if len(parts) != 2 || errS != nil || errD != nil {
+			problems = append(problems, fmt.Sprintf("tekananDarah %v harus ditulis sistolik/diastolik, misalnya 120/80", td))
+			rejected["tekananDarah"] = td
+		} else {
+			vital.Sistolik, vital.Diastolik = &sistolik, &diastolik
+		}
+	}
+
+	for _, r := range soapVitalRanges {
+		value, ok := s.take(append([]string{r.key}, r.aliases...)...)
+		if !ok {
+			if current := *vital.field(r.key); current != nil {
+				value = *current
+			} else {
+				continue
+			}
+		}
+		*vital.field(r.key) = nil
+
+		number, err := soapNumber(value)
+		switch {
+		case r.kehamilan && layanan != pasien.LayananKehamilan:
+			problems = append(problems, fmt.Sprintf("%s hanya dicatat pada kunjungan kehamilan", r.key))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s %v bukan angka", r.key, value))
+		case r.whole && number != math.Trunc(number):
+			problems = append(problems, fmt.Sprintf("%s harus bilangan bulat", r.key))
+		case number < r.min || number > r.max:
+			problems = append(problems, fmt.Sprintf("%s %v di luar rentang %v-%v %s", r.key, number, r.min, r.max, r.unit))
+		default:
+			*vital.field(r.key) = &number
+			continue
+		}
+		rejected[r.key] = value
+	}
+
+	if vital.Sistolik != nil && vital.Diastolik != nil && *vital.Sistolik <= *vital.Diastolik {
+		problems = append(problems, "sistolik harus lebih besar dari diastolik")
+	}
+	return vital, rejected, problems
+}
+
+// convertSoap reads a SOAP document written before records were typed:
+// S/O/A/P at the root (KB, Imunisasi) or under soapAnc (Kehamilan) and the
+// measurements under vital, at the root or in soapAnc. Measurements that
+// cannot be read are reported and kept under lainnya.
+func convertSoap(layanan int, doc bson.M) (soapRecord, []string) {
+	record := soapRecord{IDLayanan: layanan}
+	if id, ok := doc["_id"].(primitive.ObjectID); ok {
+		record.ID = id
+	}
+	var problems []string
+
+	src := &soapSource{}
+	anc, _ := soapMap(doc["soapAnc"])
+	vitalData, _ := soapMap(doc["vital"])
+	for _, layer := range []map[string]interface{}{vitalData, doc, anc} {
+		if layer != nil {
+			src.layers = append(src.layers, layer)
+			src.used = append(src.used, map[string]bool{})
+		}
+	}
+	src.take("_id", "id_layanan", "soapAnc", "vital", "lainnya")
+
+	idPasien, ok := src.take("id_pasien")
+	if id, err := pasien.IDFrom(idPasien); !ok || err != nil {
+		problems = append(problems, "id_pasien tidak valid")
+	} else {
+		record.IDPasien = id
+	}
+
+	tanggal, ok := src.take("tglDatang", "tanggal")
+	if !ok {
+		problems = append(problems, "tglDatang needed")
+	} else if t, ok := pasien.AsTime(tanggal); ok {
+		record.TglDatang = t
+	} else {
+		problems = append(problems, fmt.Sprintf("tglDatang %v tidak valid", tanggal))
+	}
+
+	record.S = src.text("s", "subjektif")
+	record.O = src.text("o", "objektif")
+	record.A = src.text("a", "analisa", "asesmen")
+	record.P = src.text("p", "penatalaksanaan", "planning")
+	record.Tindakan = src.text("tindakan")
+
+	vital, rejected, vitalProblems := src.vital(layanan)
+	record.Vital = vital
+	problems = append(problems, vitalProblems...)
+
+	lainnya := map[string]interface{}{}
+	if existing, ok := soapMap(doc["lainnya"]); ok {
+		for key, value := range existing {
+			lainnya[key] = value
+		}
+	}
+	for _, extra := range []map[string]interface{}{src.rest(), rejected} {
+		for key, value := range extra {
+			lainnya[key] = value
+		}
+	}
+	if len(lainnya) > 0 {
+		record.Lainnya = lainnya
+	}
+	return record, problems
+}
@@ -0,0 +1,46 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConvertSoap(t *testing.T) {
+	id := primitive.NewObjectID()
+	record, problems := convertSoap(pasien.LayananKehamilan, bson.M{
+		"_id":       id,
+		"id_pasien": int32(7),
+		"tglDatang": "2024-03-05",
+		"soapAnc":   bson.M{"s": "mual", "td": "120/80", "djj": "abc", "hasilUsg": "normal"},
+	})
+	if len(problems) != 1 {
+		t.Errorf("problems = %q, want the djj", problems)
+	}
+	if record.ID != id || record.IDPasien != 7 || record.S != "mual" {
+		t.Errorf("record = %+v", record)
+	}
+	if *record.Vital.Sistolik != 120 || *record.Vital.Diastolik != 80 || record.Vital.DJJ != nil {
+		t.Errorf("vital = %+v", record.Vital)
+	}
+	if !reflect.DeepEqual(record.Lainnya, map[string]interface{}{"djj": "abc", "hasilUsg": "normal"}) {
+		t.Errorf("lainnya = %v", record.Lainnya)
+	}
+
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored bson.M
+	if err := bson.Unmarshal(raw, &stored); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"version", "diagnosis", "resep", "penulis"} {
+		if _, ok := stored[key]; ok {
+			t.Errorf("%s written by the migration: %v", key, stored)
+		}
+	}
+}
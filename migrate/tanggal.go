@@ -0,0 +1,112 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// dateColumn is a field stored as a string by older handlers. dateOnly
+// fields are truncated to midnight Jakarta.
+type dateColumn struct {
+	field    string
+	fallback string
+	dateOnly bool
+}
+
+var dateColumns = map[string][]dateColumn{
+	"pasien": {
+		{field: "tanggal_register"},
+		{field: "tanggal_lahir", dateOnly: true},
+		{field: "tanggal_lahir_ayah", dateOnly: true},
+		{field: "tanggal_lahir_ibu", dateOnly: true},
+	},
+	"soap_kb":           {{field: "tglDatang"}},
+	"soap_kehamilan":    {{field: "tglDatang", fallback: "soapAnc.tanggal"}},
+	"soap_imunisasi":    {{field: "tglDatang"}},
+	"reservasi_layanan": {{field: "hariReservasi", dateOnly: true}},
+}
+
+// convertTanggal rewrites dates stored as strings in mixed formats as BSON
+// datetimes in Asia/Jakarta. Empty strings are removed. Values that cannot
+// be parsed are left as they are and reported.
+func convertTanggal(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	for _, name := range []string{"pasien", "soap_kb", "soap_kehamilan", "soap_imunisasi", "reservasi_layanan"} {
+		if err := convertTanggalIn(ctx, db.Collection(name), dateColumns[name], dryRun, &report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func convertTanggalIn(ctx context.Context, collection *mongo.Collection, columns []dateColumn, dryRun bool, report *Report) error {
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		report.Scanned++
+
+		set := bson.M{}
+		unset := bson.M{}
+		for _, column := range columns {
+			value, ok := doc[column.field]
+			if !ok && column.fallback != "" {
+				value, ok = pasien.Lookup(doc, column.fallback)
+			}
+			raw, isString := value.(string)
+			if !ok || !isString {
+				continue
+			}
+			if strings.TrimSpace(raw) == "" {
+				if _, stored := doc[column.field]; stored {
+					unset[column.field] = ""
+				}
+				continue
+			}
+
+			var converted interface{}
+			if column.dateOnly {
+				converted, err = pasien.ToDate(raw)
+			} else {
+				converted, err = pasien.ToDateTime(raw)
+			}
+			if err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s %v: %s %q tidak bisa dibaca", collection.Name(), doc["_id"], column.field, raw))
+				continue
+			}
+			set[column.field] = converted
+		}
+
+		update := bson.M{}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		if len(update) == 0 {
+			continue
+		}
+
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		if _, err := collection.UpdateByID(ctx, doc["_id"], update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,154 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// phoneCollections lists the phone fields outside pasien that are written
+// in E.164 since registpasien, registbidan and reservasi normalise them.
+var phoneCollections = []struct {
+	Collection string
+	Field      string
+}{
+	{"bidan", "phone_number"},
+	{"users", "phone_number"},
+	{"pending_users", "phone_number"},
+	{"reservasi_layanan", "noHP"},
+	{"reminder", "noHP"},
+}
+
+// normalizeTelepon rewrites every stored phone number to E.164 and rebuilds
+// search.no_hp to match. Numbers that do not validate are left as they are
+// and reported, and so are patients edited while the migration ran.
+func normalizeTelepon(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	report, err := normalizePasienTelepon(ctx, db, dryRun)
+	if err != nil {
+		return report, err
+	}
+	for _, c := range phoneCollections {
+		if err := normalizePhoneField(ctx, db.Collection(c.Collection), c.Field, dryRun, &report); err != nil {
+			return report, fmt.Errorf("%s: %v", c.Collection, err)
+		}
+	}
+	return report, nil
+}
+
+func normalizePasienTelepon(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	collection := db.Collection("pasien")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return report, err
+		}
+		report.Scanned++
+		if err := pasien.Decrypt(doc); err != nil {
+			return report, err
+		}
+
+		set := bson.M{}
+		if value, ok := doc["no_hp"].(string); ok {
+			if converted, changed := convertPhone(value, &report, fmt.Sprintf("id_pasien %v no_hp", doc["id_pasien"])); changed {
+				doc["no_hp"] = converted
+				set["no_hp"] = converted
+			}
+		}
+		// section2 is encrypted as a whole, so it is written back whole.
+		if value, ok := pasien.Lookup(doc, "data_kehamilan.section2.noTelp"); ok {
+			section2, _ := pasien.Lookup(doc, "data_kehamilan.section2")
+			s, _ := value.(string)
+			if converted, changed := convertPhone(s, &report, fmt.Sprintf("id_pasien %v section2.noTelp", doc["id_pasien"])); changed {
+				if section, ok := section2.(bson.M); ok {
+					section["noTelp"] = converted
+					set["data_kehamilan.section2"] = section
+				}
+			}
+		}
+
+		search := pasien.SearchFields(doc)
+		if stored, ok := doc["search"].(bson.M); !ok || !reflect.DeepEqual(stored, search) {
+			set["search"] = search
+		}
+		if len(set) == 0 {
+			continue
+		}
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		update, err := pasien.Encrypted(set)
+		if err != nil {
+			return report, err
+		}
+		filter := pasien.VersionFilter(bson.M{"_id": doc["_id"]}, pasien.Version(doc))
+		result, err := collection.UpdateOne(ctx, filter, bson.M{
+			"$set": update,
+			"$inc": bson.M{pasien.VersionField: int64(1)},
+		})
+		if err != nil {
+			return report, err
+		}
+		if result.MatchedCount == 0 {
+			report.Updated--
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v changed while migrating, run redo telepon again", doc["id_pasien"]))
+		}
+	}
+	return report, cursor.Err()
+}
+
+func normalizePhoneField(ctx context.Context, collection *mongo.Collection, field string, dryRun bool, report *Report) error {
+	cursor, err := collection.Find(ctx, bson.M{field: bson.M{"$type": "string"}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		report.Scanned++
+
+		converted, changed := convertPhone(doc[field].(string), report, fmt.Sprintf("%s %v %s", collection.Name(), doc["_id"], field))
+		if !changed {
+			continue
+		}
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		if _, err := collection.UpdateByID(ctx, doc["_id"], bson.M{"$set": bson.M{field: converted}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// convertPhone returns the E.164 form of value and whether it differs from
+// what is stored. Invalid numbers are added to the report's problems.
+func convertPhone(value string, report *Report, where string) (string, bool) {
+	if strings.TrimSpace(value) == "" {
+		return value, false
+	}
+	converted, err := pasien.NormalizePhone(value)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", where, err))
+		return value, false
+	}
+	return converted, converted != value
+}
@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillUmur prepares pasien documents for ages computed at read time.
+// Imunisasi patients whose birth date only lives in detailBayi get it copied
+// to tanggal_lahir. Every patient whose age cannot be computed gets a
+// peringatan_data entry so the frontend can ask the bidan to complete it;
+// the entry is removed again once the date is fixed.
+func backfillUmur(ctx context.Context, db *mongo.Database, dryRun bool) (Report, error) {
+	var report Report
+	collection := db.Collection("pasien")
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return report, err
+		}
+		report.Scanned++
+
+		set := bson.M{}
+		peringatan := []string{}
+
+		lahir, ok := pasien.TanggalLahir(doc)
+		if !ok {
+			peringatan = append(peringatan, "tanggal_lahir kosong atau formatnya tidak dikenali, umur memakai nilai dari formulir")
+		} else if _, stored := doc["tanggal_lahir"]; !stored {
+			set["tanggal_lahir"], _ = pasien.ToDate(lahir)
+		}
+
+		if _, ok := doc["data_imunisasi"]; ok {
+			for _, orangTua := range []string{"ayah", "ibu"} {
+				if _, ok := doc["tanggal_lahir_"+orangTua]; !ok && doc["umur_"+orangTua] != nil {
+					peringatan = append(peringatan, fmt.Sprintf("tanggal_lahir_%s kosong, umur_%s memakai nilai dari formulir", orangTua, orangTua))
+				}
+			}
+		}
+
+		update := bson.M{}
+		if len(peringatan) > 0 {
+			existing, _ := doc["peringatan_data"].(bson.A)
+			if !reflect.DeepEqual(toStrings(existing), peringatan) {
+				set["peringatan_data"] = peringatan
+			}
+			report.Problems = append(report.Problems, fmt.Sprintf("id_pasien %v: %v", doc["id_pasien"], peringatan))
+		} else if _, ok := doc["peringatan_data"]; ok {
+			update["$unset"] = bson.M{"peringatan_data": ""}
+		}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(update) == 0 {
+			continue
+		}
+
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		if _, err := collection.UpdateByID(ctx, doc["_id"], update); err != nil {
+			return report, err
+		}
+	}
+	return report, cursor.Err()
+}
+
+func toStrings(values bson.A) []string {
+	result := []string{}
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package obat
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// data is the bundled drug master list, see obat.tsv.
+//
+//go:embed obat.tsv
+var data string
+
+// Obat is one drug or contraceptive of the master list. Satuan is the unit
+// a prescribed quantity is counted in, Rute the usual route.
+type Obat struct {
+	Kode    string `bson:"kode" json:"kode"`
+	Nama    string `bson:"nama" json:"nama"`
+	Sediaan string `bson:"sediaan" json:"sediaan"`
+	Satuan  string `bson:"satuan" json:"satuan"`
+	Rute    string `bson:"rute" json:"rute"`
+}
+
+// Rute maps every way a route is written, lowercased, to the name it is
+// stored with.
+var Rute = map[string]string{
+	"oral":          "oral",
+	"po":            "oral",
+	"intramuskular": "intramuskular",
+	"im":            "intramuskular",
+	"intravena":     "intravena",
+	"iv":            "intravena",
+	"subkutan":      "subkutan",
+	"sc":            "subkutan",
+	"intradermal":   "intradermal",
+	"subdermal":     "subdermal",
+	"intrauterin":   "intrauterin",
+	"vaginal":       "vaginal",
+	"topikal":       "topikal",
+	"mata":          "mata",
+	"infiltrasi":    "infiltrasi",
+	"lainnya":       "lainnya",
+}
+
+var (
+	loadOnce sync.Once
+	list     []Obat
+	byKode   map[string]Obat
+)
+
+func load() {
+	byKode = map[string]Obat{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		o := Obat{Kode: fields[0], Nama: fields[1], Sediaan: fields[2], Satuan: fields[3], Rute: fields[4]}
+		list = append(list, o)
+		byKode[o.Kode] = o
+	}
+}
+
+// Lookup returns the drug with kode, ignoring case.
+func Lookup(kode string) (Obat, bool) {
+	loadOnce.Do(load)
+	o, ok := byKode[strings.ToLower(strings.TrimSpace(kode))]
+	return o, ok
+}
+
+// Search returns the drugs whose code, name or strength contain every word
+// of q, in the order of the master list. An empty q returns all of them.
+func Search(q string) []Obat {
+	loadOnce.Do(load)
+	words := strings.Fields(strings.ToLower(q))
+	result := []Obat{}
+	for _, o := range list {
+		text := strings.ToLower(o.Kode + " " + o.Nama + " " + o.Sediaan)
+		all := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				all = false
+				break
+			}
+		}
+		if all {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Handler serves GET /api/obat?q= for the prescription lines of the SOAP
+// form.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithJSON(w, http.StatusMethodNotAllowed, map[string]string{"message": "Method not allowed"})
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": Search(r.URL.Query().Get("q"))})
+}
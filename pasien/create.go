@@ -0,0 +1,69 @@
+package pasien
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NextID increments pasien_counter and returns the new id_pasien. Call it
+// inside the transaction that inserts the patient, so an aborted insert
+// gives the id back.
+func NextID(ctx context.Context, db *mongo.Database) (ID, error) {
+	update := bson.M{"$inc": bson.M{"seq_value": int64(1)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var counter bson.M
+	if err := db.Collection("pasien_counter").FindOneAndUpdate(ctx, bson.M{}, update, opts).Decode(&counter); err != nil {
+		return 0, err
+	}
+
+	id, err := IDFrom(counter["seq_value"])
+	if err != nil {
+		return 0, fmt.Errorf("seq_value: %v", err)
+	}
+	return id, nil
+}
+
+// Insert allocates the next id_pasien and register number of the layanan
+// and inserts doc under them in a single transaction, so either all three
+// happen or none does. The register number counts in the year of
+// tanggal_register, see RegisterYear. doc gets id_pasien and no_register
+// set and the id is returned; the stored copy has its sensitive fields
+// encrypted.
+func Insert(ctx context.Context, db *mongo.Database, layanan int, doc bson.M) (ID, error) {
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	id, err := session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		id, err := NextID(sessCtx, db)
+		if err != nil {
+			return nil, err
+		}
+		year, _ := RegisterYear(doc)
+		noRegister, err := NextRegister(sessCtx, db, layanan, year)
+		if err != nil {
+			return nil, err
+		}
+		doc["id_pasien"] = id
+		doc[RegisterField] = noRegister
+		doc["search"] = SearchFields(doc)
+		stored, err := Encrypted(doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Collection("pasien").InsertOne(sessCtx, stored); err != nil {
+			return nil, err
+		}
+		return id, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id.(ID), nil
+}
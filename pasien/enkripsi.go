@@ -0,0 +1,756 @@
+package pasien
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EncryptedFields lists the paths of a pasien document that are encrypted
+// before they are written. An encrypted field can only be found by an exact
+// match, and only if it is deterministic, or by prefix through
+// BlindPrefixFields. ENCRYPTED_FIELDS (comma separated) replaces the list.
+var EncryptedFields = []string{
+	"nama_pasien",
+	"nama_pasangan",
+	"nama_ibu",
+	"nama_ayah",
+	"nik",
+	"no_jkn",
+	"no_hp",
+	"alamat",
+	"data_kb.skrining",
+	"data_kb.penapisan_kb",
+	"data_kehamilan.section2",
+}
+
+// DeterministicFields are encrypted so that the same value always gives the
+// same ciphertext, which keeps the nik unique index and exact lookups on
+// them working. They are encrypted even when missing from EncryptedFields.
+// DETERMINISTIC_FIELDS (comma separated) replaces the list.
+var DeterministicFields = []string{"nik", "no_jkn", "no_hp"}
+
+const (
+	// encPrefix marks a value encrypted with its own data key:
+	// enc1:<key id>:<wrapped data key>:<ciphertext>.
+	encPrefix = "enc1:"
+	// detPrefix marks a deterministically encrypted string:
+	// det1:<key id>:<ciphertext>.
+	detPrefix = "det1:"
+)
+
+// ErrNoKeyring is returned when an encrypted value is read while
+// ENCRYPTION_KEY_FILE is not set.
+var ErrNoKeyring = errors.New("ENCRYPTION_KEY_FILE tidak diatur, data terenkripsi tidak bisa dibaca")
+
+func fieldList(env string, defaults []string) []string {
+	value, ok := os.LookupEnv(env)
+	if !ok {
+		return defaults
+	}
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// BlindPrefixFields are the search keys found by prefix. When the field a
+// key is built from is encrypted, search holds the blind indexes of its
+// prefixes instead of the normalised value: every prefix of up to
+// maxBlindPrefix runes that starts a word, so "siti ami" and "ami" both find
+// "siti aminah". The blind indexes do not reveal the value, but they do
+// show which patients share a prefix.
+var BlindPrefixFields = []string{"nama", "no_hp"}
+
+const maxBlindPrefix = 32
+
+// encryptedSources returns the configured encrypted paths and whether each
+// is deterministic.
+func encryptedSources() map[string]bool {
+	paths := map[string]bool{}
+	for _, path := range fieldList("ENCRYPTED_FIELDS", EncryptedFields) {
+		paths[path] = false
+	}
+	for _, path := range fieldList("DETERMINISTIC_FIELDS", DeterministicFields) {
+		paths[path] = true
+	}
+	return paths
+}
+
+// blindPrefixKeys returns the BlindPrefixFields built from an encrypted
+// field.
+func blindPrefixKeys() map[string]bool {
+	sources := encryptedSources()
+	keys := map[string]bool{}
+	for _, key := range BlindPrefixFields {
+		for _, source := range SearchableFields[key] {
+			if _, ok := sources[source]; ok {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// protectedPaths returns every encrypted path and whether it is
+// deterministic. A search key built from an encrypted field is encrypted
+// the same way, so the normalised copy does not leak the value, unless it
+// is stored as blind prefixes.
+func protectedPaths() map[string]bool {
+	paths := encryptedSources()
+	prefixed := blindPrefixKeys()
+	for key, sources := range SearchableFields {
+		if prefixed[key] {
+			continue
+		}
+		for _, source := range sources {
+			if deterministic, ok := paths[source]; ok {
+				paths["search."+key] = paths["search."+key] || deterministic
+			}
+		}
+	}
+	return paths
+}
+
+// IsEncrypted reports whether the value at path is stored encrypted. Such a
+// value can only be written as a whole, never one sub-field at a time.
+func IsEncrypted(path string) bool {
+	_, ok := protectedPaths()[path]
+	return ok
+}
+
+// Keyring holds the master keys from the key file. New values are always
+// encrypted with the active key; the others are kept to read values written
+// before the last rotation. The blind key is never rotated: it keys the
+// blind indexes, which must stay equal for equal values across rotations.
+type Keyring struct {
+	Active string
+	keys   map[string][]byte
+	blind  []byte
+}
+
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+	Blind  string            `json:"blind,omitempty"`
+}
+
+// LoadKeyring reads a key file of the form
+//
+//	{"active": "20261019", "keys": {"20261019": "<base64 of 32 bytes>"}, "blind": "<base64 of 32 bytes>"}
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	keyring := &Keyring{Active: file.Active, keys: map[string][]byte{}}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s: kunci %q harus base64 dari 32 byte", path, id)
+		}
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("%s: id kunci %q tidak valid", path, id)
+		}
+		keyring.keys[id] = key
+	}
+	if _, ok := keyring.keys[keyring.Active]; !ok {
+		return nil, fmt.Errorf("%s: kunci aktif %q tidak ada", path, keyring.Active)
+	}
+	blind, err := base64.StdEncoding.DecodeString(file.Blind)
+	if err != nil || len(blind) != 32 {
+		return nil, fmt.Errorf("%s: kunci blind harus base64 dari 32 byte, jalankan migrate rotate-key untuk membuatnya", path)
+	}
+	keyring.blind = blind
+	return keyring, nil
+}
+
+var (
+	keyringMu      sync.Mutex
+	keyringCache   *Keyring
+	keyringModTime time.Time
+)
+
+// Keys returns the keyring from ENCRYPTION_KEY_FILE, or nil when the
+// variable is not set and encryption is off. The file is read again when it
+// changes, so a rotation is picked up without a restart.
+func Keys() (*Keyring, error) {
+	path := os.Getenv("ENCRYPTION_KEY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	if keyringCache != nil && info.ModTime().Equal(keyringModTime) {
+		return keyringCache, nil
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+	keyringCache, keyringModTime = keyring, info.ModTime()
+	return keyring, nil
+}
+
+// GenerateKey adds a new random key to the key file at path, creating the
+// file if needed, and makes it the active key. Values encrypted with older
+// keys stay readable; run the enkripsi migration again to re-encrypt them.
+// A blind key is added the first time and kept afterwards.
+func GenerateKey(path string) (string, error) {
+	file := keyFile{Keys: map[string]string{}}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &file); err != nil {
+			return "", fmt.Errorf("%s: %v", path, err)
+		}
+		if file.Keys == nil {
+			file.Keys = map[string]string{}
+		}
+	case !os.IsNotExist(err):
+		return "", err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	if file.Blind == "" {
+		blind := make([]byte, 32)
+		if _, err := rand.Read(blind); err != nil {
+			return "", err
+		}
+		file.Blind = base64.StdEncoding.EncodeToString(blind)
+	}
+	// Two rotations in the same second must not overwrite a key.
+	base := time.Now().In(Jakarta).Format("20060102150405")
+	id := base
+	for i := 2; file.Keys[id] != ""; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	file.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	file.Active = id
+
+	data, err = json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+
+	keyringMu.Lock()
+	keyringCache = nil
+	keyringMu.Unlock()
+	return id, nil
+}
+
+// derive gives each use of a master key its own key.
+func derive(master []byte, label string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(key, nonce, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if nonce == nil {
+		nonce = make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext terlalu pendek")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+var encoding = base64.RawStdEncoding
+
+// encryptValue encrypts one value with the active key. The path is
+// authenticated with it, so a ciphertext copied to another field does not
+// decrypt.
+func (k *Keyring) encryptValue(path string, value interface{}, deterministic bool) (string, error) {
+	master := k.keys[k.Active]
+	if s, ok := value.(string); ok && deterministic {
+		// The nonce is a MAC of the value, which makes equal values encrypt
+		// equally and different values never share a nonce.
+		mac := hmac.New(sha256.New, derive(master, "det-nonce"))
+		mac.Write([]byte(path + "\x00" + s))
+		sealed, err := seal(derive(master, "det"), mac.Sum(nil)[:12], []byte(s), []byte(path))
+		if err != nil {
+			return "", err
+		}
+		return detPrefix + k.Active + ":" + encoding.EncodeToString(sealed), nil
+	}
+
+	plaintext, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return "", err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(derive(master, "kek"), nil, dataKey, []byte(k.Active))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(dataKey, nil, plaintext, []byte(path))
+	if err != nil {
+		return "", err
+	}
+	return encPrefix + k.Active + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(sealed), nil
+}
+
+func isCiphertext(s string) bool {
+	return strings.HasPrefix(s, encPrefix) || strings.HasPrefix(s, detPrefix)
+}
+
+// keyID returns the id of the key a ciphertext was written with.
+func keyID(s string) string {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+func (k *Keyring) decryptValue(path, s string) (interface{}, error) {
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+	parts := strings.Split(s, ":")
+	master, ok := k.keys[keyID(s)]
+	if !ok {
+		return nil, fmt.Errorf("%s: kunci %q tidak ada di key file", path, keyID(s))
+	}
+
+	switch {
+	case strings.HasPrefix(s, detPrefix) && len(parts) == 3:
+		sealed, err := encoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		plaintext, err := open(derive(master, "det"), sealed, []byte(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return string(plaintext), nil
+
+	case strings.HasPrefix(s, encPrefix) && len(parts) == 4:
+		wrapped, err := encoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		sealed, err := encoding.DecodeString(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		dataKey, err := open(derive(master, "kek"), wrapped, []byte(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		plaintext, err := open(dataKey, sealed, []byte(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		var wrapper bson.M
+		if err := bson.Unmarshal(plaintext, &wrapper); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return wrapper["v"], nil
+	}
+	return nil, fmt.Errorf("%s: format ciphertext tidak dikenal", path)
+}
+
+// Encrypted returns a copy of fields with every protected value encrypted
+// and NIKBlindField set to match nik when nik is written. fields is either
+// a whole document or the argument of a $set, whose keys may be dotted
+// paths; fields itself is left untouched. Values that are nil or already
+// encrypted are kept as they are. Without a keyring nothing is encrypted.
+func Encrypted(fields bson.M) (bson.M, error) {
+	keyring, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+	result := copyMap(fields)
+	if nik, ok := fields["nik"]; ok {
+		if result[NIKBlindField], err = keyring.blindOf("nik", nik); err != nil {
+			return nil, err
+		}
+	}
+	if keyring == nil {
+		return result, nil
+	}
+	keyring.blindPrefixesIn(result)
+
+	paths := protectedPaths()
+	names := make([]string, 0, len(paths))
+	for path := range paths {
+		names = append(names, path)
+	}
+	sort.Strings(names)
+
+	for _, path := range names {
+		if err := keyring.encryptIn(result, path, path, paths[path]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func copyMap(m map[string]interface{}) bson.M {
+	result := bson.M{}
+	for key, value := range m {
+		result[key] = value
+	}
+	return result
+}
+
+// encryptIn encrypts the value at rel inside m, copying every sub-document
+// it descends into. full is the path of the value in the whole document.
+func (k *Keyring) encryptIn(m bson.M, full, rel string, deterministic bool) error {
+	for key, value := range m {
+		switch {
+		case key == rel:
+			if s, ok := value.(string); value == nil || ok && isCiphertext(s) {
+				continue
+			}
+			encrypted, err := k.encryptValue(full, value, deterministic)
+			if err != nil {
+				return err
+			}
+			m[key] = encrypted
+
+		case strings.HasPrefix(rel, key+"."):
+			sub, ok := asMap(value)
+			if !ok {
+				continue
+			}
+			copied := copyMap(sub)
+			if err := k.encryptIn(copied, full, strings.TrimPrefix(rel, key+"."), deterministic); err != nil {
+				return err
+			}
+			m[key] = copied
+
+		case strings.HasPrefix(key, rel+"."):
+			return fmt.Errorf("%s terenkripsi dan hanya bisa diubah utuh", full)
+		}
+	}
+	return nil
+}
+
+// Decrypt replaces every encrypted value in a document read from the pasien
+// collection with its plaintext.
+func Decrypt(doc bson.M) error {
+	keyring, err := Keys()
+	if err != nil {
+		return err
+	}
+	return keyring.decryptIn(doc, "")
+}
+
+func (k *Keyring) decryptIn(value interface{}, path string) error {
+	switch v := value.(type) {
+	case bson.M:
+		for key, child := range v {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			if s, ok := child.(string); ok && isCiphertext(s) {
+				plain, err := k.decryptValue(childPath, s)
+				if err != nil {
+					return err
+				}
+				v[key] = plain
+				continue
+			}
+			if err := k.decryptIn(child, childPath); err != nil {
+				return err
+			}
+		}
+	case primitive.A:
+		for _, child := range v {
+			if err := k.decryptIn(child, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Encrypt encrypts value with the active key, for data outside the pasien
+// collection that is as sensitive as its protected fields. path is
+// authenticated with the ciphertext and must be given again to DecryptValue.
+// ok is false when encryption is off and value is to be stored as it is.
+func Encrypt(path string, value interface{}) (ciphertext string, ok bool, err error) {
+	keyring, err := Keys()
+	if err != nil || keyring == nil {
+		return "", false, err
+	}
+	ciphertext, err = keyring.encryptValue(path, value, false)
+	return ciphertext, err == nil, err
+}
+
+// DecryptValue returns the value Encrypt encrypted for path.
+func DecryptValue(path, ciphertext string) (interface{}, error) {
+	keyring, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+	return keyring.decryptValue(path, ciphertext)
+}
+
+// CurrentKey reports whether ciphertext was written with the active key, so
+// a value that is not needs encrypting again after a rotation.
+func CurrentKey(ciphertext string) (bool, error) {
+	keyring, err := Keys()
+	if err != nil || keyring == nil {
+		return false, err
+	}
+	return keyID(ciphertext) == keyring.Active, nil
+}
+
+// Blind returns the values a stored field can hold for value: the
+// ciphertext under every key for a deterministic field, plus the plaintext
+// for documents not migrated yet. Use it with $in for exact lookups.
+func Blind(path, value string) ([]string, error) {
+	candidates := []string{value}
+	keyring, err := Keys()
+	if err != nil || keyring == nil {
+		return candidates, err
+	}
+	if deterministic, ok := protectedPaths()[path]; !ok || !deterministic {
+		return candidates, nil
+	}
+	for id := range keyring.keys {
+		encrypted, err := (&Keyring{Active: id, keys: keyring.keys}).encryptValue(path, value, true)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, encrypted)
+	}
+	return candidates, nil
+}
+
+// NIKBlindField holds the blind index of nik. Unlike the ciphertext in nik
+// it does not change with the active key, so the nik_unique index on it
+// holds across a key rotation.
+const NIKBlindField = "nik_blind"
+
+// BlindIndex returns the blind index of value at path: a MAC under the
+// blind key, or value itself when encryption is off.
+func BlindIndex(path, value string) (string, error) {
+	keyring, err := Keys()
+	if err != nil {
+		return "", err
+	}
+	return keyring.blindIndex(path, value), nil
+}
+
+func (k *Keyring) blindIndex(path, value string) string {
+	if k == nil {
+		return value
+	}
+	mac := hmac.New(sha256.New, derive(k.blind, path))
+	mac.Write([]byte(value))
+	return "blind1:" + encoding.EncodeToString(mac.Sum(nil))
+}
+
+// blindOf returns the blind index of a stored or submitted value, which may
+// already be encrypted, or nil when there is no value.
+func (k *Keyring) blindOf(path string, value interface{}) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, nil
+	}
+	if isCiphertext(s) {
+		plain, err := k.decryptValue(path, s)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok = plain.(string); !ok {
+			return nil, nil
+		}
+	}
+	return k.blindIndex(path, s), nil
+}
+
+// blindPrefixesIn replaces the normalised value of every blind prefixed
+// search key in fields, under "search" or as a dotted "search." path, with
+// its blind prefixes. A search sub-document is copied before it is changed.
+func (k *Keyring) blindPrefixesIn(fields bson.M) {
+	prefixed := blindPrefixKeys()
+	search, hasSearch := asMap(fields["search"])
+	if hasSearch {
+		search = copyMap(search)
+		fields["search"] = search
+	}
+	for key := range prefixed {
+		if s, ok := fields["search."+key].(string); ok {
+			fields["search."+key] = k.blindPrefixes(key, s)
+		}
+		if s, ok := search[key].(string); hasSearch && ok {
+			search[key] = k.blindPrefixes(key, s)
+		}
+	}
+}
+
+// blindPrefixes returns the blind indexes of the prefixes of a normalised
+// search value, see BlindPrefixFields.
+func (k *Keyring) blindPrefixes(key, normalized string) []string {
+	runes := []rune(normalized)
+	seen := map[string]bool{}
+	prefixes := []string{}
+	for start := range runes {
+		if start > 0 && runes[start-1] != ' ' || runes[start] == ' ' {
+			continue
+		}
+		for end := start + 1; end <= len(runes) && end-start <= maxBlindPrefix; end++ {
+			prefix := k.blindIndex("search."+key, string(runes[start:end]))
+			if !seen[prefix] {
+				seen[prefix] = true
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	return prefixes
+}
+
+// BlindPrefix returns the blind index to look up a normalised search value
+// by, when key is stored as blind prefixes. ok is false when it is not and
+// search.<key> holds the value itself. Values longer than the longest
+// stored prefix are cut to it, so they may also find a longer value that
+// only shares that prefix.
+func BlindPrefix(key, normalized string) (prefix string, ok bool, err error) {
+	keyring, err := Keys()
+	if err != nil || keyring == nil || !blindPrefixKeys()[key] {
+		return "", false, err
+	}
+	runes := []rune(normalized)
+	if len(runes) > maxBlindPrefix {
+		runes = runes[:maxBlindPrefix]
+	}
+	return keyring.blindIndex("search."+key, string(runes)), true, nil
+}
+
+// Reencrypt brings doc in line with the current configuration and active
+// key: plaintext in a protected field, a ciphertext written with an older key
+// or a field that is no longer protected makes it decrypt and encrypt the
+// whole document again, rebuilding search on the way. It returns the
+// document to store and whether it differs from doc.
+func Reencrypt(doc bson.M) (bson.M, bool, error) {
+	keyring, err := Keys()
+	if err != nil {
+		return nil, false, err
+	}
+	paths := protectedPaths()
+
+	stale := false
+	var check func(value interface{}, path string)
+	check = func(value interface{}, path string) {
+		switch v := value.(type) {
+		case string:
+			if isCiphertext(v) {
+				_, protected := paths[path]
+				stale = stale || !protected || keyring == nil || keyID(v) != keyring.Active
+			}
+		case bson.M:
+			for key, child := range v {
+				if path != "" {
+					key = path + "." + key
+				}
+				check(child, key)
+			}
+		case primitive.A:
+			for _, child := range v {
+				check(child, path)
+			}
+		}
+	}
+	check(doc, "")
+	if nik, ok := doc["nik"]; ok {
+		blind, err := keyring.blindOf("nik", nik)
+		if err != nil {
+			return nil, false, err
+		}
+		stale = stale || doc[NIKBlindField] != blind
+	}
+	// A blind prefixed key holds a string before it is encrypted and a list
+	// of blind prefixes after.
+	prefixed := blindPrefixKeys()
+	for _, key := range BlindPrefixFields {
+		value, ok := Lookup(doc, "search."+key)
+		_, isString := value.(string)
+		stale = stale || ok && isString == (prefixed[key] && keyring != nil)
+	}
+	if keyring != nil {
+		for path := range paths {
+			value, ok := Lookup(doc, path)
+			if s, isString := value.(string); ok && !(isString && isCiphertext(s)) {
+				stale = true
+			}
+		}
+	}
+	if !stale {
+		return doc, false, nil
+	}
+
+	if err := Decrypt(doc); err != nil {
+		return nil, false, err
+	}
+	doc["search"] = SearchFields(doc)
+	encrypted, err := Encrypted(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	return encrypted, true, nil
+}
@@ -0,0 +1,153 @@
+package pasien
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// withKeyFile points ENCRYPTION_KEY_FILE at a new key file for the test.
+func withKeyFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	t.Setenv("ENCRYPTION_KEY_FILE", path)
+	if _, err := GenerateKey(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNIKBlindSurvivesRotation(t *testing.T) {
+	path := withKeyFile(t)
+	const nik = "3201014101900001"
+
+	before, err := Encrypted(bson.M{"nik": nik})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateKey(path); err != nil {
+		t.Fatal(err)
+	}
+	after, err := Encrypted(bson.M{"nik": nik})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before["nik"] == after["nik"] {
+		t.Error("nik encrypted the same under two keys")
+	}
+	if before[NIKBlindField] != after[NIKBlindField] {
+		t.Errorf("blind index changed with the key: %v, then %v", before[NIKBlindField], after[NIKBlindField])
+	}
+	if before[NIKBlindField] == nik {
+		t.Error("blind index is the plaintext NIK")
+	}
+
+	// A ciphertext written under the old key gives the same blind index.
+	again, err := Encrypted(bson.M{"nik": before["nik"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[NIKBlindField] != before[NIKBlindField] {
+		t.Errorf("blind index of the old ciphertext is %v, want %v", again[NIKBlindField], before[NIKBlindField])
+	}
+}
+
+func TestNIKBlindWithoutEncryption(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY_FILE", "")
+	stored, err := Encrypted(bson.M{"nik": "3201014101900001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored[NIKBlindField] != "3201014101900001" {
+		t.Errorf("nik_blind is %v, want the NIK itself", stored[NIKBlindField])
+	}
+}
+
+func TestNIKBlindOnlyWithNIK(t *testing.T) {
+	withKeyFile(t)
+	stored, err := Encrypted(bson.M{"nama_pasien": "Siti"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored[NIKBlindField]; ok {
+		t.Error("nik_blind set by an update that does not write nik")
+	}
+}
+
+func TestNamaBlindPrefixes(t *testing.T) {
+	withKeyFile(t)
+	doc := bson.M{"nama_pasien": "Siti  Aminah", "no_hp": "+6281234567890"}
+	doc["search"] = SearchFields(doc)
+	stored, err := Encrypted(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := stored["nama_pasien"].(string); !isCiphertext(s) {
+		t.Errorf("nama_pasien stored as %v", stored["nama_pasien"])
+	}
+	if doc["search"].(bson.M)["nama"] != "siti aminah" {
+		t.Errorf("Encrypted changed the search sub-document of its argument: %v", doc["search"])
+	}
+	search := stored["search"].(bson.M)
+
+	for _, c := range []struct {
+		key, keyword string
+		found        bool
+	}{
+		{"nama", "Siti", true},
+		{"nama", "siti ami", true},
+		{"nama", "AMI", true},
+		{"nama", "minah", false},
+		{"nama", "aminah siti", false},
+		{"no_hp", "0812", true},
+		{"no_hp", "+62 812-3456-7890", true},
+		{"no_hp", "0813", false},
+	} {
+		prefix, ok, err := BlindPrefix(c.key, NormalizeSearchValue(c.key, c.keyword))
+		if err != nil || !ok {
+			t.Fatalf("BlindPrefix(%q) = %v, %v", c.keyword, ok, err)
+		}
+		found := false
+		for _, stored := range search[c.key].([]string) {
+			found = found || stored == prefix
+		}
+		if found != c.found {
+			t.Errorf("%s %q found = %v, want %v", c.key, c.keyword, found, c.found)
+		}
+	}
+}
+
+func TestReencryptStoresBlindPrefixes(t *testing.T) {
+	withKeyFile(t)
+	doc := bson.M{"nama_pasien": "Siti"}
+	doc["search"] = SearchFields(doc)
+	stored, changed, err := Reencrypt(doc)
+	if err != nil || !changed {
+		t.Fatalf("Reencrypt of a plaintext name = %v, %v", changed, err)
+	}
+	if _, ok := stored["search"].(bson.M)["nama"].([]string); !ok {
+		t.Errorf("search.nama stored as %v", stored["search"].(bson.M)["nama"])
+	}
+
+	// As read back from the database.
+	stored["search"] = bson.M{"nama": primitive.A{stored["search"].(bson.M)["nama"].([]string)[0]}}
+	if _, changed, err := Reencrypt(stored); err != nil || changed {
+		t.Errorf("Reencrypt of an encrypted name = %v, %v", changed, err)
+	}
+}
+
+func TestPrefixFilterWithoutEncryption(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY_FILE", "")
+	filter, err := PrefixFilter("nama", "Siti A.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"search.nama": bson.M{"$regex": `^siti a\.`}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("PrefixFilter = %v, want %v", filter, want)
+	}
+}
@@ -0,0 +1,128 @@
+package pasien
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// ID is the id_pasien of a patient. It is always written as a BSON int64
+// and a JSON number. Reading accepts everything older code stored or sent:
+// int32, int64, whole doubles and strings of digits.
+type ID int64
+
+// ParseID parses an id_pasien from a query parameter or form value.
+func ParseID(s string) (ID, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("id_pasien tidak valid: %q", s)
+	}
+	return ID(n), nil
+}
+
+// IDFrom converts an id_pasien decoded into an interface{} (from a bson.M
+// or a JSON body) to an ID.
+func IDFrom(value interface{}) (ID, error) {
+	switch v := value.(type) {
+	case ID:
+		return v, nil
+	case int32:
+		return ID(v), nil
+	case int64:
+		return ID(v), nil
+	case int:
+		return ID(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			break
+		}
+		return ID(v), nil
+	case float64:
+		if v != math.Trunc(v) || v > math.MaxInt64 {
+			break
+		}
+		return ID(v), nil
+	case json.Number:
+		return ParseID(v.String())
+	case string:
+		return ParseID(v)
+	}
+	return 0, fmt.Errorf("id_pasien tidak valid: %v (%T)", value, value)
+}
+
+// String formats the id as decimal digits.
+func (id ID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+// MarshalBSONValue stores the id as int64.
+func (id ID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.TypeInt64, bsoncore.AppendInt64(nil, int64(id)), nil
+}
+
+// UnmarshalBSONValue reads an id stored as int32, int64, double or string.
+func (id *ID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	var value interface{}
+	switch t {
+	case bson.TypeInt32:
+		v, _, ok := bsoncore.ReadInt32(data)
+		if !ok {
+			return fmt.Errorf("id_pasien: invalid int32")
+		}
+		value = v
+	case bson.TypeInt64:
+		v, _, ok := bsoncore.ReadInt64(data)
+		if !ok {
+			return fmt.Errorf("id_pasien: invalid int64")
+		}
+		value = v
+	case bson.TypeDouble:
+		v, _, ok := bsoncore.ReadDouble(data)
+		if !ok {
+			return fmt.Errorf("id_pasien: invalid double")
+		}
+		value = v
+	case bson.TypeString:
+		v, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return fmt.Errorf("id_pasien: invalid string")
+		}
+		value = v
+	default:
+		return fmt.Errorf("id_pasien: cannot decode BSON %s", t)
+	}
+	parsed, err := IDFrom(value)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// MarshalJSON writes the id as a JSON number.
+func (id ID) MarshalJSON() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a string of digits; the frontend sends
+// both.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	parsed, err := IDFrom(value)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
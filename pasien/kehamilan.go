@@ -0,0 +1,104 @@
+package pasien
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// kehamilanSections are the Kehamilan sub-documents the form has put the
+// HPHT and the estimated due date in.
+var kehamilanSections = []string{"data_kehamilan", "data_kehamilan.riwayat_kehamilan", "data_kehamilan.section2"}
+
+// hphtKeys name the first day of the last menstrual period; hplKeys the
+// estimated due date (HPL, taksiran persalinan).
+var (
+	hphtKeys = []string{"hpht", "HPHT", "tglHPHT", "tanggalHPHT"}
+	hplKeys  = []string{"hpl", "HPL", "taksiranPersalinan", "tp"}
+)
+
+// lamaKehamilan is the length of a pregnancy from HPHT to HPL (Naegele).
+const lamaKehamilan = 280 * 24 * time.Hour
+
+// HPHT returns the first day of the last menstrual period of a Kehamilan
+// patient. When only the due date was recorded it is counted back 280
+// days. The encrypted sections must be decrypted first.
+func HPHT(doc bson.M) (time.Time, bool) {
+	if t, ok := findKehamilan(doc, hphtKeys); ok {
+		return t, true
+	}
+	if t, ok := findKehamilan(doc, hplKeys); ok {
+		return t.Add(-lamaKehamilan), true
+	}
+	return time.Time{}, false
+}
+
+func findKehamilan(doc bson.M, keys []string) (time.Time, bool) {
+	for _, section := range kehamilanSections {
+		for _, key := range keys {
+			if t, ok := parseAt(doc, section+"."+key); ok {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// UsiaKehamilan returns the gestational age on visit in completed weeks and
+// the remaining days. ok is false when the visit is before hpht or more
+// than 45 weeks after it, i.e. it belongs to another pregnancy.
+func UsiaKehamilan(hpht, visit time.Time) (minggu, hari int, ok bool) {
+	y1, m1, d1 := hpht.In(Jakarta).Date()
+	y2, m2, d2 := visit.In(Jakarta).Date()
+	days := int(time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	if days < 0 || days > 45*7 {
+		return 0, 0, false
+	}
+	return days / 7, days % 7, true
+}
+
+// FormatUsiaKehamilan formats a gestational age as "12 minggu 3 hari".
+func FormatUsiaKehamilan(minggu, hari int) string {
+	return fmt.Sprintf("%d minggu %d hari", minggu, hari)
+}
+
+// Trimester returns the trimester for a gestational age in completed
+// weeks: 1 up to 13 weeks, 2 up to 27, 3 after.
+func Trimester(minggu int) int {
+	switch {
+	case minggu < 14:
+		return 1
+	case minggu < 28:
+		return 2
+	}
+	return 3
+}
+
+// MinimalANC is the minimum number of antenatal visits (K1-K6) in a
+// pregnancy, Permenkes 21/2021.
+const MinimalANC = 6
+
+// kunjunganTrimester is the number of the first visit of each trimester
+// and how many visits the trimester counts toward MinimalANC: K1-K2 in the
+// first, K3 in the second, K4-K6 in the third.
+var kunjunganTrimester = map[int]struct{ pertama, jumlah int }{
+	1: {1, 2},
+	2: {3, 1},
+	3: {4, 3},
+}
+
+// LabelKunjungan names the ke-th antenatal visit (counting from 1) within
+// a trimester by the Permenkes 21/2021 scheme. Visits beyond the ones the
+// trimester counts are labelled with its last visit and a plus, e.g. a
+// third visit in the first trimester is K2+.
+func LabelKunjungan(trimester, ke int) string {
+	k, ok := kunjunganTrimester[trimester]
+	if !ok || ke < 1 {
+		return ""
+	}
+	if ke > k.jumlah {
+		return fmt.Sprintf("K%d+", k.pertama+k.jumlah-1)
+	}
+	return fmt.Sprintf("K%d", k.pertama+ke-1)
+}
@@ -0,0 +1,26 @@
+package pasien
+
+import "testing"
+
+func TestLabelKunjungan(t *testing.T) {
+	tests := []struct {
+		trimester, ke int
+		want          string
+	}{
+		{1, 1, "K1"},
+		{1, 2, "K2"},
+		{1, 3, "K2+"},
+		{2, 1, "K3"},
+		{2, 2, "K3+"},
+		{3, 1, "K4"},
+		{3, 3, "K6"},
+		{3, 4, "K6+"},
+		{4, 1, ""},
+		{1, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := LabelKunjungan(tt.trimester, tt.ke); got != tt.want {
+			t.Errorf("LabelKunjungan(%d, %d) = %q, want %q", tt.trimester, tt.ke, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pasien
+
+// Layanan ids as sent by the frontend in id_layanan.
+const (
+	LayananKB        = 0
+	LayananKehamilan = 1
+	LayananImunisasi = 2
+)
+
+// LayananField is the sub-document that marks a pasien as registered for a
+// layanan, e.g. data_kb for KB.
+var LayananField = map[int]string{
+	LayananKB:        "data_kb",
+	LayananKehamilan: "data_kehamilan",
+	LayananImunisasi: "data_imunisasi",
+}
+
+// GeneralInformation maps each key of the generalInformation form section
+// to the path it is stored at, per layanan. It mirrors what input.Input
+// writes and what edit.Edit returns.
+var GeneralInformation = map[int]map[string]string{
+	LayananKB: {
+		"nik":               "nik",
+		"noJkn":             "no_jkn",
+		"noFaskes":          "data_kb.no_faskes",
+		"noSeriKartu":       "data_kb.no_seri_kartu",
+		"statusJkn":         "data_kb.status_jkn",
+		"tglDatang":         "tanggal_register",
+		"namaPeserta":       "nama_pasien",
+		"tglLahir":          "tanggal_lahir",
+		"usia":              "umur",
+		"namaPasangan":      "nama_pasangan",
+		"jenisPasangan":     "jenis_pasangan",
+		"pendidikanAkhir":   "pendidikan",
+		"alamat":            "alamat",
+		"pekerjaanPasangan": "pekerjaan_pasangan",
+		"noHP":              "no_hp",
+	},
+	LayananKehamilan: {
+		"nik":             "nik",
+		"noJkn":           "no_jkn",
+		"agama":           "data_kehamilan.agama",
+		"pekerjaan":       "data_kehamilan.pekerjaan",
+		"desa":            "data_kehamilan.desa",
+		"kabupaten":       "data_kehamilan.kabupaten",
+		"kecamatan":       "data_kehamilan.kecamatan",
+		"provinsi":        "data_kehamilan.provinsi",
+		"rtrw":            "data_kehamilan.rtrw",
+		"noIbu":           "data_kehamilan.no_ibu",
+		"tanggalRegister": "tanggal_register",
+		"namaLengkap":     "nama_pasien",
+		"tanggalLahir":    "tanggal_lahir",
+		"umur":            "umur",
+		"namaSuami":       "nama_pasangan",
+		"pendidikan":      "pendidikan",
+		"alamatDomisili":  "alamat",
+	},
+	LayananImunisasi: {
+		"nik":          "nik",
+		"noJkn":        "no_jkn",
+		"nomorBayi":    "nomor_bayi",
+		"tglDatang":    "tanggal_register",
+		"nomor":        "nomor",
+		"namaBayi":     "nama_pasien",
+		"tglLahir":     "tanggal_lahir",
+		"namaAyah":     "nama_ayah",
+		"usiaAyah":     "umur_ayah",
+		"tglLahirAyah": "tanggal_lahir_ayah",
+		"namaIbu":      "nama_ibu",
+		"usiaIbu":      "umur_ibu",
+		"tglLahirIbu":  "tanggal_lahir_ibu",
+		"puskesmas":    "puskesmas",
+		"bidan":        "bidan",
+		"alamat":       "alamat",
+		"desa":         "desa",
+		"kecamatan":    "kecamatan",
+		"kabupaten":    "kabupaten",
+		"provinsi":     "provinsi",
+		"noHP":         "no_hp",
+	},
+}
+
+// NamaField is the generalInformation key holding the patient's name,
+// which may never be patched to empty.
+var NamaField = map[int]string{
+	LayananKB:        "namaPeserta",
+	LayananKehamilan: "namaLengkap",
+	LayananImunisasi: "namaBayi",
+}
+
+// Sections maps the remaining form sections to the sub-document they are
+// stored in as-is.
+var Sections = map[int]map[string]string{
+	LayananKB: {
+		"otherInformation": "data_kb.informasi_lainnya",
+		"skrining":         "data_kb.skrining",
+		"hasil":            "data_kb.hasil",
+		"penapisanKB":      "data_kb.penapisan_kb",
+	},
+	LayananKehamilan: {
+		"kunjunganNifas":                        "data_kehamilan.kunjungan_nifas",
+		"mendeteksiFaktorResikoDanResikoTinggi": "data_kehamilan.faktor_risiko_dan_risiko_tinggi",
+		"pemeriksaanPNC":                        "data_kehamilan.pemeriksaan_pnc",
+		"persalinan":                            "data_kehamilan.persalinan",
+		"rencanaPersalinan":                     "data_kehamilan.rencana_persalinan",
+		"riwayatKehamilan":                      "data_kehamilan.riwayat_kehamilan",
+		"skriningTT":                            "data_kehamilan.skrining_tt",
+		"section2":                              "data_kehamilan.section2",
+	},
+	LayananImunisasi: {
+		"detailBayi":                  "data_imunisasi.detail_bayi",
+		"pemeriksaanNeonatus":         "data_imunisasi.pemeriksaan_neonatus",
+		"pemeriksaanNeonatusLanjutan": "data_imunisasi.pemeriksaan_neonatus_lanjutan",
+		"pemeriksaanBalita":           "data_imunisasi.pemeriksaan_balita",
+	},
+}
@@ -0,0 +1,181 @@
+package pasien
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// kodeProvinsi lists the two-digit province codes (Kemendagri) that a NIK
+// can start with.
+var kodeProvinsi = map[string]string{
+	"11": "Aceh", "12": "Sumatera Utara", "13": "Sumatera Barat", "14": "Riau",
+	"15": "Jambi", "16": "Sumatera Selatan", "17": "Bengkulu", "18": "Lampung",
+	"19": "Kepulauan Bangka Belitung", "21": "Kepulauan Riau",
+	"31": "DKI Jakarta", "32": "Jawa Barat", "33": "Jawa Tengah", "34": "DI Yogyakarta",
+	"35": "Jawa Timur", "36": "Banten", "51": "Bali", "52": "Nusa Tenggara Barat",
+	"53": "Nusa Tenggara Timur", "61": "Kalimantan Barat", "62": "Kalimantan Tengah",
+	"63": "Kalimantan Selatan", "64": "Kalimantan Timur", "65": "Kalimantan Utara",
+	"71": "Sulawesi Utara", "72": "Sulawesi Tengah", "73": "Sulawesi Selatan",
+	"74": "Sulawesi Tenggara", "75": "Gorontalo", "76": "Sulawesi Barat",
+	"81": "Maluku", "82": "Maluku Utara", "91": "Papua Barat", "92": "Papua Barat Daya",
+	"93": "Papua Selatan", "94": "Papua", "95": "Papua Tengah", "96": "Papua Pegunungan",
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateNIK checks the 16-digit Nomor Induk Kependudukan. Digits 1-2 must
+// be a known province, digits 7-12 the birth date as DDMMYY (DD + 40 for
+// women). When tanggalLahir is given and parseable the NIK must agree with it.
+func ValidateNIK(nik, tanggalLahir string) error {
+	if len(nik) != 16 || !isDigits(nik) {
+		return fmt.Errorf("NIK harus 16 digit angka")
+	}
+	if _, ok := kodeProvinsi[nik[:2]]; !ok {
+		return fmt.Errorf("kode provinsi NIK %s tidak dikenal", nik[:2])
+	}
+	if nik[12:] == "0000" {
+		return fmt.Errorf("nomor urut NIK tidak boleh 0000")
+	}
+
+	day, dayErr := strconv.Atoi(nik[6:8])
+	month, monthErr := strconv.Atoi(nik[8:10])
+	year, yearErr := strconv.Atoi(nik[10:12])
+	if day > 40 {
+		day -= 40
+	}
+	if dayErr != nil || monthErr != nil || yearErr != nil || !tanggalAda(day, month, year) {
+		return fmt.Errorf("tanggal lahir pada NIK tidak valid")
+	}
+
+	if tanggalLahir == "" {
+		return nil
+	}
+	lahir, err := ParseTanggal(tanggalLahir)
+	if err != nil {
+		return nil
+	}
+	if lahir.Day() != day || int(lahir.Month()) != month || lahir.Year()%100 != year {
+		return fmt.Errorf("tanggal lahir pada NIK (%02d-%02d-%02d) tidak sesuai dengan tanggal lahir pasien", day, month, year)
+	}
+	return nil
+}
+
+// tanggalAda reports whether day-month exists in 19yy or 20yy, the century
+// a two-digit NIK year is in. 29-02-00 exists (2000), 31-04-xx never does.
+func tanggalAda(day, month, yy int) bool {
+	if month < 1 || month > 12 || day < 1 {
+		return false
+	}
+	for _, year := range []int{1900 + yy, 2000 + yy} {
+		t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if t.Day() == day && int(t.Month()) == month {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateNoJKN checks a BPJS Kesehatan / JKN-KIS card number (13 digits).
+func ValidateNoJKN(noJkn string) error {
+	if len(noJkn) != 13 || !isDigits(noJkn) {
+		return fmt.Errorf("nomor kartu JKN harus 13 digit angka")
+	}
+	return nil
+}
+
+func formString(general map[string]interface{}, key string) string {
+	value, _ := general[key].(string)
+	return strings.Join(strings.Fields(value), "")
+}
+
+// IdentityFields validates the nik and noJkn keys of a generalInformation
+// section and returns them as pasien fields. The NIK is checked against the
+// birth date sent in the same section (tglLahir for KB, tanggalLahir for
+// Kehamilan). Empty values are left out so an edit without them does not
+// clear what is already stored.
+func IdentityFields(general map[string]interface{}) (bson.M, error) {
+	fields := bson.M{}
+	lahir := formString(general, "tglLahir")
+	if lahir == "" {
+		lahir = formString(general, "tanggalLahir")
+	}
+
+	if nik := formString(general, "nik"); nik != "" {
+		if err := ValidateNIK(nik, lahir); err != nil {
+			return nil, err
+		}
+		fields["nik"] = nik
+	}
+	if noJkn := formString(general, "noJkn"); noJkn != "" {
+		if err := ValidateNoJKN(noJkn); err != nil {
+			return nil, err
+		}
+		fields["no_jkn"] = noJkn
+	}
+	return fields, nil
+}
+
+// CheckNIKUnique returns an error when another pasien already has nik.
+// idPasien is the patient being edited, or nil when creating one. The
+// nik_unique index built by EnsureNIKIndex (the indeks migration) is the
+// real guarantee; this check only gives a readable message before the
+// insert fails.
+func CheckNIKUnique(ctx context.Context, collection *mongo.Collection, nik interface{}, idPasien interface{}) error {
+	if nik == nil {
+		return nil
+	}
+	filter := bson.M{"nik": nik}
+	if s, ok := nik.(string); ok {
+		candidates, err := Blind("nik", s)
+		if err != nil {
+			return err
+		}
+		filter["nik"] = bson.M{"$in": candidates}
+	}
+	if idPasien != nil {
+		filter["id_pasien"] = bson.M{"$ne": idPasien}
+	}
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrNIKExists
+	}
+	return nil
+}
+
+// ErrNIKExists is returned when a NIK is already registered to another pasien.
+var ErrNIKExists = errors.New("NIK sudah terdaftar pada pasien lain")
+
+// EnsureNIKIndex creates nik_unique, the unique index on NIKBlindField. It
+// fails while two patients share a NIK, so only the indeks migration calls
+// it, after it has checked for those.
+func EnsureNIKIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("pasien").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: NIKBlindField, Value: 1}},
+		Options: options.Index().
+			SetName("nik_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{NIKBlindField: bson.M{"$type": "string"}}),
+	})
+	return err
+}
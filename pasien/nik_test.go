@@ -0,0 +1,21 @@
+package pasien
+
+import "testing"
+
+func TestValidateNIKTanggal(t *testing.T) {
+	cases := map[string]bool{
+		"3201014506900001": true,  // 05-06-90, woman
+		"3201012902000001": true,  // 29-02-2000
+		"3201012902010001": false, // 29-02-01 exists in neither century
+		"3201013104900001": false, // 31 April
+		"3201017202900001": false, // 32-02, woman
+		"3201010013900001": false, // month 13
+		"3201010006900001": false, // day 00
+	}
+	for nik, valid := range cases {
+		err := ValidateNIK(nik, "")
+		if (err == nil) != valid {
+			t.Errorf("ValidateNIK(%s) = %v, want valid %v", nik, err, valid)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package pasien
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case bson.M:
+		return v, true
+	case bson.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to target and returns the
+// result. target is not modified. A null in the patch removes the key, an
+// object is merged recursively, anything else replaces the target value.
+func MergePatch(target, patch interface{}) interface{} {
+	patchMap, ok := asMap(patch)
+	if !ok {
+		return patch
+	}
+
+	result := map[string]interface{}{}
+	if targetMap, ok := asMap(target); ok {
+		for key, value := range targetMap {
+			result[key] = value
+		}
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = MergePatch(result[key], value)
+	}
+	return result
+}
+
+// MergePatchUpdate translates a merge patch applied to the value stored at
+// path into dotted $set and $unset entries, so that only the fields named
+// in the patch are written and concurrent edits of sibling fields survive.
+// current is the value currently stored at path.
+func MergePatchUpdate(path string, current, patch interface{}, set, unset bson.M) error {
+	patchMap, ok := asMap(patch)
+	if !ok {
+		if patch == nil {
+			unset[path] = ""
+		} else {
+			set[path] = patch
+		}
+		return nil
+	}
+
+	currentMap, ok := asMap(current)
+	if !ok {
+		// Patching into a scalar or a missing value replaces it entirely.
+		if err := checkKeys(patchMap); err != nil {
+			return err
+		}
+		set[path] = MergePatch(nil, patch)
+		return nil
+	}
+
+	for key, value := range patchMap {
+		if err := checkKey(key); err != nil {
+			return err
+		}
+		child := path + "." + key
+		if value == nil {
+			if _, exists := currentMap[key]; exists {
+				unset[child] = ""
+			}
+			continue
+		}
+		if err := MergePatchUpdate(child, currentMap[key], value, set, unset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateSection checks a form section as it is to be stored at path after
+// a merge patch: it must be an object with keys MongoDB can store, phone
+// numbers in it must be valid, and in a Kehamilan section the HPHT and the
+// due date must be dates, with the HPHT not in the future, since the
+// gestational age, the eliminasi status and the alerts are counted from
+// them.
+func ValidateSection(path string, section interface{}) error {
+	fields, ok := asMap(section)
+	if !ok {
+		return fmt.Errorf("%s harus berupa object", path)
+	}
+	if err := checkKeys(fields); err != nil {
+		return err
+	}
+
+	for _, phone := range PhoneFields {
+		if key, ok := strings.CutPrefix(phone, path+"."); ok {
+			if _, err := NormalizePhoneValue(fields[key]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, kehamilan := range kehamilanSections {
+		if path != kehamilan {
+			continue
+		}
+		for _, key := range append(append([]string{}, hphtKeys...), hplKeys...) {
+			value, ok := fields[key]
+			if s, isString := value.(string); !ok || value == nil || isString && strings.TrimSpace(s) == "" {
+				continue
+			}
+			t, ok := AsTime(value)
+			if !ok {
+				return fmt.Errorf("%s.%s bukan tanggal yang valid", path, key)
+			}
+			for _, hpht := range hphtKeys {
+				if key == hpht && t.After(time.Now()) {
+					return fmt.Errorf("%s.%s tidak boleh setelah hari ini", path, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkKey(key string) error {
+	if key == "" || strings.ContainsAny(key, ".$") {
+		return fmt.Errorf("nama field %q tidak valid", key)
+	}
+	return nil
+}
+
+func checkKeys(value interface{}) error {
+	valueMap, ok := asMap(value)
+	if !ok {
+		return nil
+	}
+	for key, child := range valueMap {
+		if err := checkKey(key); err != nil {
+			return err
+		}
+		if err := checkKeys(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
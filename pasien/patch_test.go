@@ -0,0 +1,121 @@
+package pasien
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return v
+}
+
+// The examples of RFC 7396, appendix A.
+func TestMergePatch(t *testing.T) {
+	for _, c := range []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		target := decodeJSON(t, c.target)
+		before := decodeJSON(t, c.target)
+		got := MergePatch(target, decodeJSON(t, c.patch))
+		if want := decodeJSON(t, c.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("MergePatch(%s, %s) = %v, want %s", c.target, c.patch, got, c.want)
+		}
+		if !reflect.DeepEqual(target, before) {
+			t.Errorf("MergePatch(%s, %s) changed the target to %v", c.target, c.patch, target)
+		}
+	}
+}
+
+func TestMergePatchUpdate(t *testing.T) {
+	current := bson.M{"hpht": "2026-01-05", "gravida": 2, "catatan": bson.M{"a": 1, "b": 2}}
+	set, unset := bson.M{}, bson.M{}
+	patch := decodeJSON(t, `{"gravida":3,"hpht":null,"tidakAda":null,"catatan":{"b":null,"c":{"d":1}},"baru":{"x":1}}`)
+	if err := MergePatchUpdate("data_kehamilan.riwayat_kehamilan", current, patch, set, unset); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSet := bson.M{
+		"data_kehamilan.riwayat_kehamilan.gravida":   float64(3),
+		"data_kehamilan.riwayat_kehamilan.catatan.c": map[string]interface{}{"d": float64(1)},
+		"data_kehamilan.riwayat_kehamilan.baru":      map[string]interface{}{"x": float64(1)},
+	}
+	wantUnset := bson.M{
+		"data_kehamilan.riwayat_kehamilan.hpht":      "",
+		"data_kehamilan.riwayat_kehamilan.catatan.b": "",
+	}
+	if !reflect.DeepEqual(set, wantSet) {
+		t.Errorf("$set = %v, want %v", set, wantSet)
+	}
+	if !reflect.DeepEqual(unset, wantUnset) {
+		t.Errorf("$unset = %v, want %v", unset, wantUnset)
+	}
+}
+
+func TestMergePatchUpdateIntoScalar(t *testing.T) {
+	set, unset := bson.M{}, bson.M{}
+	if err := MergePatchUpdate("data_kb.hasil", "lama", decodeJSON(t, `{"a":1,"b":null}`), set, unset); err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{"data_kb.hasil": map[string]interface{}{"a": float64(1)}}
+	if !reflect.DeepEqual(set, want) || len(unset) != 0 {
+		t.Errorf("$set = %v, $unset = %v, want %v and nothing", set, unset, want)
+	}
+}
+
+func TestMergePatchUpdateRejectsKeys(t *testing.T) {
+	for _, patch := range []string{`{"a.b":1}`, `{"$set":1}`, `{"":1}`, `{"a":{"$x":1}}`} {
+		if err := MergePatchUpdate("data_kb.hasil", bson.M{"a": bson.M{}}, decodeJSON(t, patch), bson.M{}, bson.M{}); err == nil {
+			t.Errorf("MergePatchUpdate accepted %s", patch)
+		}
+		if err := MergePatchUpdate("data_kb.hasil", nil, decodeJSON(t, patch), bson.M{}, bson.M{}); err == nil {
+			t.Errorf("MergePatchUpdate into a missing value accepted %s", patch)
+		}
+	}
+}
+
+func TestValidateSection(t *testing.T) {
+	besok := time.Now().In(Jakarta).AddDate(0, 0, 2).Format("2006-01-02")
+	for _, c := range []struct {
+		path    string
+		section interface{}
+		valid   bool
+	}{
+		{"data_kehamilan.riwayat_kehamilan", bson.M{"hpht": "2026-01-05", "hpl": ""}, true},
+		{"data_kehamilan.riwayat_kehamilan", bson.M{"hpht": "kemarin"}, false},
+		{"data_kehamilan.riwayat_kehamilan", bson.M{"hpht": besok}, false},
+		{"data_kehamilan.riwayat_kehamilan", bson.M{"hpl": besok}, true},
+		{"data_kehamilan.section2", bson.M{"noTelp": "0812-3456-7890"}, true},
+		{"data_kehamilan.section2", bson.M{"noTelp": "12ab"}, false},
+		{"data_kb.skrining", bson.M{"hpht": "bukan tanggal"}, true},
+		{"data_kb.skrining", bson.M{"a": bson.M{"b.c": 1}}, false},
+		{"data_kb.skrining", "teks", false},
+	} {
+		err := ValidateSection(c.path, c.section)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidateSection(%s, %v) = %v, want valid %v", c.path, c.section, err, c.valid)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package pasien
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RegisterField holds the human-readable register number shown to
+// patients, next to the internal id_pasien.
+const RegisterField = "no_register"
+
+// RegisterFormat is the default register-number format per layanan. It can
+// be overridden with REGISTER_FORMAT_KB, REGISTER_FORMAT_KEHAMILAN and
+// REGISTER_FORMAT_IMUNISASI. {YYYY} and {YY} are replaced by the year and
+// {SEQ:n} by the sequence within that year, zero-padded to n digits.
+var RegisterFormat = map[int]string{
+	LayananKB:        "KB-{YYYY}-{SEQ:4}",
+	LayananKehamilan: "ANC-{YYYY}-{SEQ:4}",
+	LayananImunisasi: "IMN-{YYYY}-{SEQ:4}",
+}
+
+var registerFormatEnv = map[int]string{
+	LayananKB:        "REGISTER_FORMAT_KB",
+	LayananKehamilan: "REGISTER_FORMAT_KEHAMILAN",
+	LayananImunisasi: "REGISTER_FORMAT_IMUNISASI",
+}
+
+var seqPlaceholder = regexp.MustCompile(`\{SEQ(?::(\d+))?\}`)
+
+// FormatRegister renders the register number for the given layanan, year
+// and sequence.
+func FormatRegister(layanan, year int, seq int64) (string, error) {
+	format := os.Getenv(registerFormatEnv[layanan])
+	if format == "" {
+		format = RegisterFormat[layanan]
+	}
+	if format == "" {
+		return "", fmt.Errorf("no register format for id_layanan %d", layanan)
+	}
+	if !seqPlaceholder.MatchString(format) {
+		return "", fmt.Errorf("register format %q has no {SEQ} placeholder", format)
+	}
+
+	result := strings.NewReplacer(
+		"{YYYY}", strconv.Itoa(year),
+		"{YY}", fmt.Sprintf("%02d", year%100),
+	).Replace(format)
+	return seqPlaceholder.ReplaceAllStringFunc(result, func(match string) string {
+		width := 0
+		if sub := seqPlaceholder.FindStringSubmatch(match); sub[1] != "" {
+			width, _ = strconv.Atoi(sub[1])
+		}
+		return fmt.Sprintf("%0*d", width, seq)
+	}), nil
+}
+
+// RegisterYear is the year a patient is numbered in: the year of its
+// tanggal_register in Jakarta, so a back-dated registration counts with
+// the others of its year. ok is false when tanggal_register is missing or
+// not a date, and the current year is returned.
+func RegisterYear(doc bson.M) (year int, ok bool) {
+	if t, ok := AsTime(doc["tanggal_register"]); ok {
+		return t.In(Jakarta).Year(), true
+	}
+	return time.Now().In(Jakarta).Year(), false
+}
+
+// NextRegister increments the register counter of the layanan for year and
+// returns the formatted number. Each layanan and year has its own counter
+// in register_counter, so numbering restarts at 1 every year. Like NextID
+// it belongs inside the transaction that inserts the patient.
+func NextRegister(ctx context.Context, db *mongo.Database, layanan, year int) (string, error) {
+	filter := bson.M{"_id": fmt.Sprintf("%d-%d", layanan, year)}
+	update := bson.M{
+		"$inc":         bson.M{"seq_value": int64(1)},
+		"$setOnInsert": bson.M{"id_layanan": layanan, "tahun": year},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var counter bson.M
+	if err := db.Collection("register_counter").FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter); err != nil {
+		return "", err
+	}
+
+	seq, ok := counter["seq_value"].(int64)
+	if !ok {
+		return "", fmt.Errorf("seq_value is not int64: %v (%T)", counter["seq_value"], counter["seq_value"])
+	}
+	return FormatRegister(layanan, year, seq)
+}
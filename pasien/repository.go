@@ -0,0 +1,41 @@
+package pasien
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindOne decodes the pasien matching filter with its encrypted fields
+// decrypted. It returns mongo.ErrNoDocuments when there is none.
+func FindOne(ctx context.Context, collection *mongo.Collection, filter interface{}, opts ...*options.FindOneOptions) (bson.M, error) {
+	var doc bson.M
+	if err := collection.FindOne(ctx, filter, opts...).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if err := Decrypt(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// FindAll decodes every pasien matching filter with its encrypted fields
+// decrypted.
+func FindAll(ctx context.Context, collection *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]bson.M, error) {
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if err := Decrypt(doc); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
@@ -0,0 +1,217 @@
+package pasien
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchableFields maps the key stored under "search" to the path of the
+// original value in a pasien document. Paths are tried in order, so the
+// Kehamilan layout (desa inside data_kehamilan) and the Imunisasi layout
+// (desa at the root) both end up in search.desa.
+var SearchableFields = map[string][]string{
+	"nama":          {"nama_pasien"},
+	"no_hp":         {"no_hp"},
+	"nik":           {"nik"},
+	"no_jkn":        {"no_jkn"},
+	"no_seri_kartu": {"data_kb.no_seri_kartu"},
+	"nomor_bayi":    {"nomor_bayi"},
+	"no_register":   {"no_register"},
+	"desa":          {"desa", "data_kehamilan.desa"},
+}
+
+// NormalizeText lowercases s and collapses runs of whitespace so that
+// "  Siti   AMINAH" and "siti aminah" share the same prefix.
+func NormalizeText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// NormalizeCode lowercases s and strips everything but letters and digits,
+// used for card, baby and register numbers that are typed with or without dashes.
+func NormalizeCode(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// NormalizeSearchValue applies the normaliser used for the given search key.
+func NormalizeSearchValue(key, value string) string {
+	switch key {
+	case "no_hp":
+		return PhonePrefix(value)
+	case "nik", "no_jkn":
+		return strings.Join(strings.Fields(value), "")
+	case "no_seri_kartu", "nomor_bayi", "no_register":
+		return NormalizeCode(value)
+	default:
+		return NormalizeText(value)
+	}
+}
+
+// Lookup returns the value at a dotted path such as "data_kb.no_seri_kartu".
+func Lookup(doc bson.M, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		switch m := current.(type) {
+		case bson.M:
+			current = m[part]
+		case map[string]interface{}:
+			current = m[part]
+		case bson.D:
+			current = m.Map()[part]
+		default:
+			return nil, false
+		}
+		if current == nil {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// SearchFields builds the normalised "search" sub-document for a pasien
+// document. It is written next to the original fields by every create and
+// edit handler and is what the prefix indexes are built on.
+func SearchFields(doc bson.M) bson.M {
+	search := bson.M{}
+	for key, paths := range SearchableFields {
+		for _, path := range paths {
+			value, ok := Lookup(doc, path)
+			if !ok {
+				continue
+			}
+			str, ok := value.(string)
+			if !ok || strings.TrimSpace(str) == "" {
+				continue
+			}
+			search[key] = NormalizeSearchValue(key, str)
+			break
+		}
+	}
+	return search
+}
+
+// PrefixFilter matches the patients whose search.<key> starts with value,
+// or nil when value normalises to nothing. A key stored as blind prefixes
+// is matched on the blind index of value; a key encrypted as a whole only
+// on all of it. Plain search values are matched too, for patients not
+// encrypted yet.
+func PrefixFilter(key, value string) (bson.M, error) {
+	normalized := NormalizeSearchValue(key, value)
+	if normalized == "" {
+		return nil, nil
+	}
+	field := "search." + key
+	plain := bson.M{field: bson.M{"$regex": "^" + regexp.QuoteMeta(normalized)}}
+
+	prefix, ok, err := BlindPrefix(key, normalized)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return bson.M{"$or": []bson.M{{field: prefix}, plain}}, nil
+	}
+	if IsEncrypted(field) {
+		candidates, err := Blind(field, normalized)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{field: bson.M{"$in": candidates}}, nil
+	}
+	return plain, nil
+}
+
+// textFields are the fields of the text index with their weights. An
+// encrypted one is left out, its ciphertext has no words to find.
+var textFields = []struct {
+	Path   string
+	Weight int32
+}{
+	{"nama_pasien", 10},
+	{"nama_ibu", 3},
+	{"nama_ayah", 3},
+	{"nama_pasangan", 3},
+	{"desa", 2},
+	{"data_kehamilan.desa", 2},
+	{"alamat", 1},
+}
+
+// EnsureSearchIndexes creates the text index and the normalised prefix
+// indexes used by searchpasien. The search, noregister and nama migrations
+// call it; creating an index that already exists with the same options is
+// a no-op. The text index is rebuilt when the encrypted fields changed what
+// it should cover, since a collection has only one.
+func EnsureSearchIndexes(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("pasien")
+	var keys, weights bson.D
+	for _, field := range textFields {
+		if IsEncrypted(field.Path) {
+			continue
+		}
+		keys = append(keys, bson.E{Key: field.Path, Value: "text"})
+		weights = append(weights, bson.E{Key: field.Path, Value: field.Weight})
+	}
+	if err := dropChangedTextIndex(ctx, collection, weights); err != nil {
+		return err
+	}
+
+	var models []mongo.IndexModel
+	if len(keys) > 0 {
+		models = append(models, mongo.IndexModel{
+			Keys: keys,
+			Options: options.Index().
+				SetName("pasien_text").
+				SetDefaultLanguage("none").
+				SetWeights(weights),
+		})
+	}
+	for key := range SearchableFields {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: "search." + key, Value: 1}},
+			Options: options.Index().SetName("search_" + key),
+		})
+	}
+
+	_, err := collection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+// dropChangedTextIndex drops pasien_text when it does not cover exactly
+// the fields in weights.
+func dropChangedTextIndex(ctx context.Context, collection *mongo.Collection, weights bson.D) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var indexes []struct {
+		Name    string           `bson:"name"`
+		Weights map[string]int32 `bson:"weights"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if index.Name != "pasien_text" {
+			continue
+		}
+		same := len(index.Weights) == len(weights)
+		for _, weight := range weights {
+			same = same && index.Weights[weight.Key] == weight.Value
+		}
+		if same {
+			return nil
+		}
+		_, err := collection.Indexes().DropOne(ctx, "pasien_text")
+		return err
+	}
+	return nil
+}
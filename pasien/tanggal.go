@@ -0,0 +1,205 @@
+package pasien
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Jakarta is the timezone dates are stored and shown in. Date-only values
+// are stored as midnight in Jakarta.
+var Jakarta = loadJakarta()
+
+func loadJakarta() *time.Location {
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return time.FixedZone("WIB", 7*60*60)
+	}
+	return loc
+}
+
+// tanggalLayouts are the formats the frontend has sent dates in.
+var tanggalLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "02-01-2006", "02/01/2006"}
+
+// DateFields are the pasien fields holding a calendar date, stored as
+// midnight Jakarta. DateTimeFields hold an instant and keep their time.
+var (
+	DateFields     = []string{"tanggal_lahir", "tanggal_lahir_ayah", "tanggal_lahir_ibu"}
+	DateTimeFields = []string{"tanggal_register"}
+)
+
+// ParseTanggal parses a date in any of the formats the frontend sends.
+// Values without an offset are read as Jakarta time; the result is always
+// in Jakarta.
+func ParseTanggal(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range tanggalLayouts {
+		if t, err := time.ParseInLocation(layout, value, Jakarta); err == nil {
+			return t.In(Jakarta), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("format tanggal tidak dikenal: %q", value)
+}
+
+// AsTime reads a stored or submitted date: a BSON datetime, a time.Time or
+// a string ParseTanggal understands.
+func AsTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v.Time().In(Jakarta), true
+	case time.Time:
+		return v.In(Jakarta), true
+	case string:
+		t, err := ParseTanggal(v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// ToDateTime converts a submitted value to a BSON datetime. Empty values
+// stay nil so an optional field is not turned into an error.
+func ToDateTime(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if s, ok := value.(string); ok && strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	t, ok := AsTime(value)
+	if !ok {
+		return nil, fmt.Errorf("format tanggal tidak dikenal: %v", value)
+	}
+	return primitive.NewDateTimeFromTime(t), nil
+}
+
+// ToDate is ToDateTime truncated to midnight Jakarta on the same day.
+func ToDate(value interface{}) (interface{}, error) {
+	converted, err := ToDateTime(value)
+	if converted == nil || err != nil {
+		return converted, err
+	}
+	t := converted.(primitive.DateTime).Time().In(Jakarta)
+	return primitive.NewDateTimeFromTime(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, Jakarta)), nil
+}
+
+// NormalizeDate converts the value submitted for a stored field to the type
+// it is stored as. Fields that are not dates are returned unchanged.
+func NormalizeDate(field string, value interface{}) (interface{}, error) {
+	for _, date := range DateFields {
+		if field == date {
+			converted, err := ToDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", field, err)
+			}
+			return converted, nil
+		}
+	}
+	for _, date := range DateTimeFields {
+		if field == date {
+			converted, err := ToDateTime(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", field, err)
+			}
+			return converted, nil
+		}
+	}
+	return value, nil
+}
+
+// NormalizeDates converts the date fields of a pasien document built from
+// a form in place.
+func NormalizeDates(doc bson.M) error {
+	for _, fields := range [][]string{DateFields, DateTimeFields} {
+		for _, field := range fields {
+			value, ok := doc[field]
+			if !ok {
+				continue
+			}
+			converted, err := NormalizeDate(field, value)
+			if err != nil {
+				return err
+			}
+			doc[field] = converted
+		}
+	}
+	return nil
+}
+
+// FormatDate formats a stored date for the API as 2006-01-02. Values that
+// are not dates (legacy strings that could not be migrated) are returned
+// as they are.
+func FormatDate(value interface{}) interface{} {
+	if _, ok := value.(string); ok {
+		return value
+	}
+	if t, ok := AsTime(value); ok {
+		return t.Format("2006-01-02")
+	}
+	return value
+}
+
+// FormatDateTime formats a stored instant for the API as RFC3339 in
+// Jakarta time.
+func FormatDateTime(value interface{}) interface{} {
+	if _, ok := value.(string); ok {
+		return value
+	}
+	if t, ok := AsTime(value); ok {
+		return t.Format(time.RFC3339)
+	}
+	return value
+}
+
+// FormatDates formats the date fields of a pasien document for the API in
+// place.
+func FormatDates(doc bson.M) {
+	for _, field := range DateFields {
+		if value, ok := doc[field]; ok {
+			doc[field] = FormatDate(value)
+		}
+	}
+	for _, field := range DateTimeFields {
+		if value, ok := doc[field]; ok {
+			doc[field] = FormatDateTime(value)
+		}
+	}
+}
+
+// FormatTanggal formats a date as dd-mm-yyyy, the way the tables show it.
+// Unparseable values come back as an empty string instead of panicking.
+func FormatTanggal(value interface{}) string {
+	t, ok := AsTime(value)
+	if !ok {
+		return ""
+	}
+	return t.Format("02-01-2006")
+}
+
+var (
+	namaHari  = []string{"Minggu", "Senin", "Selasa", "Rabu", "Kamis", "Jumat", "Sabtu"}
+	namaBulan = []string{"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"}
+)
+
+// TanggalIndonesia formats t like "Senin, 2 Januari 2006".
+func TanggalIndonesia(t time.Time) string {
+	t = t.In(Jakarta)
+	return fmt.Sprintf("%s, %d %s %d", namaHari[t.Weekday()], t.Day(), namaBulan[t.Month()-1], t.Year())
+}
+
+// DateTimeFormat is the $dateToString format that matches FormatDateTime.
+// Jakarta has no daylight saving, so the offset is fixed.
+const DateTimeFormat = "%Y-%m-%dT%H:%M:%S+07:00"
+
+// DateToString is the aggregation expression formatting a stored date field
+// in Jakarta time, e.g. DateToString("$tglDatang", "%Y-%m"). Values Mongo
+// cannot convert to a date become null instead of failing the pipeline.
+func DateToString(field, format string) bson.M {
+	return bson.M{"$dateToString": bson.M{
+		"date":     bson.M{"$convert": bson.M{"input": field, "to": "date", "onError": nil, "onNull": nil}},
+		"format":   format,
+		"timezone": "Asia/Jakarta",
+	}}
+}
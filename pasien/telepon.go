@@ -0,0 +1,127 @@
+package pasien
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PhoneFields are the paths of a pasien document holding a phone number.
+// Kehamilan keeps the number in section2 as well as at the root.
+var PhoneFields = []string{"no_hp", "data_kehamilan.section2.noTelp"}
+
+// toE164 rewrites the digits of s in international form without checking
+// the length, so it also works on the first digits of a number typed into
+// a search box. Local numbers (08..., 8...) get the +62 country code; a
+// 0 typed after the country code (+62 0812...) is dropped.
+func toE164(s string) string {
+	s = strings.TrimSpace(s)
+	international := strings.HasPrefix(s, "+")
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+	if !international && strings.HasPrefix(digits, "00") {
+		digits, international = digits[2:], true
+	}
+
+	switch {
+	case digits == "":
+		return ""
+	case strings.HasPrefix(digits, "62"):
+		return "+62" + strings.TrimPrefix(digits[2:], "0")
+	case international:
+		return "+" + digits
+	case strings.HasPrefix(digits, "0"):
+		return "+62" + digits[1:]
+	default:
+		return "+62" + digits
+	}
+}
+
+// NormalizePhone validates a phone number written as 0812..., +62812...,
+// 62812... or 812..., with or without spaces, dashes, dots or brackets, and
+// returns it in E.164 form (+62812...). Indonesian numbers need 8 to 12
+// digits after the country code; numbers with another country code are
+// accepted when they have 8 to 15 digits in total.
+func NormalizePhone(s string) (string, error) {
+	for i, r := range strings.TrimSpace(s) {
+		if unicode.IsDigit(r) || strings.ContainsRune(" -.()", r) || (r == '+' && i == 0) {
+			continue
+		}
+		return "", fmt.Errorf("nomor HP %q mengandung karakter yang tidak valid", s)
+	}
+
+	e164 := toE164(s)
+	if nasional, ok := strings.CutPrefix(e164, "+62"); ok {
+		if len(nasional) < 8 || len(nasional) > 12 {
+			return "", fmt.Errorf("nomor HP %q harus 8 sampai 12 digit setelah kode negara +62", s)
+		}
+		return e164, nil
+	}
+	if len(e164) < 9 || len(e164) > 16 {
+		return "", fmt.Errorf("nomor HP %q tidak valid", s)
+	}
+	return e164, nil
+}
+
+// PhonePrefix normalises a whole or partial phone number for search.
+// Whatever format it is typed in, it is a prefix of the E.164 form of the
+// numbers it should find.
+func PhonePrefix(s string) string {
+	return toE164(s)
+}
+
+// NormalizePhoneValue converts a submitted phone number to E.164. Empty
+// values stay as they are so an optional number is not turned into an
+// error.
+func NormalizePhoneValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("nomor HP harus berupa teks")
+	}
+	if strings.TrimSpace(s) == "" {
+		return value, nil
+	}
+	return NormalizePhone(s)
+}
+
+// NormalizePhones converts the PhoneFields of a pasien document built from
+// a form to E.164 in place.
+func NormalizePhones(doc bson.M) error {
+	for _, path := range PhoneFields {
+		parts := strings.Split(path, ".")
+		var parent interface{} = doc
+		if len(parts) > 1 {
+			parent, _ = Lookup(doc, strings.Join(parts[:len(parts)-1], "."))
+		}
+		key := parts[len(parts)-1]
+
+		var m map[string]interface{}
+		switch p := parent.(type) {
+		case bson.M:
+			m = p
+		case map[string]interface{}:
+			m = p
+		default:
+			continue
+		}
+		value, ok := m[key]
+		if !ok {
+			continue
+		}
+		converted, err := NormalizePhoneValue(value)
+		if err != nil {
+			return err
+		}
+		m[key] = converted
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package pasien
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	for _, c := range []struct {
+		in, want string
+	}{
+		{"081234567890", "+6281234567890"},
+		{"0812-3456-7890", "+6281234567890"},
+		{"(0812) 3456 7890", "+6281234567890"},
+		{"+6281234567890", "+6281234567890"},
+		{"+62 0812 3456 7890", "+6281234567890"},
+		{"6281234567890", "+6281234567890"},
+		{"81234567890", "+6281234567890"},
+		{"0062 812 3456 7890", "+6281234567890"},
+		{"+6012345678", "+6012345678"},
+	} {
+		got, err := NormalizePhone(c.in)
+		if err != nil || got != c.want {
+			t.Errorf("NormalizePhone(%q) = %q, %v, want %q", c.in, got, err, c.want)
+		}
+	}
+}
+
+func TestNormalizePhoneInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"0812",
+		"0812345678901234",
+		"0812-abcd-7890",
+		"081234567890+",
+		"+601234",
+	} {
+		if got, err := NormalizePhone(in); err == nil {
+			t.Errorf("NormalizePhone(%q) = %q, want an error", in, got)
+		}
+	}
+}
+
+// toE164 also rewrites the first digits of a number, as typed in search.
+func TestToE164(t *testing.T) {
+	for _, c := range []struct {
+		in, want string
+	}{
+		{"08", "+628"},
+		{"0812", "+62812"},
+		{"62", "+62"},
+		{"+62 0", "+62"},
+		{"812", "+62812"},
+		{"+1 415", "+1415"},
+		{"", ""},
+		{"abc", ""},
+	} {
+		if got := toE164(c.in); got != c.want {
+			t.Errorf("toE164(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestNormalizePhones(t *testing.T) {
+	doc := bson.M{
+		"no_hp":          "0812 3456 7890",
+		"data_kehamilan": bson.M{"section2": map[string]interface{}{"noTelp": "62813-1111-2222"}},
+	}
+	if err := NormalizePhones(doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["no_hp"] != "+6281234567890" {
+		t.Errorf("no_hp = %v", doc["no_hp"])
+	}
+	if noTelp, _ := Lookup(doc, "data_kehamilan.section2.noTelp"); noTelp != "+6281311112222" {
+		t.Errorf("section2.noTelp = %v", noTelp)
+	}
+	if err := NormalizePhones(bson.M{"no_hp": "bukan nomor"}); err == nil {
+		t.Error("NormalizePhones accepted an invalid number")
+	}
+}
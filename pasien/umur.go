@@ -0,0 +1,103 @@
+package pasien
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// tanggalLahirBayiPaths are tried in order for an Imunisasi patient. Older
+// records only carry the birth date inside the detailBayi section.
+var tanggalLahirBayiPaths = []string{
+	"tanggal_lahir",
+	"data_imunisasi.detail_bayi.tanggalLahir",
+	"data_imunisasi.detail_bayi.tglLahir",
+}
+
+// TanggalLahir returns the parsed birth date of the patient, or false when
+// it is missing or in a format ParseTanggal does not understand.
+func TanggalLahir(doc bson.M) (time.Time, bool) {
+	paths := []string{"tanggal_lahir"}
+	if _, ok := doc["data_imunisasi"]; ok {
+		paths = tanggalLahirBayiPaths
+	}
+	for _, path := range paths {
+		if t, ok := parseAt(doc, path); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseAt(doc bson.M, path string) (time.Time, bool) {
+	value, ok := Lookup(doc, path)
+	if !ok {
+		return time.Time{}, false
+	}
+	return AsTime(value)
+}
+
+// HitungUmur returns the completed years between lahir and now.
+func HitungUmur(lahir, now time.Time) int {
+	years := now.Year() - lahir.Year()
+	if now.Month() < lahir.Month() || (now.Month() == lahir.Month() && now.Day() < lahir.Day()) {
+		years--
+	}
+	return years
+}
+
+// HitungUsiaBayi returns the completed months and the remaining days
+// between lahir and now. A birthday past the end of a month falls on its
+// last day: a baby born on 31 January is one month old on 28 February.
+func HitungUsiaBayi(lahir, now time.Time) (bulan, hari int) {
+	y, m, d := now.In(Jakarta).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	bulan = (y-lahir.In(Jakarta).Year())*12 + int(m-lahir.In(Jakarta).Month())
+	if tambahBulan(lahir, bulan).After(today) {
+		bulan--
+	}
+	if bulan < 0 {
+		return 0, 0
+	}
+	hari = int(today.Sub(tambahBulan(lahir, bulan)).Hours() / 24)
+	return bulan, hari
+}
+
+// tambahBulan returns the date, at midnight UTC, n months after lahir, with
+// the day clamped to the last day of that month instead of rolling over
+// into the next one as time.AddDate does.
+func tambahBulan(lahir time.Time, n int) time.Time {
+	y, m, d := lahir.In(Jakarta).Date()
+	first := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return time.Date(first.Year(), first.Month(), d, 0, 0, 0, 0, time.UTC)
+}
+
+// Umur is the age shown for a patient: whole years for KB and Kehamilan,
+// "x bulan y hari" for babies in Imunisasi. When tanggal_lahir is missing the
+// value typed on the form (umur) is returned as it was stored.
+func Umur(doc bson.M, now time.Time) interface{} {
+	now = now.In(Jakarta)
+	lahir, ok := TanggalLahir(doc)
+	if !ok {
+		return doc["umur"]
+	}
+	if _, ok := doc["data_imunisasi"]; ok {
+		bulan, hari := HitungUsiaBayi(lahir, now)
+		return fmt.Sprintf("%d bulan %d hari", bulan, hari)
+	}
+	return HitungUmur(lahir, now)
+}
+
+// UmurOrangTua returns the age in years of the baby's father ("ayah") or
+// mother ("ibu"), from tanggal_lahir_ayah/tanggal_lahir_ibu when present,
+// otherwise the stored umur_ayah/umur_ibu.
+func UmurOrangTua(doc bson.M, orangTua string, now time.Time) interface{} {
+	if lahir, ok := parseAt(doc, "tanggal_lahir_"+orangTua); ok {
+		return HitungUmur(lahir, now.In(Jakarta))
+	}
+	return doc["umur_"+orangTua]
+}
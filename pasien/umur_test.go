@@ -0,0 +1,33 @@
+package pasien
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHitungUsiaBayi(t *testing.T) {
+	tanggal := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 10, 0, 0, 0, Jakarta)
+	}
+	tests := []struct {
+		lahir, now  time.Time
+		bulan, hari int
+	}{
+		{tanggal(2025, 1, 15), tanggal(2025, 3, 20), 2, 5},
+		{tanggal(2025, 1, 15), tanggal(2025, 3, 14), 1, 27},
+		{tanggal(2025, 1, 31), tanggal(2025, 2, 27), 0, 27},
+		{tanggal(2025, 1, 31), tanggal(2025, 2, 28), 1, 0},
+		{tanggal(2025, 1, 31), tanggal(2025, 3, 1), 1, 1},
+		{tanggal(2025, 1, 31), tanggal(2025, 3, 31), 2, 0},
+		{tanggal(2024, 1, 31), tanggal(2024, 2, 29), 1, 0},
+		{tanggal(2025, 3, 31), tanggal(2025, 4, 30), 1, 0},
+		{tanggal(2025, 5, 10), tanggal(2025, 5, 1), 0, 0},
+	}
+	for _, tt := range tests {
+		bulan, hari := HitungUsiaBayi(tt.lahir, tt.now)
+		if bulan != tt.bulan || hari != tt.hari {
+			t.Errorf("HitungUsiaBayi(%s, %s) = %d bulan %d hari, want %d bulan %d hari",
+				tt.lahir.Format("2006-01-02"), tt.now.Format("2006-01-02"), bulan, hari, tt.bulan, tt.hari)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package pasien
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// VersionField is incremented on every write to a pasien document. Documents
+// created before versioning have no such field and count as version 0.
+const VersionField = "version"
+
+// ErrIfMatchRequired is returned when an update is sent without If-Match.
+var ErrIfMatchRequired = errors.New("header If-Match wajib diisi dengan ETag dari data pasien terakhir")
+
+// ErrInvalidIfMatch is returned when If-Match is not a version ETag.
+var ErrInvalidIfMatch = errors.New("If-Match tidak valid")
+
+// ErrNotFound is returned when the patient to update does not exist.
+var ErrNotFound = errors.New("id_pasien tidak ditemukan")
+
+// VersionConflictError is returned when the document changed after the
+// client read it. Current is the version now stored.
+type VersionConflictError struct {
+	Current int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("data pasien sudah diubah oleh pengguna lain (versi sekarang %d), muat ulang data sebelum menyimpan", e.Current)
+}
+
+// Version returns the version of a pasien document.
+func Version(doc bson.M) int64 {
+	switch v := doc[VersionField].(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// ETag formats a version as a strong entity tag.
+func ETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// IfMatch reads the expected version from the If-Match header. Weak tags
+// (W/"3") are accepted since the version is the only thing compared.
+func IfMatch(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, ErrIfMatchRequired
+	}
+	header = strings.TrimPrefix(header, "W/")
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidIfMatch, r.Header.Get("If-Match"))
+	}
+	return version, nil
+}
+
+// VersionFilter adds the expected version to filter. Version 0 also
+// matches documents that have never been versioned.
+func VersionFilter(filter bson.M, version int64) bson.M {
+	versioned := bson.M{}
+	for key, value := range filter {
+		versioned[key] = value
+	}
+	if version == 0 {
+		versioned["$or"] = []bson.M{
+			{VersionField: int64(0)},
+			{VersionField: bson.M{"$exists": false}},
+		}
+	} else {
+		versioned[VersionField] = version
+	}
+	return versioned
+}
+
+// UpdateVersioned applies update, a $set and/or $unset, to the document
+// matching filter only if it is still at version, and bumps the version.
+// The search sub-document is rebuilt from the document as it will be after
+// the update and written in the same update, so a cleared field stops
+// being searchable. Values under $set are encrypted as configured. It
+// returns the new document decrypted, a *VersionConflictError when someone
+// else wrote first, ErrNIKExists when the nik_unique index rejects the
+// change, or ErrNotFound.
+func UpdateVersioned(ctx context.Context, collection *mongo.Collection, filter bson.M, version int64, update bson.M) (bson.M, error) {
+	var current bson.M
+	err := collection.FindOne(ctx, filter).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if Version(current) != version {
+		return nil, &VersionConflictError{Current: Version(current)}
+	}
+	if err := Decrypt(current); err != nil {
+		return nil, err
+	}
+
+	set, _ := update["$set"].(bson.M)
+	unset, _ := update["$unset"].(bson.M)
+	merged := applyUpdate(current, set, unset)
+	set = copyMap(set)
+	set["search"] = SearchFields(merged)
+
+	withVersion := bson.M{}
+	for key, value := range update {
+		withVersion[key] = value
+	}
+	withVersion["$inc"] = bson.M{VersionField: 1}
+	encrypted, err := Encrypted(set)
+	if err != nil {
+		return nil, err
+	}
+	withVersion["$set"] = encrypted
+	if unset, ok := update["$unset"].(bson.M); ok {
+		if _, ok := unset["nik"]; ok {
+			unset = copyMap(unset)
+			unset[NIKBlindField] = ""
+			withVersion["$unset"] = unset
+		}
+	}
+
+	var updated bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx, VersionFilter(filter, version), withVersion, opts).Decode(&updated)
+	if err == nil {
+		return updated, Decrypt(updated)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrNIKExists
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	err = collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{VersionField: 1})).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, &VersionConflictError{Current: Version(current)}
+}
+
+// applyUpdate returns a copy of doc with set and unset applied as MongoDB
+// would: keys may be dotted paths, and a $set of a sub-document replaces
+// it whole.
+func applyUpdate(doc, set, unset bson.M) bson.M {
+	result := deepCopy(doc)
+	for path, value := range set {
+		parent, key := parentOf(result, path, true)
+		parent[key] = value
+	}
+	for path := range unset {
+		if parent, key := parentOf(result, path, false); parent != nil {
+			delete(parent, key)
+		}
+	}
+	return result
+}
+
+// parentOf returns the sub-document holding the last key of path, creating
+// the missing ones when create is set.
+func parentOf(doc bson.M, path string, create bool) (bson.M, string) {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := asMap(current[part])
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			next = bson.M{}
+		}
+		current[part] = bson.M(next)
+		current = next
+	}
+	return current, parts[len(parts)-1]
+}
+
+func deepCopy(doc bson.M) bson.M {
+	result := bson.M{}
+	for key, value := range doc {
+		if sub, ok := asMap(value); ok {
+			value = deepCopy(bson.M(sub))
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// WriteUpdateError writes the response for an error from IfMatch or
+// UpdateVersioned: 428 without If-Match, 409 with the current version on a
+// conflict or for a NIK already registered, 404 for a missing patient.
+func WriteUpdateError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	body := map[string]interface{}{"message": err.Error()}
+
+	var conflict *VersionConflictError
+	switch {
+	case errors.As(err, &conflict):
+		status = http.StatusConflict
+		body["version"] = conflict.Current
+		w.Header().Set("ETag", ETag(conflict.Current))
+	case errors.Is(err, ErrIfMatchRequired):
+		status = http.StatusPreconditionRequired
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidIfMatch):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrNIKExists):
+		status = http.StatusConflict
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(body)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
@@ -0,0 +1,43 @@
+package pasien
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestApplyUpdate(t *testing.T) {
+	doc := bson.M{
+		"nama_pasien": "Siti",
+		"no_hp":       "+6281234567890",
+		"data_kb":     bson.M{"no_seri_kartu": "AB-12", "status_jkn": "pbi"},
+	}
+	got := applyUpdate(doc,
+		bson.M{"nama_pasien": "Siti Aminah", "data_kb.status_jkn": "mandiri", "data_kehamilan.desa": "Suka Maju"},
+		bson.M{"no_hp": "", "alamat.jalan": ""},
+	)
+	want := bson.M{
+		"nama_pasien":    "Siti Aminah",
+		"data_kb":        bson.M{"no_seri_kartu": "AB-12", "status_jkn": "mandiri"},
+		"data_kehamilan": bson.M{"desa": "Suka Maju"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("applyUpdate = %v, want %v", got, want)
+	}
+	if doc["no_hp"] == nil || doc["data_kb"].(bson.M)["status_jkn"] != "pbi" {
+		t.Errorf("applyUpdate changed its argument: %v", doc)
+	}
+}
+
+// Clearing a searchable field removes it from search instead of leaving
+// the old value findable.
+func TestSearchAfterClearing(t *testing.T) {
+	doc := bson.M{"nama_pasien": "Siti", "no_hp": "+6281234567890", "nik": "3201014101900001"}
+	search := SearchFields(applyUpdate(doc, bson.M{"nik": nil, "nama_pasien": "  "}, bson.M{"no_hp": ""}))
+	for _, key := range []string{"nik", "nama", "no_hp"} {
+		if value, ok := search[key]; ok {
+			t.Errorf("search.%s is still %v", key, value)
+		}
+	}
+}
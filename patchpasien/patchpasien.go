@@ -0,0 +1,255 @@
+package patchpasien
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// currentGeneralInformation rebuilds the generalInformation section in the
+// shape the form sends it, from wherever each field is stored.
+func currentGeneralInformation(pasienData bson.M, idLayanan int) map[string]interface{} {
+	current := map[string]interface{}{}
+	for formKey, path := range pasien.GeneralInformation[idLayanan] {
+		if value, ok := pasien.Lookup(pasienData, path); ok {
+			current[formKey] = value
+		}
+	}
+	return current
+}
+
+// patchGeneralInformation validates the merged section and translates the
+// patch into updates of the individual stored fields.
+func patchGeneralInformation(ctx context.Context, collection *mongo.Collection, pasienData bson.M, idLayanan int, patch map[string]interface{}, set, unset bson.M) (interface{}, int, error) {
+	fields := pasien.GeneralInformation[idLayanan]
+	for key := range patch {
+		if _, ok := fields[key]; !ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("field %q tidak ada di generalInformation", key)
+		}
+	}
+
+	merged := pasien.MergePatch(currentGeneralInformation(pasienData, idLayanan), patch).(map[string]interface{})
+	if nama, _ := merged[pasien.NamaField[idLayanan]].(string); strings.TrimSpace(nama) == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("%s tidak boleh kosong", pasien.NamaField[idLayanan])
+	}
+
+	identity, err := pasien.IdentityFields(merged)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if _, ok := patch["nik"]; ok {
+		if err := pasien.CheckNIKUnique(ctx, collection, identity["nik"], pasienData["id_pasien"]); err != nil {
+			if err == pasien.ErrNIKExists {
+				return nil, http.StatusConflict, err
+			}
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	for key, value := range patch {
+		path := fields[key]
+		if normalized, ok := identity[path]; ok && value != nil {
+			value = normalized
+		}
+		value, err = pasien.NormalizeDate(path, value)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if path == "no_hp" {
+			value, err = pasien.NormalizePhoneValue(value)
+			if err != nil {
+				return nil, http.StatusBadRequest, err
+			}
+		}
+		current, _ := pasien.Lookup(pasienData, path)
+		if err := pasien.MergePatchUpdate(path, current, value, set, unset); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	return merged, http.StatusOK, nil
+}
+
+func patchSection(pasienData bson.M, idLayanan int, section, path string, patch map[string]interface{}, set, unset bson.M) (interface{}, int, error) {
+	if idLayanan == pasien.LayananKehamilan && section == "section2" {
+		noTelp, err := pasien.NormalizePhoneValue(patch["noTelp"])
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if noTelp != nil {
+			patch["noTelp"] = noTelp
+		}
+	}
+
+	current, _ := pasien.Lookup(pasienData, path)
+	merged := pasien.MergePatch(current, patch)
+	if err := pasien.ValidateSection(path, merged); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	sectionSet, sectionUnset := set, unset
+	if pasien.IsEncrypted(path) {
+		sectionSet, sectionUnset = bson.M{}, bson.M{}
+	}
+	if err := pasien.MergePatchUpdate(path, current, patch, sectionSet, sectionUnset); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if pasien.IsEncrypted(path) {
+		// An encrypted section is stored as a single ciphertext, so it can
+		// only be written whole.
+		set[path] = merged
+	}
+
+	// Kehamilan keeps the phone number both in section2 and at the root.
+	if idLayanan == pasien.LayananKehamilan && section == "section2" {
+		if _, ok := patch["noTelp"]; ok {
+			if noTelp, ok := merged.(map[string]interface{})["noTelp"]; ok {
+				set["no_hp"] = noTelp
+			} else {
+				unset["no_hp"] = ""
+			}
+		}
+	}
+	return merged, http.StatusOK, nil
+}
+
+// PatchPasien applies a JSON Merge Patch (RFC 7396) to one section of a
+// patient record. Only the fields present in the patch are written; a null
+// removes the field. The merged section is validated before anything is
+// stored and returned in the response, and search is rebuilt in the same
+// update, see pasien.UpdateVersioned. The request must carry the ETag of
+// the version it was based on in If-Match.
+func PatchPasien(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPatch {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	idPasien, err := pasien.ParseID(r.URL.Query().Get("id_pasien"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+		return
+	}
+
+	idLayanan, err := strconv.Atoi(r.URL.Query().Get("id_layanan"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id_layanan")
+		return
+	}
+	layananField, ok := pasien.LayananField[idLayanan]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid id_layanan")
+		return
+	}
+
+	section := r.URL.Query().Get("section")
+	sectionPath, isSection := pasien.Sections[idLayanan][section]
+	if section != "generalInformation" && !isSection {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("section %q tidak dikenal untuk id_layanan %d", section, idLayanan))
+		return
+	}
+
+	version, err := pasien.IfMatch(r)
+	if err != nil {
+		pasien.WriteUpdateError(w, err)
+		return
+	}
+
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		respondWithError(w, http.StatusBadRequest, "body harus berupa JSON Merge Patch object")
+		return
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	collection := client.Database("mydb").Collection("pasien")
+	filter := bson.M{"id_pasien": idPasien, layananField: bson.M{"$exists": true}}
+
+	pasienData, err := pasien.FindOne(context.Background(), collection, filter)
+	if err == mongo.ErrNoDocuments {
+		respondWithError(w, http.StatusNotFound, "id_pasien tidak ditemukan")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error finding pasien")
+		return
+	}
+	if current := pasien.Version(pasienData); current != version {
+		pasien.WriteUpdateError(w, &pasien.VersionConflictError{Current: current})
+		return
+	}
+
+	set := bson.M{}
+	unset := bson.M{}
+	var merged interface{}
+	var status int
+	if section == "generalInformation" {
+		merged, status, err = patchGeneralInformation(context.Background(), collection, pasienData, idLayanan, patch, set, unset)
+	} else {
+		merged, status, err = patchSection(pasienData, idLayanan, section, sectionPath, patch, set, unset)
+	}
+	if err != nil {
+		respondWithError(w, status, err.Error())
+		return
+	}
+
+	if len(set) == 0 && len(unset) == 0 {
+		w.Header().Set("ETag", pasien.ETag(version))
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": merged, "version": version})
+		return
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	updated, err := pasien.UpdateVersioned(context.Background(), collection, filter, version, update)
+	if err != nil {
+		pasien.WriteUpdateError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", pasien.ETag(pasien.Version(updated)))
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": merged, "version": pasien.Version(updated)})
+}
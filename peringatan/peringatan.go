@@ -0,0 +1,758 @@
+package peringatan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one alert per rule and source entry. AturanCollection
+// holds the rules a superadmin added or changed; the rest come from
+// DefaultAturan.
+const (
+	Collection       = "peringatan"
+	AturanCollection = "aturan_peringatan"
+)
+
+// Sources an observation comes from.
+const (
+	SumberSoap = "soap"
+	SumberLab  = "lab"
+)
+
+// Tingkat of an alert. Darurat needs action during the visit, waspada
+// needs follow-up.
+const (
+	TingkatDarurat = "darurat"
+	TingkatWaspada = "waspada"
+)
+
+// Status of an alert. An open alert is terbuka until a bidan acknowledges
+// it and stays on the worklist until it is selesai.
+const (
+	StatusTerbuka = "terbuka"
+	StatusDiakui  = "diakui"
+	StatusSelesai = "selesai"
+)
+
+var (
+	ErrNotFound      = errors.New("peringatan tidak ditemukan")
+	ErrSudahSelesai  = errors.New("peringatan sudah selesai")
+	ErrAturanInvalid = errors.New("aturan tidak valid")
+)
+
+// Kondisi is one test of a rule on an observation: a measurement of a
+// SOAP entry (Field is a key of soap.Vital) or a lab result (Field is the
+// lab test). Operator is <, <=, > or >= against Nilai, or "in" against
+// the qualitative results in Teks.
+type Kondisi struct {
+	Sumber   string   `bson:"sumber" json:"sumber"`
+	Field    string   `bson:"field" json:"field"`
+	Operator string   `bson:"operator" json:"operator"`
+	Nilai    float64  `bson:"nilai,omitempty" json:"nilai,omitempty"`
+	Teks     []string `bson:"teks,omitempty" json:"teks,omitempty"`
+}
+
+// Aturan is a clinical rule. It fires when any of its Kondisi holds. A
+// rule with Layanan only looks at SOAP entries of those layanan; lab
+// results are not tied to a layanan.
+type Aturan struct {
+	Kode    string    `bson:"_id" json:"kode"`
+	Nama    string    `bson:"nama" json:"nama"`
+	Tingkat string    `bson:"tingkat" json:"tingkat"`
+	Pesan   string    `bson:"pesan" json:"pesan"`
+	Layanan []int     `bson:"layanan,omitempty" json:"layanan,omitempty"`
+	Kondisi []Kondisi `bson:"kondisi" json:"kondisi"`
+	Aktif   bool      `bson:"aktif" json:"aktif"`
+}
+
+// DefaultAturan are the danger signs of the KIA book checked when no
+// superadmin has changed them.
+var DefaultAturan = []Aturan{
+	{
+		Kode: "hipertensi_berat", Nama: "Hipertensi berat", Tingkat: TingkatDarurat, Aktif: true,
+		Pesan: "Tekanan darah >= 160/110: tangani sebagai preeklampsia berat dan rujuk segera.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberSoap, Field: "sistolik", Operator: ">=", Nilai: 160},
+			{Sumber: SumberSoap, Field: "diastolik", Operator: ">=", Nilai: 110},
+		},
+	},
+	{
+		Kode: "hipertensi", Nama: "Hipertensi", Tingkat: TingkatWaspada, Aktif: true,
+		Pesan: "Tekanan darah >= 140/90: periksa protein urine dan tanda preeklampsia.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberSoap, Field: "sistolik", Operator: ">=", Nilai: 140},
+			{Sumber: SumberSoap, Field: "diastolik", Operator: ">=", Nilai: 90},
+		},
+	},
+	{
+		Kode: "proteinuria", Nama: "Proteinuria", Tingkat: TingkatWaspada, Aktif: true,
+		Pesan: "Protein urine +2 atau lebih: periksa tekanan darah dan tanda preeklampsia.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberLab, Field: "protein_urine", Operator: "in", Teks: []string{"+2", "+3", "+4"}},
+		},
+	},
+	{
+		Kode: "anemia_berat", Nama: "Anemia berat", Tingkat: TingkatDarurat, Aktif: true,
+		Pesan: "Hb < 7 g/dL: rujuk untuk tata laksana anemia berat.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberSoap, Field: "hb", Operator: "<", Nilai: 7},
+			{Sumber: SumberLab, Field: "hb", Operator: "<", Nilai: 7},
+		},
+	},
+	{
+		Kode: "hiperglikemia", Nama: "Gula darah tinggi", Tingkat: TingkatWaspada, Aktif: true,
+		Pesan: "Gula darah sewaktu >= 200 mg/dL: rujuk untuk pemeriksaan diabetes.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberLab, Field: "glukosa_sewaktu", Operator: ">=", Nilai: 200},
+		},
+	},
+	{
+		Kode: "djj_abnormal", Nama: "DJJ tidak normal", Tingkat: TingkatDarurat, Aktif: true, Layanan: []int{pasien.LayananKehamilan},
+		Pesan: "DJJ < 120 atau > 160 x/menit: nilai kesejahteraan janin dan rujuk.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberSoap, Field: "djj", Operator: "<", Nilai: 120},
+			{Sumber: SumberSoap, Field: "djj", Operator: ">", Nilai: 160},
+		},
+	},
+	{
+		Kode: "demam", Nama: "Demam", Tingkat: TingkatWaspada, Aktif: true,
+		Pesan: "Suhu >= 38 °C: cari sumber infeksi.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberSoap, Field: "suhu", Operator: ">=", Nilai: 38},
+		},
+	},
+	{
+		Kode: "berat_badan_rendah", Nama: "Berat badan bayi rendah", Tingkat: TingkatWaspada, Aktif: true, Layanan: []int{pasien.LayananImunisasi},
+		Pesan: "Berat badan < 2,5 kg: pantau pertumbuhan dan pemberian ASI.",
+		Kondisi: []Kondisi{
+			{Sumber: SumberSoap, Field: "beratBadan", Operator: "<", Nilai: 2.5},
+		},
+	},
+}
+
+func (k Kondisi) validate() error {
+	if k.Sumber != SumberSoap && k.Sumber != SumberLab {
+		return fmt.Errorf("sumber harus %s atau %s", SumberSoap, SumberLab)
+	}
+	if strings.TrimSpace(k.Field) == "" {
+		return fmt.Errorf("field needed")
+	}
+	switch k.Operator {
+	case "<", "<=", ">", ">=":
+	case "in":
+		if len(k.Teks) == 0 {
+			return fmt.Errorf("operator in butuh teks")
+		}
+	default:
+		return fmt.Errorf("operator %q tidak dikenal", k.Operator)
+	}
+	return nil
+}
+
+// Validate checks a rule sent by a superadmin.
+func (a Aturan) Validate() error {
+	var problems []string
+	if strings.TrimSpace(a.Kode) == "" {
+		problems = append(problems, "kode needed")
+	}
+	if strings.TrimSpace(a.Nama) == "" {
+		problems = append(problems, "nama needed")
+	}
+	if a.Tingkat != TingkatDarurat && a.Tingkat != TingkatWaspada {
+		problems = append(problems, fmt.Sprintf("tingkat harus %s atau %s", TingkatDarurat, TingkatWaspada))
+	}
+	if len(a.Kondisi) == 0 {
+		problems = append(problems, "kondisi needed")
+	}
+	for i, k := range a.Kondisi {
+		if err := k.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("kondisi %d: %v", i+1, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrAturanInvalid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Rules returns the rules in force: DefaultAturan with the rules stored in
+// AturanCollection replacing those of the same kode, in order of kode.
+func Rules(ctx context.Context, db *mongo.Database) ([]Aturan, error) {
+	byKode := map[string]Aturan{}
+	for _, a := range DefaultAturan {
+		byKode[a.Kode] = a
+	}
+	cursor, err := db.Collection(AturanCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var stored []Aturan
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+	for _, a := range stored {
+		byKode[a.Kode] = a
+	}
+	rules := make([]Aturan, 0, len(byKode))
+	for _, a := range byKode {
+		rules = append(rules, a)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Kode < rules[j].Kode })
+	return rules, nil
+}
+
+// Observasi is what one SOAP entry or lab result recorded about a patient.
+// Angka holds the measurements by key, Teks the qualitative results.
+type Observasi struct {
+	IDPasien  pasien.ID
+	IDLayanan *int
+	Sumber    string
+	IDSumber  primitive.ObjectID
+	Tanggal   time.Time
+	Angka     map[string]float64
+	Teks      map[string]string
+}
+
+// match returns what made k hold for o, or "" when it does not.
+func (k Kondisi) match(o Observasi) string {
+	if k.Sumber != o.Sumber {
+		return ""
+	}
+	if k.Operator == "in" {
+		value, ok := o.Teks[k.Field]
+		if !ok {
+			return ""
+		}
+		for _, t := range k.Teks {
+			if strings.EqualFold(t, value) {
+				return fmt.Sprintf("%s %s", k.Field, value)
+			}
+		}
+		return ""
+	}
+	value, ok := o.Angka[k.Field]
+	if !ok {
+		return ""
+	}
+	var hit bool
+	switch k.Operator {
+	case "<":
+		hit = value < k.Nilai
+	case "<=":
+		hit = value <= k.Nilai
+	case ">":
+		hit = value > k.Nilai
+	case ">=":
+		hit = value >= k.Nilai
+	}
+	if !hit {
+		return ""
+	}
+	return fmt.Sprintf("%s %v", k.Field, value)
+}
+
+// Temuan returns what made a fire for o, or "" when it does not.
+func (a Aturan) Temuan(o Observasi) string {
+	if !a.Aktif {
+		return ""
+	}
+	if a.Layanan != nil {
+		if o.IDLayanan == nil {
+			return ""
+		}
+		found := false
+		for _, l := range a.Layanan {
+			found = found || l == *o.IDLayanan
+		}
+		if !found {
+			return ""
+		}
+	}
+	var hits []string
+	for _, k := range a.Kondisi {
+		if hit := k.match(o); hit != "" {
+			hits = append(hits, hit)
+		}
+	}
+	return strings.Join(hits, ", ")
+}
+
+// Tindakan is who acknowledged or resolved an alert, and when.
+type Tindakan struct {
+	Oleh    sesi.Bidan `bson:"oleh" json:"oleh"`
+	Pada    time.Time  `bson:"pada" json:"pada"`
+	Catatan string     `bson:"catatan,omitempty" json:"catatan,omitempty"`
+}
+
+// Peringatan is an alert raised by a rule on one SOAP entry or lab result.
+type Peringatan struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id_peringatan"`
+	IDPasien     pasien.ID          `bson:"id_pasien" json:"id_pasien"`
+	IDLayanan    *int               `bson:"id_layanan,omitempty" json:"id_layanan,omitempty"`
+	Sumber       string             `bson:"sumber" json:"sumber"`
+	IDSumber     primitive.ObjectID `bson:"id_sumber" json:"id_sumber"`
+	Tanggal      time.Time          `bson:"tanggal" json:"tanggal"`
+	Aturan       string             `bson:"aturan" json:"aturan"`
+	Nama         string             `bson:"nama" json:"nama"`
+	Tingkat      string             `bson:"tingkat" json:"tingkat"`
+	Pesan        string             `bson:"pesan" json:"pesan"`
+	Temuan       string             `bson:"temuan" json:"temuan"`
+	Status       string             `bson:"status" json:"status"`
+	DibuatPada   time.Time          `bson:"dibuat_pada" json:"dibuat_pada"`
+	Diakui       *Tindakan          `bson:"diakui,omitempty" json:"diakui,omitempty"`
+	Diselesaikan *Tindakan          `bson:"diselesaikan,omitempty" json:"diselesaikan,omitempty"`
+}
+
+// catatanTidakBerlaku marks an alert Evaluate resolved because a
+// correction cleared its danger sign, as opposed to one a bidan resolved.
+const catatanTidakBerlaku = "tidak berlaku lagi setelah data dikoreksi"
+
+var (
+	indexMu   sync.Mutex
+	indexDone bool
+)
+
+// ensureIndexes creates the unique index on the entry and rule of an
+// alert, so two evaluations of the same entry at once cannot raise it twice.
+func ensureIndexes(ctx context.Context, collection *mongo.Collection) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	if indexDone {
+		return nil
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "id_sumber", Value: 1}, {Key: "aturan", Value: 1}},
+		Options: options.Index().SetName("sumber_aturan_unique").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	indexDone = true
+	return nil
+}
+
+// Evaluate checks o against the rules in force and stores an alert for
+// every rule that fires. It is called again when the entry is corrected:
+// an alert already raised for the entry and rule is updated, not repeated,
+// open alerts of rules that no longer fire are resolved, and alerts
+// resolved that way are opened again when their rule fires again.
+func Evaluate(ctx context.Context, db *mongo.Database, o Observasi) error {
+	rules, err := Rules(ctx, db)
+	if err != nil {
+		return err
+	}
+	collection := db.Collection(Collection)
+	if err := ensureIndexes(ctx, collection); err != nil {
+		return err
+	}
+	now := time.Now()
+
+	fired := []string{}
+	for _, a := range rules {
+		temuan := a.Temuan(o)
+		if temuan == "" {
+			continue
+		}
+		fired = append(fired, a.Kode)
+		filter := bson.M{"id_sumber": o.IDSumber, "aturan": a.Kode}
+		var current *Peringatan
+		var stored Peringatan
+		err := collection.FindOne(ctx, filter).Decode(&stored)
+		switch {
+		case err == nil:
+			current = &stored
+		case err != mongo.ErrNoDocuments:
+			return err
+		}
+		update := fireUpdate(current, o, a, temuan, now)
+		if reopened(current) {
+			// Only if a bidan has not resolved it in the meantime.
+			filter["diselesaikan.catatan"] = catatanTidakBerlaku
+		}
+		if _, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(current == nil)); err != nil {
+			return err
+		}
+	}
+
+	_, err = collection.UpdateMany(ctx, staleFilter(o.IDSumber, fired), bson.M{"$set": bson.M{
+		"status":       StatusSelesai,
+		"diselesaikan": bson.M{"pada": now, "catatan": catatanTidakBerlaku},
+	}})
+	return err
+}
+
+// reopened reports whether a rule firing again reopens current: it was
+// resolved by Evaluate because an earlier correction cleared the danger
+// sign. An alert a bidan resolved stays resolved.
+func reopened(current *Peringatan) bool {
+	return current != nil && current.Status == StatusSelesai &&
+		current.Diselesaikan != nil && current.Diselesaikan.Catatan == catatanTidakBerlaku
+}
+
+// fireUpdate is the update storing the alert of rule a firing on o with
+// temuan. current is the alert already raised for the entry and rule, or
+// nil when there is none yet.
+func fireUpdate(current *Peringatan, o Observasi, a Aturan, temuan string, now time.Time) bson.M {
+	set := bson.M{
+		"id_pasien": o.IDPasien,
+		"tanggal":   o.Tanggal,
+		"nama":      a.Nama,
+		"tingkat":   a.Tingkat,
+		"pesan":     a.Pesan,
+		"temuan":    temuan,
+	}
+	update := bson.M{
+		"$set": set,
+		"$setOnInsert": bson.M{
+			"id_layanan":  o.IDLayanan,
+			"sumber":      o.Sumber,
+			"status":      StatusTerbuka,
+			"dibuat_pada": now,
+		},
+	}
+	if reopened(current) {
+		set["status"] = StatusTerbuka
+		delete(update["$setOnInsert"].(bson.M), "status")
+		update["$unset"] = bson.M{"diakui": "", "diselesaikan": ""}
+	}
+	return update
+}
+
+// staleFilter matches the unresolved alerts of an entry raised by rules
+// other than fired. fired must not be nil: the server rejects a null $nin,
+// and a correction that clears every danger sign has nothing in fired.
+func staleFilter(idSumber primitive.ObjectID, fired []string) bson.M {
+	if fired == nil {
+		fired = []string{}
+	}
+	return bson.M{
+		"id_sumber": idSumber,
+		"aturan":    bson.M{"$nin": fired},
+		"status":    bson.M{"$ne": StatusSelesai},
+	}
+}
+
+// Close resolves the open alerts of an entry that was deleted.
+func Close(ctx context.Context, db *mongo.Database, idSumber primitive.ObjectID, oleh sesi.Bidan) error {
+	_, err := db.Collection(Collection).UpdateMany(ctx,
+		bson.M{"id_sumber": idSumber, "status": bson.M{"$ne": StatusSelesai}},
+		bson.M{"$set": bson.M{
+			"status":       StatusSelesai,
+			"diselesaikan": Tindakan{Oleh: oleh, Pada: time.Now(), Catatan: "data sumber dihapus"},
+		}},
+	)
+	return err
+}
+
+// Raise evaluates o and logs instead of failing when the alerts cannot be
+// written, since the entry they are about has already been saved.
+func Raise(ctx context.Context, db *mongo.Database, o Observasi) {
+	if err := Evaluate(ctx, db, o); err != nil {
+		log.Printf("peringatan: %s %s: %v", o.Sumber, o.IDSumber.Hex(), err)
+	}
+}
+
+// Open returns the alerts of the patients in ids that are not selesai,
+// newest first.
+func Open(ctx context.Context, db *mongo.Database, ids []pasien.ID) (map[pasien.ID][]Peringatan, error) {
+	filter := bson.M{"id_pasien": bson.M{"$in": ids}, "status": bson.M{"$ne": StatusSelesai}}
+	opts := options.Find().SetSort(bson.D{{Key: "tanggal", Value: -1}})
+	cursor, err := db.Collection(Collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var list []Peringatan
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	byPasien := map[pasien.ID][]Peringatan{}
+	for _, p := range list {
+		byPasien[p.IDPasien] = append(byPasien[p.IDPasien], p)
+	}
+	return byPasien, nil
+}
+
+// Act acknowledges (status diakui) or resolves (status selesai) an alert.
+func Act(ctx context.Context, db *mongo.Database, id primitive.ObjectID, status string, oleh sesi.Bidan, catatan string) (Peringatan, error) {
+	field := "diakui"
+	if status == StatusSelesai {
+		field = "diselesaikan"
+	}
+	var p Peringatan
+	err := db.Collection(Collection).FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": bson.M{"$ne": StatusSelesai}},
+		bson.M{"$set": bson.M{"status": status, field: Tindakan{Oleh: oleh, Pada: time.Now(), Catatan: strings.TrimSpace(catatan)}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		count, err := db.Collection(Collection).CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return p, err
+		}
+		if count > 0 {
+			return p, ErrSudahSelesai
+		}
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// DeleteForPasien deletes every alert of a patient, for when the patient
+// is deleted or anonymised.
+func DeleteForPasien(ctx context.Context, db *mongo.Database, idPasien interface{}) error {
+	_, err := db.Collection(Collection).DeleteMany(ctx, bson.M{"id_pasien": idPasien})
+	return err
+}
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func status(err error) int {
+	switch {
+	case errors.Is(err, sesi.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrSudahSelesai):
+		return http.StatusConflict
+	case errors.Is(err, ErrAturanInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// Baris is an alert on the worklist with the patient it is about.
+type Baris struct {
+	Peringatan `bson:",inline"`
+	NamaPasien string `bson:"-" json:"nama_pasien"`
+	NoHP       string `bson:"-" json:"no_hp,omitempty"`
+}
+
+// Handler serves /api/peringatan.
+//
+// GET lists the open alerts (terbuka and diakui) as a worklist, darurat
+// first and then newest first; ?id_pasien=, ?tingkat= and ?status= narrow
+// it. POST ?id_peringatan=&aksi=akui|selesai with {catatan} acknowledges
+// or resolves an alert and needs the bidan's token.
+//
+// With ?aksi=aturan, GET lists the rules in force, PUT stores a rule sent
+// as JSON (replacing the default of the same kode) and DELETE ?kode=
+// restores the default; changing rules is for superadmins.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	var bidan sesi.Bidan
+	if r.Method != http.MethodGet {
+		var err error
+		bidan, err = sesi.FromRequest(r)
+		if err != nil {
+			respondWithError(w, status(err), err.Error())
+			return
+		}
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	if r.URL.Query().Get("aksi") == "aturan" {
+		aturan(w, r, db, bidan)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		worklist(w, r, db)
+
+	case http.MethodPost:
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id_peringatan"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_peringatan")
+			return
+		}
+		var next string
+		switch r.URL.Query().Get("aksi") {
+		case "akui":
+			next = StatusDiakui
+		case "selesai":
+			next = StatusSelesai
+		default:
+			respondWithError(w, http.StatusBadRequest, "aksi harus akui atau selesai")
+			return
+		}
+		var body struct {
+			Catatan string `json:"catatan"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+		if next == StatusSelesai && strings.TrimSpace(body.Catatan) == "" {
+			respondWithError(w, http.StatusBadRequest, "catatan tindak lanjut wajib diisi untuk menyelesaikan peringatan")
+			return
+		}
+		p, err := Act(r.Context(), db, id, next, bidan, body.Catatan)
+		if err != nil {
+			respondWithError(w, status(err), err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": p})
+
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func worklist(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	query := r.URL.Query()
+	filter := bson.M{"status": bson.M{"$in": []string{StatusTerbuka, StatusDiakui}}}
+	if s := query.Get("status"); s != "" {
+		if s != StatusTerbuka && s != StatusDiakui && s != StatusSelesai {
+			respondWithError(w, http.StatusBadRequest, "invalid status")
+			return
+		}
+		filter["status"] = s
+	}
+	if t := query.Get("tingkat"); t != "" {
+		if t != TingkatDarurat && t != TingkatWaspada {
+			respondWithError(w, http.StatusBadRequest, "invalid tingkat")
+			return
+		}
+		filter["tingkat"] = t
+	}
+	if id := query.Get("id_pasien"); id != "" {
+		idPasien, err := pasien.ParseID(id)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+			return
+		}
+		filter["id_pasien"] = idPasien
+	}
+
+	// darurat sorts before waspada.
+	opts := options.Find().SetSort(bson.D{{Key: "tingkat", Value: 1}, {Key: "tanggal", Value: -1}})
+	cursor, err := db.Collection(Collection).Find(r.Context(), filter, opts)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing query")
+		return
+	}
+	rows := []Baris{}
+	if err := cursor.All(r.Context(), &rows); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error decoding results")
+		return
+	}
+
+	ids := []pasien.ID{}
+	for _, row := range rows {
+		ids = append(ids, row.IDPasien)
+	}
+	projection := options.Find().SetProjection(bson.M{"id_pasien": 1, "nama_pasien": 1, "no_hp": 1})
+	docs, err := pasien.FindAll(r.Context(), db.Collection("pasien"), bson.M{"id_pasien": bson.M{"$in": ids}}, projection)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error finding pasien")
+		return
+	}
+	byID := map[pasien.ID]bson.M{}
+	for _, doc := range docs {
+		if id, err := pasien.IDFrom(doc["id_pasien"]); err == nil {
+			byID[id] = doc
+		}
+	}
+	for i := range rows {
+		doc := byID[rows[i].IDPasien]
+		rows[i].NamaPasien, _ = doc["nama_pasien"].(string)
+		rows[i].NoHP, _ = doc["no_hp"].(string)
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": rows})
+}
+
+func aturan(w http.ResponseWriter, r *http.Request, db *mongo.Database, bidan sesi.Bidan) {
+	if r.Method != http.MethodGet && !bidan.IsSuperadmin() {
+		respondWithError(w, http.StatusForbidden, "hanya superadmin yang boleh mengubah aturan peringatan")
+		return
+	}
+	collection := db.Collection(AturanCollection)
+
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := Rules(r.Context(), db)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error executing query")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": rules})
+
+	case http.MethodPut:
+		var a Aturan
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		a.Kode = strings.TrimSpace(a.Kode)
+		if err := a.Validate(); err != nil {
+			respondWithError(w, status(err), err.Error())
+			return
+		}
+		_, err := collection.ReplaceOne(r.Context(), bson.M{"_id": a.Kode}, a, options.Replace().SetUpsert(true))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error updating data")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": a})
+
+	case http.MethodDelete:
+		kode := strings.TrimSpace(r.URL.Query().Get("kode"))
+		result, err := collection.DeleteOne(r.Context(), bson.M{"_id": kode})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error deleting data")
+			return
+		}
+		if result.DeletedCount == 0 {
+			respondWithError(w, http.StatusNotFound, "aturan tidak ditemukan atau belum pernah diubah")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Delete successful"})
+
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,115 @@
+package peringatan
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func fired(o Observasi) []string {
+	kode := []string{}
+	for _, a := range DefaultAturan {
+		if a.Temuan(o) != "" {
+			kode = append(kode, a.Kode)
+		}
+	}
+	return kode
+}
+
+func TestTemuan(t *testing.T) {
+	kehamilan, kb := pasien.LayananKehamilan, pasien.LayananKB
+	o := Observasi{Sumber: SumberSoap, IDLayanan: &kehamilan, Angka: map[string]float64{"sistolik": 145, "diastolik": 85, "djj": 100}}
+	if got, want := fired(o), []string{"hipertensi", "djj_abnormal"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fired %v, want %v", got, want)
+	}
+
+	o.IDLayanan = &kb
+	if got, want := fired(o), []string{"hipertensi"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fired %v on a KB visit, want %v", got, want)
+	}
+
+	lab := Observasi{Sumber: SumberLab, Teks: map[string]string{"protein_urine": "+3"}}
+	if got, want := fired(lab), []string{"proteinuria"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fired %v, want %v", got, want)
+	}
+}
+
+// A correction that clears every danger sign fires nothing, and the filter
+// resolving the alerts it left behind must still be a valid query.
+func TestStaleFilterWhenNothingFires(t *testing.T) {
+	kehamilan := pasien.LayananKehamilan
+	normal := Observasi{Sumber: SumberSoap, IDLayanan: &kehamilan, Angka: map[string]float64{"sistolik": 110, "diastolik": 70, "djj": 140}}
+	kode := fired(normal)
+	if len(kode) != 0 {
+		t.Fatalf("fired %v on a normal visit", kode)
+	}
+
+	for _, f := range [][]string{kode, nil} {
+		raw, err := bson.Marshal(staleFilter(primitive.NewObjectID(), f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nin, err := bson.Raw(raw).LookupErr("aturan", "$nin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nin.Type != bsontype.Array {
+			t.Errorf("$nin is %v, want an array", nin.Type)
+		}
+	}
+}
+
+func TestDefaultAturanValid(t *testing.T) {
+	for _, a := range DefaultAturan {
+		if err := a.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// An alert resolved because a correction cleared its danger sign opens
+// again when a later correction brings the sign back.
+func TestRefireReopensAutoResolved(t *testing.T) {
+	kehamilan := pasien.LayananKehamilan
+	o := Observasi{Sumber: SumberSoap, IDLayanan: &kehamilan, Angka: map[string]float64{"sistolik": 145, "diastolik": 85}}
+	a := DefaultAturan[1]
+	temuan := a.Temuan(o)
+	if temuan == "" {
+		t.Fatalf("rule %s does not fire", a.Kode)
+	}
+	now := time.Now()
+
+	update := fireUpdate(nil, o, a, temuan, now)
+	if update["$setOnInsert"].(bson.M)["status"] != StatusTerbuka {
+		t.Errorf("a new alert is not terbuka: %v", update)
+	}
+
+	closed := &Peringatan{Status: StatusSelesai, Diselesaikan: &Tindakan{Pada: now, Catatan: catatanTidakBerlaku}}
+	update = fireUpdate(closed, o, a, temuan, now)
+	if update["$set"].(bson.M)["status"] != StatusTerbuka {
+		t.Errorf("refiring an auto-resolved alert does not reopen it: %v", update)
+	}
+	if _, ok := update["$setOnInsert"].(bson.M)["status"]; ok {
+		t.Error("status is both set and set on insert")
+	}
+	if _, ok := update["$unset"].(bson.M)["diselesaikan"]; !ok {
+		t.Errorf("reopened alert keeps its resolution: %v", update)
+	}
+
+	resolved := &Peringatan{Status: StatusSelesai, Diselesaikan: &Tindakan{Pada: now, Catatan: "sudah dirujuk"}}
+	update = fireUpdate(resolved, o, a, temuan, now)
+	if _, ok := update["$set"].(bson.M)["status"]; ok {
+		t.Errorf("refiring reopens an alert a bidan resolved: %v", update)
+	}
+
+	open := &Peringatan{Status: StatusDiakui}
+	update = fireUpdate(open, o, a, temuan, now)
+	if _, ok := update["$unset"]; ok {
+		t.Errorf("refiring an open alert clears it: %v", update)
+	}
+}
@@ -0,0 +1,341 @@
+package persetujuan
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Kazengan/bidan-backend/lampiran"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one document per signed informed consent.
+const Collection = "persetujuan"
+
+// Jenis of consent.
+const (
+	JenisTindakan    = "tindakan"
+	JenisBerbagiData = "berbagi_data"
+)
+
+// TindakanWajib maps every word a procedure is written with in a SOAP
+// record, lowercased, to the procedure that needs a signed persetujuan
+// tindakan before it is done. The words are looked for anywhere in the
+// free text, so "Pemasangan IUD" is the IUD.
+var TindakanWajib = map[string]string{
+	"iud":       "IUD",
+	"akdr":      "IUD",
+	"implan":    "Implan",
+	"implant":   "Implan",
+	"akbk":      "Implan",
+	"mow":       "MOW",
+	"tubektomi": "MOW",
+	"mop":       "MOP",
+	"vasektomi": "MOP",
+}
+
+// Penandatangan is whoever signed: the patient, or the husband or guardian
+// signing for her.
+type Penandatangan struct {
+	Nama     string `bson:"nama" json:"nama"`
+	Hubungan string `bson:"hubungan" json:"hubungan"`
+}
+
+// Persetujuan is one signed informed consent.
+type Persetujuan struct {
+	ID            primitive.ObjectID  `bson:"_id" json:"id"`
+	IDPasien      pasien.ID           `bson:"id_pasien" json:"id_pasien"`
+	Jenis         string              `bson:"jenis" json:"jenis"`
+	Tindakan      string              `bson:"tindakan,omitempty" json:"tindakan,omitempty"`
+	Penandatangan Penandatangan       `bson:"penandatangan" json:"penandatangan"`
+	Saksi         string              `bson:"saksi" json:"saksi"`
+	Tanggal       time.Time           `bson:"tanggal" json:"tanggal"`
+	TandaTanganID *primitive.ObjectID `bson:"tanda_tangan_id,omitempty" json:"tanda_tangan_id,omitempty"`
+	Keterangan    string              `bson:"keterangan,omitempty" json:"keterangan,omitempty"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// request is the body of a POST. tandaTangan is an optional PNG or JPEG,
+// base64 or as a data: URL straight from a signature pad.
+type request struct {
+	IDPasien              pasien.ID   `json:"id_pasien"`
+	Jenis                 string      `json:"jenis"`
+	Tindakan              string      `json:"tindakan"`
+	NamaPenandatangan     string      `json:"namaPenandatangan"`
+	HubunganPenandatangan string      `json:"hubunganPenandatangan"`
+	NamaSaksi             string      `json:"namaSaksi"`
+	Tanggal               interface{} `json:"tanggal"`
+	TandaTangan           string      `json:"tandaTangan"`
+	Keterangan            string      `json:"keterangan"`
+}
+
+// ErrRequired is returned by Require when a procedure is recorded without
+// a prior consent.
+type ErrRequired struct {
+	Tindakan string
+}
+
+func (e *ErrRequired) Error() string {
+	return fmt.Sprintf("persetujuan tindakan %s belum ditandatangani, simpan persetujuan sebelum mencatat tindakan", e.Tindakan)
+}
+
+// procedures returns the procedures of TindakanWajib named in text, each
+// once, in the order they appear. Words are split at anything that is not
+// a letter or digit, so "iud+konseling" and "IUD/implan" are found too.
+func procedures(text string) []string {
+	found := []string{}
+	seen := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if tindakan, ok := TindakanWajib[word]; ok && !seen[tindakan] {
+			seen[tindakan] = true
+			found = append(found, tindakan)
+		}
+	}
+	return found
+}
+
+// Tindakan returns the procedures needing consent that a SOAP record
+// documents in its tindakan field, empty if there are none.
+func Tindakan(soap map[string]interface{}) []string {
+	value, _ := soap["tindakan"].(string)
+	return procedures(value)
+}
+
+// Require checks that a SOAP record for a procedure in TindakanWajib has a
+// persetujuan tindakan for every such procedure signed no later than the
+// visit day. It returns an *ErrRequired for the first one without.
+func Require(ctx context.Context, db *mongo.Database, idPasien interface{}, soap map[string]interface{}) error {
+	for _, tindakan := range Tindakan(soap) {
+		filter := bson.M{"id_pasien": idPasien, "jenis": JenisTindakan, "tindakan": tindakan}
+		if visit, ok := pasien.AsTime(soap["tglDatang"]); ok {
+			y, m, d := visit.In(pasien.Jakarta).Date()
+			filter["tanggal"] = bson.M{"$lt": time.Date(y, m, d+1, 0, 0, 0, 0, pasien.Jakarta)}
+		}
+		count, err := db.Collection(Collection).CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return &ErrRequired{Tindakan: tindakan}
+		}
+	}
+	return nil
+}
+
+// ForPasien lists the consents of a patient, newest first.
+func ForPasien(ctx context.Context, db *mongo.Database, idPasien interface{}) ([]Persetujuan, error) {
+	opts := options.Find().SetSort(bson.M{"tanggal": -1})
+	cursor, err := db.Collection(Collection).Find(ctx, bson.M{"id_pasien": idPasien}, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []Persetujuan{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteForPasien deletes the consents of a deleted or anonymised patient.
+// Signature images are attachments and go with lampiran.DeleteForPasien.
+func DeleteForPasien(ctx context.Context, db *mongo.Database, idPasien interface{}) error {
+	_, err := db.Collection(Collection).DeleteMany(ctx, bson.M{"id_pasien": idPasien})
+	return err
+}
+
+func decodeSignature(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "data:") {
+		comma := strings.Index(s, ",")
+		if comma < 0 || !strings.HasSuffix(s[:comma], ";base64") {
+			return nil, errors.New("tandaTangan harus data URL base64")
+		}
+		s = s[comma+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("tandaTangan bukan base64 yang valid")
+	}
+	return data, nil
+}
+
+// validate turns a request into a Persetujuan, or says what is missing.
+func (req request) validate() (Persetujuan, error) {
+	p := Persetujuan{
+		IDPasien: req.IDPasien,
+		Jenis:    req.Jenis,
+		Penandatangan: Penandatangan{
+			Nama:     strings.TrimSpace(req.NamaPenandatangan),
+			Hubungan: strings.TrimSpace(req.HubunganPenandatangan),
+		},
+		Saksi:      strings.TrimSpace(req.NamaSaksi),
+		Keterangan: req.Keterangan,
+	}
+	if p.IDPasien <= 0 {
+		return p, errors.New("id_pasien needed")
+	}
+	switch p.Jenis {
+	case JenisTindakan:
+		// A consent covers one procedure; a known one is stored by its
+		// code so Require finds it.
+		switch found := procedures(req.Tindakan); len(found) {
+		case 0:
+			p.Tindakan = strings.TrimSpace(req.Tindakan)
+		case 1:
+			p.Tindakan = found[0]
+		default:
+			return p, fmt.Errorf("satu persetujuan untuk satu tindakan, pisahkan %s", strings.Join(found, " dan "))
+		}
+		if p.Tindakan == "" {
+			return p, errors.New("tindakan needed untuk persetujuan tindakan")
+		}
+	case JenisBerbagiData:
+	default:
+		return p, fmt.Errorf("jenis harus %s atau %s", JenisTindakan, JenisBerbagiData)
+	}
+	if p.Penandatangan.Nama == "" {
+		return p, errors.New("namaPenandatangan needed")
+	}
+	if p.Penandatangan.Hubungan == "" {
+		p.Penandatangan.Hubungan = "pasien"
+	}
+	if p.Saksi == "" {
+		return p, errors.New("namaSaksi needed")
+	}
+
+	p.Tanggal = time.Now()
+	if req.Tanggal != nil && req.Tanggal != "" {
+		tanggal, err := pasien.ToDateTime(req.Tanggal)
+		if err != nil {
+			return p, fmt.Errorf("tanggal tidak valid: %v", err)
+		}
+		p.Tanggal = tanggal.(primitive.DateTime).Time()
+	}
+	return p, nil
+}
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Handler serves /api/persetujuan: POST records a signed consent, GET
+// ?id_pasien= lists them.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	switch r.Method {
+	case http.MethodGet:
+		idPasien, err := pasien.ParseID(r.URL.Query().Get("id_pasien"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+			return
+		}
+		list, err := ForPasien(r.Context(), db, idPasien)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error executing query")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": list})
+
+	case http.MethodPost:
+		create(w, r, db)
+
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func create(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	persetujuan, err := req.validate()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	count, err := db.Collection("pasien").CountDocuments(r.Context(), bson.M{"id_pasien": persetujuan.IDPasien})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "error finding pasien")
+		return
+	}
+	if count == 0 {
+		respondWithError(w, http.StatusNotFound, "id_pasien tidak ditemukan")
+		return
+	}
+
+	persetujuan.ID = primitive.NewObjectID()
+	persetujuan.CreatedAt = time.Now()
+
+	if req.TandaTangan != "" {
+		data, err := decodeSignature(req.TandaTangan)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+			respondWithError(w, http.StatusBadRequest, "tandaTangan harus gambar PNG atau JPEG")
+			return
+		}
+		keterangan := "Tanda tangan persetujuan " + persetujuan.Jenis
+		if persetujuan.Tindakan != "" {
+			keterangan += " " + persetujuan.Tindakan
+		}
+		signature, err := lampiran.Save(r.Context(), db, persetujuan.IDPasien, "tanda-tangan-"+persetujuan.ID.Hex(), keterangan, data)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, lampiran.ErrUnsupportedType) || errors.Is(err, lampiran.ErrBadImage) || errors.Is(err, lampiran.ErrEmpty) || errors.Is(err, lampiran.ErrTooLarge) {
+				status = http.StatusBadRequest
+			}
+			respondWithError(w, status, "tandaTangan: "+err.Error())
+			return
+		}
+		persetujuan.TandaTanganID = &signature.ID
+	}
+
+	if _, err := db.Collection(Collection).InsertOne(r.Context(), persetujuan); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error inserting data to database")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, map[string]interface{}{"message": "success", "data": persetujuan})
+}
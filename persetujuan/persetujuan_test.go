@@ -0,0 +1,26 @@
+package persetujuan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTindakanFindsProceduresInFreeText(t *testing.T) {
+	cases := map[string][]string{
+		"IUD":                      {"IUD"},
+		"Pemasangan IUD":           {"IUD"},
+		"iud + konseling":          {"IUD"},
+		"pasang AKDR (Cu T380A)":   {"IUD"},
+		"lepas implan, pasang IUD": {"Implan", "IUD"},
+		"IUD/iud":                  {"IUD"},
+		"suntik 3 bulan":           {},
+		"konseling liud":           {},
+		"":                         {},
+	}
+	for text, want := range cases {
+		got := Tindakan(map[string]interface{}{"tindakan": text})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Tindakan(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -79,6 +80,17 @@ func RegistBidan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.PhoneNumber != "" {
+		phoneNumber, err := pasien.NormalizePhone(user.PhoneNumber)
+		if err != nil {
+			jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
+		user.PhoneNumber = phoneNumber
+	}
+
 	db := client.Database("mydb")
 	collection := db.Collection("bidan")
 
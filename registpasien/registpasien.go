@@ -9,6 +9,7 @@ import (
 	"net/smtp"
 	"os"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -301,6 +302,17 @@ func RegistPasien(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.PhoneNumber != "" {
+		phoneNumber, err := pasien.NormalizePhone(user.PhoneNumber)
+		if err != nil {
+			jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(jsonData)
+			return
+		}
+		user.PhoneNumber = phoneNumber
+	}
+
 	//check in db user with email or username already exist or not
 	filter := bson.M{"$or": []bson.M{
 		{"email": user.Email},
@@ -9,19 +9,17 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// calculateReminderTime parses the reservation date and returns the timestamp
-func calculateReminderTime(hariReservasi string) (int64, error) {
-	layout := "2006-01-02"
-	t, err := time.Parse(layout, hariReservasi)
-	if err != nil {
-		return 0, err
-	}
-	return t.Unix(), nil
+// calculateReminderTime returns the timestamp of midnight (Jakarta) on the
+// reservation day
+func calculateReminderTime(hariReservasi time.Time) int64 {
+	return hariReservasi.Unix()
 }
 
 // Reservasi handles the reservation request
@@ -38,7 +36,7 @@ func Reservasi(w http.ResponseWriter, r *http.Request) {
 	nama := args["nama"]
 	phoneNumber := args["noHP"]
 	idLayanan := args["id_layanan"]
-	hariReservasi := args["hariReservasi"][:10]
+	hariReservasi := args["hariReservasi"]
 	waktu := args["waktuTersedia"]
 
 	if nama == "" {
@@ -49,6 +47,11 @@ func Reservasi(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"message": "noHP neeeded"}`, http.StatusUnauthorized)
 		return
 	}
+	phoneNumber, err = pasien.NormalizePhone(phoneNumber)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"message": %q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 	if idLayanan == "" {
 		http.Error(w, `{"message": "id_layanan needed"}`, http.StatusUnauthorized)
 		return
@@ -81,17 +84,18 @@ func Reservasi(w http.ResponseWriter, r *http.Request) {
 	reservasiCollection := db.Collection("reservasi_layanan")
 	reminderCollection := db.Collection("reminder")
 
-	remindTimestamp, err := calculateReminderTime(hariReservasi)
+	tanggalReservasi, err := pasien.ToDate(hariReservasi)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"message": "error %v"}`, err), http.StatusUnauthorized)
+		http.Error(w, `{"message": "invalid hariReservasi"}`, http.StatusBadRequest)
 		return
 	}
+	remindTimestamp := calculateReminderTime(tanggalReservasi.(primitive.DateTime).Time())
 
 	jsonData1 := bson.M{
 		"nama":          nama,
 		"noHP":          phoneNumber,
 		"id_layanan":    idLayananInt,
-		"hariReservasi": hariReservasi,
+		"hariReservasi": tanggalReservasi,
 		"waktuTersedia": waktu,
 	}
 
@@ -0,0 +1,393 @@
+package retensi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/eliminasi"
+	"github.com/Kazengan/bidan-backend/lab"
+	"github.com/Kazengan/bidan-backend/lampiran"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
+	"github.com/Kazengan/bidan-backend/persetujuan"
+	"github.com/Kazengan/bidan-backend/soap"
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const day = 24 * time.Hour
+
+// Result is what one policy did in a run.
+type Result struct {
+	Policy  string    `json:"policy"`
+	Cutoff  time.Time `json:"cutoff"`
+	Matched int       `json:"matched"`
+	Changed int       `json:"changed"`
+	Skipped []string  `json:"skipped,omitempty"`
+}
+
+// Policy keeps the documents of one kind for Retention and then purges or
+// anonymises them. Retention is read from Env when set, e.g.
+// RETENTION_REMINDER=90d; "off" disables the policy.
+type Policy struct {
+	Name      string
+	Env       string
+	Retention time.Duration
+	Apply     func(ctx context.Context, db *mongo.Database, cutoff time.Time, dryRun bool) (Result, error)
+}
+
+// Policies are applied in this order by every run.
+var Policies = []Policy{
+	{Name: "pending_users", Env: "RETENTION_PENDING_USERS", Retention: 2 * day, Apply: purgePendingUsers},
+	{Name: "reminder", Env: "RETENTION_REMINDER", Retention: 30 * day, Apply: purgeReminders},
+	{Name: "reservasi_layanan", Env: "RETENTION_RESERVASI", Retention: 365 * day, Apply: purgeReservasi},
+	// Rekam medis must be kept at least 25 years after the last visit
+	// (Permenkes 24/2022).
+	{Name: "pasien", Env: "RETENTION_PASIEN", Retention: 25 * 365 * day, Apply: anonymisePasien},
+	{Name: "soap_terhapus", Env: "RETENTION_SOAP_TERHAPUS", Retention: 30 * day, Apply: anonymiseOrphanSoap},
+}
+
+// ParseRetention reads a retention period: a number of days ("30d"), of
+// years ("25y") or a Go duration ("48h"). "off" returns 0.
+func ParseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "off" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = day
+	case strings.HasSuffix(s, "y"):
+		unit = 365 * day
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("masa retensi %q tidak valid, gunakan mis. 30d, 25y atau 48h", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("masa retensi %q tidak valid, gunakan mis. 30d, 25y atau 48h", s)
+	}
+	return d, nil
+}
+
+// retention returns the configured period of a policy, 0 when it is off.
+func (p Policy) retention() (time.Duration, error) {
+	value, ok := os.LookupEnv(p.Env)
+	if !ok {
+		return p.Retention, nil
+	}
+	d, err := ParseRetention(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", p.Env, err)
+	}
+	return d, nil
+}
+
+// Run applies every policy that is not off. With dryRun set the policies
+// only count what they would purge or anonymise.
+func Run(ctx context.Context, db *mongo.Database, dryRun bool) ([]Result, error) {
+	now := time.Now()
+	var results []Result
+	for _, policy := range Policies {
+		retention, err := policy.retention()
+		if err != nil {
+			return results, err
+		}
+		if retention == 0 {
+			continue
+		}
+		result, err := policy.Apply(ctx, db, now.Add(-retention), dryRun)
+		result.Policy = policy.Name
+		result.Cutoff = now.Add(-retention)
+		if err != nil {
+			return results, fmt.Errorf("%s: %v", policy.Name, err)
+		}
+		log.Printf("retensi %s: cutoff %s, matched %d, changed %d, dry-run %v", policy.Name, result.Cutoff.In(pasien.Jakarta).Format(time.RFC3339), result.Matched, result.Changed, dryRun)
+		for _, skipped := range result.Skipped {
+			log.Printf("  %s", skipped)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// purgePendingUsers deletes registrations that were never verified. They
+// carry no timestamp, so the creation time in the ObjectId is used.
+func purgePendingUsers(ctx context.Context, db *mongo.Database, cutoff time.Time, dryRun bool) (Result, error) {
+	filter := bson.M{"_id": bson.M{"$lt": primitive.NewObjectIDFromTimestamp(cutoff)}}
+	return deleteMany(ctx, db.Collection("pending_users"), filter, dryRun)
+}
+
+// purgeReminders deletes reminders whose time has passed.
+func purgeReminders(ctx context.Context, db *mongo.Database, cutoff time.Time, dryRun bool) (Result, error) {
+	filter := bson.M{"remind_timestamp": bson.M{"$lt": cutoff.Unix()}}
+	return deleteMany(ctx, db.Collection("reminder"), filter, dryRun)
+}
+
+// purgeReservasi deletes reservations for days that have passed.
+func purgeReservasi(ctx context.Context, db *mongo.Database, cutoff time.Time, dryRun bool) (Result, error) {
+	filter := bson.M{"hariReservasi": bson.M{"$lt": cutoff}}
+	return deleteMany(ctx, db.Collection("reservasi_layanan"), filter, dryRun)
+}
+
+func deleteMany(ctx context.Context, collection *mongo.Collection, filter bson.M, dryRun bool) (Result, error) {
+	var result Result
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return result, err
+	}
+	result.Matched = int(count)
+	if dryRun || count == 0 {
+		return result, nil
+	}
+	deleted, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return result, err
+	}
+	result.Changed = int(deleted.DeletedCount)
+	return result, nil
+}
+
+// AnonymisedField is set on pasien and SOAP documents stripped by retention.
+const AnonymisedField = "anonymised_at"
+
+// lastVisit returns the latest of the registration date and every SOAP
+// visit of a patient.
+func lastVisit(ctx context.Context, db *mongo.Database, soapCollections []string, doc bson.M) (time.Time, error) {
+	last, _ := pasien.AsTime(doc["tanggal_register"])
+	opts := options.FindOne().SetSort(bson.M{"tglDatang": -1}).SetProjection(bson.M{"tglDatang": 1})
+	for _, name := range soapCollections {
+		var soap bson.M
+		err := db.Collection(name).FindOne(ctx, bson.M{"id_pasien": doc["id_pasien"]}, opts).Decode(&soap)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return last, err
+		}
+		if visit, ok := pasien.AsTime(soap["tglDatang"]); ok && visit.After(last) {
+			last = visit
+		}
+	}
+	return last, nil
+}
+
+// stripSoap reduces SOAP documents to what chart, chartt, count and
+// countanually aggregate on: the visit date and the layanan.
+var stripSoap = mongo.Pipeline{{{Key: "$replaceWith", Value: bson.M{
+	"_id":           "$_id",
+	"id_pasien":     "$id_pasien",
+	"id_layanan":    "$id_layanan",
+	"tglDatang":     "$tglDatang",
+	AnonymisedField: "$$NOW",
+}}}}
+
+// anonymised keeps only the fields the statistics need: the registration
+// date and which layanan the patient was registered for.
+func anonymised(doc bson.M) bson.M {
+	result := bson.M{
+		"_id":               doc["_id"],
+		"id_pasien":         doc["id_pasien"],
+		"tanggal_register":  doc["tanggal_register"],
+		pasien.VersionField: pasien.Version(doc) + 1,
+		AnonymisedField:     time.Now(),
+	}
+	for _, field := range pasien.LayananField {
+		if _, ok := doc[field]; ok {
+			result[field] = bson.M{}
+		}
+	}
+	return result
+}
+
+// anonymisePasien strips every patient whose last visit is older than the
+// cutoff, together with their SOAP documents and archived deleted ones, in
+// one transaction per patient. Their consents and attachments are deleted.
+func anonymisePasien(ctx context.Context, db *mongo.Database, cutoff time.Time, dryRun bool) (Result, error) {
+	var result Result
+	soapCollections := soap.CollectionNames()
+	collection := db.Collection("pasien")
+	filter := bson.M{
+		AnonymisedField:    bson.M{"$exists": false},
+		"tanggal_register": bson.M{"$lt": cutoff},
+	}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return result, err
+	}
+	defer cursor.Close(ctx)
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return result, err
+	}
+	defer session.EndSession(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return result, err
+		}
+		last, err := lastVisit(ctx, db, soapCollections, doc)
+		if err != nil {
+			return result, err
+		}
+		if !last.Before(cutoff) {
+			continue
+		}
+		result.Matched++
+		if dryRun {
+			continue
+		}
+
+		_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			replaced, err := collection.ReplaceOne(sessCtx, pasien.VersionFilter(bson.M{"_id": doc["_id"]}, pasien.Version(doc)), anonymised(doc))
+			if err != nil {
+				return nil, err
+			}
+			if replaced.MatchedCount == 0 {
+				return nil, &pasien.VersionConflictError{}
+			}
+			for _, name := range append(soapCollections, soap.ArsipCollection) {
+				if _, err := db.Collection(name).UpdateMany(sessCtx, bson.M{"id_pasien": doc["id_pasien"]}, stripSoap); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		var conflict *pasien.VersionConflictError
+		if errors.As(err, &conflict) {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("id_pasien %v diubah selama retensi, dilewati", doc["id_pasien"]))
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if err := persetujuan.DeleteForPasien(ctx, db, doc["id_pasien"]); err != nil {
+			return result, err
+		}
+		if _, err := lampiran.DeleteForPasien(ctx, db, doc["id_pasien"]); err != nil {
+			return result, err
+		}
+		if err := lab.DeleteForPasien(ctx, db, doc["id_pasien"]); err != nil {
+			return result, err
+		}
+		if err := eliminasi.DeleteForPasien(ctx, db, doc["id_pasien"]); err != nil {
+			return result, err
+		}
+		if err := peringatan.DeleteForPasien(ctx, db, doc["id_pasien"]); err != nil {
+			return result, err
+		}
+		result.Changed++
+	}
+	return result, cursor.Err()
+}
+
+// anonymiseOrphanSoap strips SOAP documents, archived ones included, left
+// behind by deleted patients. They are kept, stripped, so past months still
+// count the visits.
+func anonymiseOrphanSoap(ctx context.Context, db *mongo.Database, cutoff time.Time, dryRun bool) (Result, error) {
+	var result Result
+	soapCollections := append(soap.CollectionNames(), soap.ArsipCollection)
+
+	ids, err := db.Collection("pasien").Distinct(ctx, "id_pasien", bson.M{})
+	if err != nil {
+		return result, err
+	}
+	filter := bson.M{
+		"id_pasien":     bson.M{"$nin": ids},
+		"tglDatang":     bson.M{"$lt": cutoff},
+		AnonymisedField: bson.M{"$exists": false},
+	}
+	for _, name := range soapCollections {
+		collection := db.Collection(name)
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return result, err
+		}
+		result.Matched += int(count)
+		if dryRun || count == 0 {
+			continue
+		}
+		updated, err := collection.UpdateMany(ctx, filter, stripSoap)
+		if err != nil {
+			return result, err
+		}
+		result.Changed += int(updated.ModifiedCount)
+	}
+	return result, nil
+}
+
+func connect() (*mongo.Client, error) {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+	return client, nil
+}
+
+// Timer is called by the Functions host on the schedule in
+// retensi/function.json and answers in the custom handler invocation format.
+func Timer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	client, err := connect()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"Logs": []string{err.Error()}})
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	results, err := Run(r.Context(), client.Database("mydb"), false)
+	logs := []string{}
+	for _, result := range results {
+		logs = append(logs, fmt.Sprintf("%s: matched %d, changed %d", result.Policy, result.Matched, result.Changed))
+	}
+	if err != nil {
+		logs = append(logs, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"Outputs": map[string]interface{}{}, "Logs": logs, "ReturnValue": results})
+}
+
+// Command is the entry point for "main retensi [-dry-run]".
+func Command(args []string) error {
+	fs := flag.NewFlagSet("retensi", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would be purged or anonymised without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: main retensi [-dry-run]")
+	}
+
+	client, err := connect()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	_, err = Run(context.Background(), client.Database("mydb"), *dryRun)
+	return err
+}
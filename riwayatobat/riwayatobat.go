@@ -0,0 +1,145 @@
+package riwayatobat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Pemberian is one prescription line with the visit it was written at.
+type Pemberian struct {
+	IDSoap     primitive.ObjectID `bson:"id_soap" json:"id_soap"`
+	IDLayanan  int                `bson:"id_layanan" json:"id_layanan"`
+	TglDatang  time.Time          `bson:"tglDatang" json:"tglDatang"`
+	Penulis    *sesi.Bidan        `bson:"penulis,omitempty" json:"penulis,omitempty"`
+	soap.Resep `bson:"resep"`
+}
+
+// Ringkasan totals what a patient was given of one drug.
+type Ringkasan struct {
+	KodeObat  string  `json:"kode_obat"`
+	NamaObat  string  `json:"nama_obat"`
+	Jumlah    float64 `json:"jumlah"`
+	Satuan    string  `json:"satuan"`
+	Pertama   string  `json:"pertama"`
+	Terakhir  string  `json:"terakhir"`
+	Pemberian int     `json:"pemberian"`
+}
+
+// lines is the pipeline listing the prescription lines of one layanan.
+func lines(layanan int, filter bson.M) []bson.M {
+	return []bson.M{
+		{"$match": filter},
+		{"$unwind": "$resep"},
+		{"$project": bson.M{
+			"_id":        0,
+			"id_soap":    "$_id",
+			"id_layanan": bson.M{"$literal": layanan},
+			"tglDatang":  1,
+			"penulis":    1,
+			"resep":      1,
+		}},
+	}
+}
+
+// RiwayatObat returns every drug prescribed to ?id_pasien= in the SOAP
+// records of all layanan, newest first, and a summary per drug with the
+// total quantity. ?kode_obat= limits it to one drug.
+func RiwayatObat(w http.ResponseWriter, r *http.Request) {
+	idPasien, err := pasien.ParseID(r.URL.Query().Get("id_pasien"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+		return
+	}
+	filter := bson.M{"id_pasien": idPasien, "resep": bson.M{"$exists": true, "$ne": bson.A{}}}
+	if kode := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("kode_obat"))); kode != "" {
+		filter["resep.kode_obat"] = kode
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	layanan := make([]int, 0, len(soap.Collections))
+	for l := range soap.Collections {
+		layanan = append(layanan, l)
+	}
+	sort.Ints(layanan)
+
+	pipeline := lines(layanan[0], filter)
+	for _, l := range layanan[1:] {
+		pipeline = append(pipeline, bson.M{"$unionWith": bson.M{"coll": soap.Collections[l], "pipeline": lines(l, filter)}})
+	}
+	if kode, ok := filter["resep.kode_obat"]; ok {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"resep.kode_obat": kode}})
+	}
+	pipeline = append(pipeline, bson.M{"$sort": bson.D{{Key: "tglDatang", Value: -1}}})
+
+	cursor, err := db.Collection(soap.Collections[layanan[0]]).Aggregate(r.Context(), pipeline)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing aggregation")
+		return
+	}
+	riwayat := []Pemberian{}
+	if err := cursor.All(r.Context(), &riwayat); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error decoding results")
+		return
+	}
+
+	// riwayat is newest first, so the first line seen of a drug is the
+	// latest one.
+	ringkasan := []*Ringkasan{}
+	byKode := map[string]*Ringkasan{}
+	for _, p := range riwayat {
+		s, ok := byKode[p.KodeObat]
+		if !ok {
+			s = &Ringkasan{KodeObat: p.KodeObat, NamaObat: p.NamaObat, Satuan: p.Satuan, Terakhir: pasien.FormatTanggal(p.TglDatang)}
+			byKode[p.KodeObat] = s
+			ringkasan = append(ringkasan, s)
+		}
+		s.Jumlah += p.Jumlah
+		s.Pemberian++
+		s.Pertama = pasien.FormatTanggal(p.TglDatang)
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": riwayat, "ringkasan": ringkasan})
+}
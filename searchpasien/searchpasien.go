@@ -0,0 +1,335 @@
+package searchpasien
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultLimit = 20
+
+// matchFields is the order in which a candidate's fields are checked when
+// deciding which one to highlight. Identifiers come first because a hit on
+// a phone or card number is more specific than a hit on a name.
+var matchFields = []string{"nik", "no_jkn", "no_register", "no_hp", "no_seri_kartu", "nomor_bayi", "nama", "desa"}
+
+// prefixWeight ranks an identifier prefix hit above any text score.
+var prefixWeight = map[string]float64{
+	"nik":           50,
+	"no_jkn":        50,
+	"no_register":   50,
+	"no_hp":         40,
+	"no_seri_kartu": 40,
+	"nomor_bayi":    40,
+	"nama":          20,
+	"desa":          5,
+}
+
+type result struct {
+	IdPasien     interface{} `json:"id_pasien"`
+	NamaPasien   interface{} `json:"nama_pasien"`
+	IdLayanan    []int       `json:"id_layanan"`
+	Score        float64     `json:"score"`
+	MatchedField string      `json:"matched_field"`
+	MatchedValue string      `json:"matched_value"`
+	Highlight    string      `json:"highlight"`
+}
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func layananFilter(idLayanan int) (bson.M, error) {
+	switch idLayanan {
+	case 0:
+		return bson.M{"data_kb": bson.M{"$exists": true}}, nil
+	case 1:
+		return bson.M{"data_kehamilan": bson.M{"$exists": true}}, nil
+	case 2:
+		return bson.M{"data_imunisasi": bson.M{"$exists": true}}, nil
+	}
+	return nil, fmt.Errorf("invalid id_layanan")
+}
+
+func layananOf(doc bson.M) []int {
+	layanan := []int{}
+	for i, field := range []string{"data_kb", "data_kehamilan", "data_imunisasi"} {
+		if _, ok := doc[field]; ok {
+			layanan = append(layanan, i)
+		}
+	}
+	return layanan
+}
+
+// buildPrefixQuery matches the keyword as a prefix on every normalised
+// search field, see pasien.PrefixFilter.
+func buildPrefixQuery(keyword string) (bson.M, error) {
+	var clauses []bson.M
+	for key := range pasien.SearchableFields {
+		clause, err := pasien.PrefixFilter(key, keyword)
+		if err != nil {
+			return nil, err
+		}
+		if clause != nil {
+			clauses = append(clauses, clause)
+		}
+	}
+	if len(clauses) == 0 {
+		return nil, nil
+	}
+	return bson.M{"$or": clauses}, nil
+}
+
+func withLayanan(query, layanan bson.M) bson.M {
+	if layanan == nil {
+		return query
+	}
+	return bson.M{"$and": []bson.M{layanan, query}}
+}
+
+// highlight finds the keyword in a candidate and wraps the matched part of
+// the stored value in <mark>. Candidates written before the "search"
+// sub-document existed are normalised on the fly.
+func highlight(doc bson.M, keyword string) (string, string, string, float64) {
+	search := pasien.SearchFields(doc)
+	for _, key := range matchFields {
+		stored, ok := search[key].(string)
+		if !ok {
+			continue
+		}
+		normalized := pasien.NormalizeSearchValue(key, keyword)
+		if normalized == "" || !startsWord(stored, normalized) {
+			continue
+		}
+
+		raw := rawValue(doc, key)
+		weight := prefixWeight[key]
+		if stored == normalized {
+			weight *= 2
+		}
+		if key == "no_hp" && raw == stored {
+			// Phone numbers are stored in E.164, which the keyword may
+			// not be typed in.
+			return key, raw, "<mark>" + raw[:len(normalized)] + "</mark>" + raw[len(normalized):], weight
+		}
+		return key, raw, mark(raw, keyword), weight
+	}
+
+	// Text-only hits: report the first field containing one of the words.
+	words := strings.Fields(strings.ToLower(keyword))
+	for _, key := range []string{"nama_pasien", "nama_ibu", "nama_ayah", "nama_pasangan", "desa", "data_kehamilan.desa", "alamat"} {
+		value, ok := pasien.Lookup(doc, key)
+		if !ok {
+			continue
+		}
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		for _, word := range words {
+			if strings.Contains(strings.ToLower(str), word) {
+				return key, str, mark(str, word), 0
+			}
+		}
+	}
+	return "", "", "", 0
+}
+
+// startsWord reports whether prefix starts stored or one of its words, the
+// prefixes a blind prefixed search key can be found by.
+func startsWord(stored, prefix string) bool {
+	return strings.HasPrefix(stored, prefix) || strings.Contains(stored, " "+prefix)
+}
+
+func rawValue(doc bson.M, key string) string {
+	for _, path := range pasien.SearchableFields[key] {
+		if value, ok := pasien.Lookup(doc, path); ok {
+			if str, ok := value.(string); ok {
+				return str
+			}
+		}
+	}
+	return ""
+}
+
+// mark wraps the first case-insensitive occurrence of keyword in value in
+// <mark>. It compares rune by rune on the original string, since lowercasing
+// can change the byte length of non-ASCII letters.
+func mark(value, keyword string) string {
+	runes := []rune(value)
+	needle := []rune(strings.TrimSpace(keyword))
+	if len(needle) == 0 {
+		return value
+	}
+	for i := 0; i+len(needle) <= len(runes); i++ {
+		if equalFold(runes[i:i+len(needle)], needle) {
+			end := i + len(needle)
+			return string(runes[:i]) + "<mark>" + string(runes[i:end]) + "</mark>" + string(runes[end:])
+		}
+	}
+	return value
+}
+
+func equalFold(a, b []rune) bool {
+	for i := range a {
+		if unicode.ToLower(a[i]) != unicode.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func SearchPasien(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	keyword := strings.TrimSpace(r.URL.Query().Get("keyword"))
+	if keyword == "" {
+		respondWithError(w, http.StatusBadRequest, "keyword needed")
+		return
+	}
+
+	var layanan bson.M
+	if idLayananStr := r.URL.Query().Get("id_layanan"); idLayananStr != "" {
+		idLayanan, err := strconv.Atoi(idLayananStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_layanan")
+			return
+		}
+		layanan, err = layananFilter(idLayanan)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	limit := int64(defaultLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || parsed <= 0 || parsed > 100 {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = parsed
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database("mydb")
+	collection := db.Collection("pasien")
+
+	candidates := map[string]bson.M{}
+	scores := map[string]float64{}
+	collect := func(cursor *mongo.Cursor) error {
+		defer cursor.Close(context.Background())
+		for cursor.Next(context.Background()) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				return err
+			}
+			if err := pasien.Decrypt(doc); err != nil {
+				return err
+			}
+			key := fmt.Sprint(doc["id_pasien"])
+			if score, ok := doc["score"].(float64); ok {
+				scores[key] += score
+			}
+			candidates[key] = doc
+		}
+		return cursor.Err()
+	}
+
+	prefixQuery, err := buildPrefixQuery(keyword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if prefixQuery != nil {
+		// Sorted so that which patients make the limit does not depend on
+		// the order MongoDB happens to return them in. Encrypted names
+		// cannot be sorted on; those are sorted after decrypting.
+		sortBy := bson.D{{Key: "search.nama", Value: 1}, {Key: "id_pasien", Value: 1}}
+		if pasien.IsEncrypted("nama_pasien") {
+			sortBy = bson.D{{Key: "id_pasien", Value: 1}}
+		}
+		prefixOptions := options.Find().SetSort(sortBy).SetLimit(limit)
+		cursor, err := collection.Find(context.Background(), withLayanan(prefixQuery, layanan), prefixOptions)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error executing query")
+			return
+		}
+		if err := collect(cursor); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error decoding results")
+			return
+		}
+	}
+
+	textQuery := bson.M{"$text": bson.M{"$search": keyword}}
+	textOptions := options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetSort(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetLimit(limit)
+	cursor, err := collection.Find(context.Background(), withLayanan(textQuery, layanan), textOptions)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing query")
+		return
+	}
+	if err := collect(cursor); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error decoding results")
+		return
+	}
+
+	results := []result{}
+	for key, doc := range candidates {
+		field, value, marked, weight := highlight(doc, keyword)
+		results = append(results, result{
+			IdPasien:     doc["id_pasien"],
+			NamaPasien:   doc["nama_pasien"],
+			IdLayanan:    layananOf(doc),
+			Score:        scores[key] + weight,
+			MatchedField: field,
+			MatchedValue: value,
+			Highlight:    marked,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return pasien.NormalizeText(fmt.Sprint(results[i].NamaPasien)) < pasien.NormalizeText(fmt.Sprint(results[j].NamaPasien))
+	})
+	if int64(len(results)) > limit {
+		results = results[:limit]
+	}
+
+	jsonData, _ := json.Marshal(map[string]interface{}{"message": "Success", "data": results})
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
@@ -0,0 +1,40 @@
+package searchpasien
+
+import "testing"
+
+func TestMark(t *testing.T) {
+	tests := []struct {
+		value, keyword, want string
+	}{
+		{"Siti Aminah", "ami", "Siti <mark>Ami</mark>nah"},
+		{"Siti Aminah", "  SITI ", "<mark>Siti</mark> Aminah"},
+		{"Siti Aminah", "budi", "Siti Aminah"},
+		// İ lowercases to two bytes more than it has; the highlight must
+		// still land on the right letters.
+		{"İra Ayu", "ayu", "İra <mark>Ayu</mark>"},
+		{"Ṡiti", "ṡi", "<mark>Ṡi</mark>ti"},
+		{"Ani", "anita", "Ani"},
+		{"Ani", "", "Ani"},
+	}
+	for _, tt := range tests {
+		if got := mark(tt.value, tt.keyword); got != tt.want {
+			t.Errorf("mark(%q, %q) = %q, want %q", tt.value, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestStartsWord(t *testing.T) {
+	for _, c := range []struct {
+		stored, prefix string
+		want           bool
+	}{
+		{"siti aminah", "siti", true},
+		{"siti aminah", "ami", true},
+		{"siti aminah", "siti ami", true},
+		{"siti aminah", "minah", false},
+	} {
+		if got := startsWord(c.stored, c.prefix); got != c.want {
+			t.Errorf("startsWord(%q, %q) = %v, want %v", c.stored, c.prefix, got, c.want)
+		}
+	}
+}
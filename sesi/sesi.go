@@ -0,0 +1,121 @@
+package sesi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TTL is how long a token from bidanlogin stays valid: one working day.
+const TTL = 12 * time.Hour
+
+// ErrUnauthenticated is returned when a request has no valid token.
+var ErrUnauthenticated = errors.New("login sebagai bidan diperlukan, kirim token dari bidanlogin di header Authorization: Bearer <token>")
+
+// ErrNoSecret is returned when SESSION_SECRET is not set, so no token can
+// be issued or checked.
+var ErrNoSecret = errors.New("SESSION_SECRET belum diatur, isi dengan string acak yang panjang agar bidan bisa login")
+
+// RoleSuperadmin is the role of the bidan accounts that manage the
+// practice, see registbidan.
+const RoleSuperadmin = "superadmin"
+
+// Bidan is the logged-in bidan a token was issued to. It is what SOAP
+// records are stamped with.
+type Bidan struct {
+	ID       primitive.ObjectID `bson:"id" json:"id"`
+	Username string             `bson:"username" json:"username"`
+	Nama     string             `bson:"nama" json:"nama"`
+	Role     string             `bson:"role,omitempty" json:"role,omitempty"`
+}
+
+// IsSuperadmin reports whether b may manage what the whole practice shares.
+func (b Bidan) IsSuperadmin() bool {
+	return b.Role == RoleSuperadmin
+}
+
+type claims struct {
+	Bidan
+	Exp int64 `json:"exp"`
+}
+
+func secret() ([]byte, error) {
+	s := os.Getenv("SESSION_SECRET")
+	if s == "" {
+		return nil, ErrNoSecret
+	}
+	return []byte(s), nil
+}
+
+// CheckSecret returns ErrNoSecret when SESSION_SECRET is not set. The
+// server checks it at startup, so a missing secret stops it there instead
+// of failing every login.
+func CheckSecret() error {
+	_, err := secret()
+	return err
+}
+
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a token for b, signed with SESSION_SECRET and valid for TTL.
+func Issue(b Bidan) (string, error) {
+	key, err := secret()
+	if err != nil {
+		return "", err
+	}
+	return issue(key, b, time.Now().Add(TTL))
+}
+
+func issue(key []byte, b Bidan, exp time.Time) (string, error) {
+	raw, err := json.Marshal(claims{Bidan: b, Exp: exp.Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + sign(key, payload), nil
+}
+
+// Parse checks a token and returns the bidan it was issued to.
+func Parse(token string) (Bidan, error) {
+	key, err := secret()
+	if err != nil {
+		return Bidan{}, err
+	}
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(key, payload))) {
+		return Bidan{}, ErrUnauthenticated
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Bidan{}, ErrUnauthenticated
+	}
+	var c claims
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID.IsZero() {
+		return Bidan{}, ErrUnauthenticated
+	}
+	if time.Now().Unix() > c.Exp {
+		return Bidan{}, ErrUnauthenticated
+	}
+	return c.Bidan, nil
+}
+
+// FromRequest returns the bidan of the bearer token in Authorization.
+func FromRequest(r *http.Request) (Bidan, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.TrimSpace(token) == "" {
+		return Bidan{}, ErrUnauthenticated
+	}
+	return Parse(strings.TrimSpace(token))
+}
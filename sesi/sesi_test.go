@@ -0,0 +1,77 @@
+package sesi
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var bidan = Bidan{ID: primitive.NewObjectID(), Username: "siti", Nama: "Siti Aminah"}
+
+func TestParseIssued(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "rahasia")
+	token, err := Issue(bidan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(token)
+	if err != nil || got != bidan {
+		t.Errorf("Parse = %+v, %v, want %+v", got, err, bidan)
+	}
+}
+
+func TestParseExpired(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "rahasia")
+	token, err := issue([]byte("rahasia"), bidan, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(token); err != ErrUnauthenticated {
+		t.Errorf("Parse of an expired token = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestParseTampered(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "rahasia")
+	token, err := Issue(bidan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	raw, _ := base64.RawURLEncoding.DecodeString(payload)
+	raw = []byte(strings.Replace(string(raw), `"nama":"Siti Aminah"`, `"nama":"Siti Aminah","role":"superadmin"`, 1))
+	tampered := base64.RawURLEncoding.EncodeToString(raw) + "." + signature
+
+	if _, err := Parse(tampered); err != ErrUnauthenticated {
+		t.Errorf("Parse of a changed payload = %v, want ErrUnauthenticated", err)
+	}
+	if _, err := Parse(payload + "." + signature[1:]); err != ErrUnauthenticated {
+		t.Errorf("Parse of a changed signature = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestParseWrongSecret(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "rahasia")
+	token, err := Issue(bidan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSION_SECRET", "rahasia lain")
+	if _, err := Parse(token); err != ErrUnauthenticated {
+		t.Errorf("Parse under another secret = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestCheckSecret(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "")
+	if err := CheckSecret(); err != ErrNoSecret {
+		t.Errorf("CheckSecret without SESSION_SECRET = %v, want ErrNoSecret", err)
+	}
+	t.Setenv("SESSION_SECRET", "rahasia")
+	if err := CheckSecret(); err != nil {
+		t.Errorf("CheckSecret = %v", err)
+	}
+}
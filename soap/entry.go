@@ -0,0 +1,248 @@
+package soap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
+	"github.com/Kazengan/bidan-backend/persetujuan"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ArsipCollection keeps deleted SOAP records together with when and why
+// they were deleted.
+const ArsipCollection = "arsip_soap"
+
+// ErrNotFound is returned when no SOAP collection has the record.
+var ErrNotFound = errors.New("id_soap tidak ditemukan")
+
+// ErrSigned is returned when a signed record is to be changed or deleted.
+var ErrSigned = errors.New("catatan SOAP sudah ditandatangani dan tidak bisa diubah, tambahkan adendum")
+
+// ErrNotAuthor is returned when a bidan signs a record someone else wrote.
+var ErrNotAuthor = errors.New("catatan SOAP hanya bisa ditandatangani oleh penulisnya")
+
+// ConflictError is returned when the record changed after the client read
+// it. Current is the version now stored.
+type ConflictError struct {
+	Current int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("catatan SOAP sudah diubah oleh pengguna lain (versi sekarang %d), muat ulang sebelum menyimpan", e.Current)
+}
+
+// Find looks a SOAP record up by id in every layanan.
+func Find(ctx context.Context, db *mongo.Database, id primitive.ObjectID) (Record, error) {
+	for layanan, name := range Collections {
+		var record Record
+		err := db.Collection(name).FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return record, err
+		}
+		record.IDLayanan = layanan
+		return record, nil
+	}
+	return Record{}, ErrNotFound
+}
+
+func checkVersion(record Record, version int64) error {
+	if record.Ditandatangani != nil {
+		return ErrSigned
+	}
+	if record.Version != version {
+		return &ConflictError{Current: record.Version}
+	}
+	return nil
+}
+
+func alasanOf(alasan string) (string, error) {
+	alasan = strings.TrimSpace(alasan)
+	if alasan == "" {
+		return "", InvalidError{"alasan wajib diisi"}
+	}
+	return alasan, nil
+}
+
+// Update corrects the content of an unsigned SOAP record that is still at
+// version. The content it replaces is kept in the record as an Amandemen
+// with the reason and the bidan, so nothing is silently overwritten. The
+// patient cannot change.
+func Update(ctx context.Context, db *mongo.Database, id primitive.ObjectID, version int64, data map[string]interface{}, alasan string, oleh sesi.Bidan) (Record, error) {
+	alasan, err := alasanOf(alasan)
+	if err != nil {
+		return Record{}, err
+	}
+	current, err := Find(ctx, db, id)
+	if err != nil {
+		return current, err
+	}
+	if err := checkVersion(current, version); err != nil {
+		return current, err
+	}
+
+	if _, ok := data["id_pasien"]; !ok {
+		data["id_pasien"] = current.IDPasien
+	}
+	record, err := Parse(current.IDLayanan, data)
+	if err != nil {
+		return current, err
+	}
+	if record.IDPasien != current.IDPasien {
+		return current, InvalidError{"id_pasien catatan SOAP tidak bisa diubah"}
+	}
+	if current.IDLayanan == pasien.LayananKB {
+		soap := map[string]interface{}{"tindakan": record.Tindakan, "tglDatang": record.TglDatang}
+		if err := persetujuan.Require(ctx, db, current.IDPasien, soap); err != nil {
+			return current, err
+		}
+	}
+
+	updated := current
+	updated.Isi = record.Isi
+	updated.Version = version + 1
+	updated.Amandemen = append(append([]Amandemen{}, current.Amandemen...), Amandemen{
+		Versi:      version,
+		Tanggal:    time.Now(),
+		Alasan:     alasan,
+		Oleh:       &oleh,
+		Sebelumnya: current.Isi,
+	})
+
+	collection := db.Collection(Collections[current.IDLayanan])
+	result, err := collection.ReplaceOne(ctx, pasien.VersionFilter(bson.M{"_id": id}, version), updated)
+	if err != nil {
+		return current, err
+	}
+	if result.MatchedCount == 0 {
+		return current, lost(ctx, db, id)
+	}
+	peringatan.Raise(ctx, db, updated.observasi())
+	return updated, nil
+}
+
+// lost explains why a versioned write matched nothing.
+func lost(ctx context.Context, db *mongo.Database, id primitive.ObjectID) error {
+	now, err := Find(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	return &ConflictError{Current: now.Version}
+}
+
+// Delete moves an unsigned SOAP record that is still at version to
+// ArsipCollection, with who deleted it and why.
+func Delete(ctx context.Context, db *mongo.Database, id primitive.ObjectID, version int64, alasan string, oleh sesi.Bidan) error {
+	alasan, err := alasanOf(alasan)
+	if err != nil {
+		return err
+	}
+	current, err := Find(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(current, version); err != nil {
+		return err
+	}
+
+	raw, err := bson.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var arsip bson.M
+	if err := bson.Unmarshal(raw, &arsip); err != nil {
+		return err
+	}
+	arsip["koleksi"] = Collections[current.IDLayanan]
+	arsip["dihapus_pada"] = time.Now()
+	arsip["alasan_hapus"] = alasan
+	arsip["dihapus_oleh"] = oleh
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		collection := db.Collection(Collections[current.IDLayanan])
+		result, err := collection.DeleteOne(sessCtx, pasien.VersionFilter(bson.M{"_id": id}, version))
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount == 0 {
+			return nil, lost(sessCtx, db, id)
+		}
+		_, err = db.Collection(ArsipCollection).InsertOne(sessCtx, arsip)
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+	if err := peringatan.Close(ctx, db, id, oleh); err != nil {
+		log.Printf("peringatan: soap %s: %v", id.Hex(), err)
+	}
+	return nil
+}
+
+// Sign locks a SOAP record that is still at version. Only its author may
+// sign it; records from before authorship was recorded can be signed by
+// any bidan. A signed record only takes addenda.
+func Sign(ctx context.Context, db *mongo.Database, id primitive.ObjectID, version int64, oleh sesi.Bidan) (Record, error) {
+	current, err := Find(ctx, db, id)
+	if err != nil {
+		return current, err
+	}
+	if err := checkVersion(current, version); err != nil {
+		return current, err
+	}
+	if current.Penulis != nil && current.Penulis.ID != oleh.ID {
+		return current, ErrNotAuthor
+	}
+
+	tanda := Tanda{Oleh: oleh, Pada: time.Now()}
+	update := bson.M{"$set": bson.M{"ditandatangani": tanda}, "$inc": bson.M{"version": 1}}
+	filter := pasien.VersionFilter(bson.M{"_id": id, "ditandatangani": bson.M{"$exists": false}}, version)
+	result, err := db.Collection(Collections[current.IDLayanan]).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return current, err
+	}
+	if result.MatchedCount == 0 {
+		return current, lost(ctx, db, id)
+	}
+	return Find(ctx, db, id)
+}
+
+// AddAdendum appends a note by oleh to a signed SOAP record. Addenda only
+// ever add to a record, so no version is needed to write one.
+func AddAdendum(ctx context.Context, db *mongo.Database, id primitive.ObjectID, isi string, oleh sesi.Bidan) (Record, error) {
+	isi = strings.TrimSpace(isi)
+	if isi == "" {
+		return Record{}, InvalidError{"isi adendum wajib diisi"}
+	}
+	current, err := Find(ctx, db, id)
+	if err != nil {
+		return current, err
+	}
+	if current.Ditandatangani == nil {
+		return current, InvalidError{"catatan SOAP belum ditandatangani, ubah catatannya langsung"}
+	}
+
+	adendum := Adendum{Isi: isi, Oleh: oleh, Pada: time.Now()}
+	update := bson.M{"$push": bson.M{"adendum": adendum}, "$inc": bson.M{"version": 1}}
+	if _, err := db.Collection(Collections[current.IDLayanan]).UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		return current, err
+	}
+	return Find(ctx, db, id)
+}
@@ -0,0 +1,673 @@
+package soap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/icd10"
+	"github.com/Kazengan/bidan-backend/obat"
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
+	"github.com/Kazengan/bidan-backend/persetujuan"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Collections maps a layanan to the collection its SOAP records are in.
+var Collections = map[int]string{
+	pasien.LayananKB:        "soap_kb",
+	pasien.LayananKehamilan: "soap_kehamilan",
+	pasien.LayananImunisasi: "soap_imunisasi",
+}
+
+// CollectionNames returns the collections of Collections, sorted by name.
+func CollectionNames() []string {
+	names := make([]string, 0, len(Collections))
+	for _, name := range Collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Vital is the structured part of the objective data of a visit. Every
+// measurement is optional; a nil field was not measured.
+type Vital struct {
+	Sistolik     *float64 `bson:"sistolik,omitempty" json:"sistolik,omitempty"`
+	Diastolik    *float64 `bson:"diastolik,omitempty" json:"diastolik,omitempty"`
+	BeratBadan   *float64 `bson:"beratBadan,omitempty" json:"beratBadan,omitempty"`
+	TinggiBadan  *float64 `bson:"tinggiBadan,omitempty" json:"tinggiBadan,omitempty"`
+	Suhu         *float64 `bson:"suhu,omitempty" json:"suhu,omitempty"`
+	Nadi         *float64 `bson:"nadi,omitempty" json:"nadi,omitempty"`
+	TinggiFundus *float64 `bson:"tinggiFundus,omitempty" json:"tinggiFundus,omitempty"`
+	DJJ          *float64 `bson:"djj,omitempty" json:"djj,omitempty"`
+	Hb           *float64 `bson:"hb,omitempty" json:"hb,omitempty"`
+}
+
+// Isi is the clinical content of a SOAP record, the part a correction
+// changes.
+type Isi struct {
+	TglDatang time.Time              `bson:"tglDatang" json:"tglDatang"`
+	S         string                 `bson:"s" json:"s"`
+	O         string                 `bson:"o" json:"o"`
+	A         string                 `bson:"a" json:"a"`
+	P         string                 `bson:"p" json:"p"`
+	Vital     Vital                  `bson:"vital" json:"vital"`
+	Diagnosis []Diagnosis            `bson:"diagnosis,omitempty" json:"diagnosis,omitempty"`
+	Resep     []Resep                `bson:"resep,omitempty" json:"resep,omitempty"`
+	Tindakan  string                 `bson:"tindakan,omitempty" json:"tindakan,omitempty"`
+	Lainnya   map[string]interface{} `bson:"lainnya,omitempty" json:"lainnya,omitempty"`
+}
+
+// Diagnosis is an ICD-10 coded diagnosis of the assessment, kept next to
+// the narrative in A. The title is stored with the code so reports do not
+// depend on the master table. The first diagnosis is the main one.
+type Diagnosis struct {
+	Kode string `bson:"kode" json:"kode"`
+	Nama string `bson:"nama" json:"nama"`
+}
+
+// Resep is one prescription line of the plan: a drug of the master list
+// given or prescribed at the visit. Dosis is the dosing as written, e.g.
+// "1 x 1 tablet"; Jumlah is counted in Satuan. LamaHari is 0 for a single
+// dose given at the visit.
+type Resep struct {
+	KodeObat   string  `bson:"kode_obat" json:"kode_obat"`
+	NamaObat   string  `bson:"nama_obat" json:"nama_obat"`
+	Sediaan    string  `bson:"sediaan" json:"sediaan"`
+	Dosis      string  `bson:"dosis" json:"dosis"`
+	Jumlah     float64 `bson:"jumlah" json:"jumlah"`
+	Satuan     string  `bson:"satuan" json:"satuan"`
+	Rute       string  `bson:"rute" json:"rute"`
+	LamaHari   int     `bson:"lama_hari,omitempty" json:"lama_hari,omitempty"`
+	Keterangan string  `bson:"keterangan,omitempty" json:"keterangan,omitempty"`
+}
+
+// Amandemen is one correction of a SOAP record: the content as it was
+// before, and who changed it why. Corrections made before authorship was
+// recorded have no Oleh.
+type Amandemen struct {
+	Versi      int64       `bson:"versi" json:"versi"`
+	Tanggal    time.Time   `bson:"tanggal" json:"tanggal"`
+	Alasan     string      `bson:"alasan" json:"alasan"`
+	Oleh       *sesi.Bidan `bson:"oleh,omitempty" json:"oleh,omitempty"`
+	Sebelumnya Isi         `bson:"sebelumnya" json:"sebelumnya"`
+}
+
+// Tanda is the signature that locks a SOAP record.
+type Tanda struct {
+	Oleh sesi.Bidan `bson:"oleh" json:"oleh"`
+	Pada time.Time  `bson:"pada" json:"pada"`
+}
+
+// Adendum is a note added to a signed SOAP record. The signed content
+// itself never changes; later findings and corrections are addenda.
+type Adendum struct {
+	Isi  string     `bson:"isi" json:"isi"`
+	Oleh sesi.Bidan `bson:"oleh" json:"oleh"`
+	Pada time.Time  `bson:"pada" json:"pada"`
+}
+
+// Record is one SOAP visit record. Every layanan stores the same shape;
+// form fields that are not part of it are kept under lainnya. Version
+// counts the writes like pasien.VersionField does for patients. Records
+// written before authorship was recorded have no Penulis or DibuatPada.
+type Record struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id_soap"`
+	IDPasien       pasien.ID          `bson:"id_pasien" json:"id_pasien"`
+	IDLayanan      int                `bson:"id_layanan" json:"id_layanan"`
+	Isi            `bson:",inline"`
+	Penulis        *sesi.Bidan `bson:"penulis,omitempty" json:"penulis,omitempty"`
+	DibuatPada     *time.Time  `bson:"dibuat_pada,omitempty" json:"dibuat_pada,omitempty"`
+	Ditandatangani *Tanda      `bson:"ditandatangani,omitempty" json:"ditandatangani,omitempty"`
+	Adendum        []Adendum   `bson:"adendum,omitempty" json:"adendum,omitempty"`
+	Version        int64       `bson:"version" json:"version"`
+	Amandemen      []Amandemen `bson:"amandemen,omitempty" json:"amandemen,omitempty"`
+}
+
+// VitalRange is the plausible range of one measurement. Values outside it
+// are typing mistakes (a weight in grams, a temperature in Fahrenheit) and
+// are rejected rather than stored.
+type VitalRange struct {
+	Key     string
+	Aliases []string
+	Min     float64
+	Max     float64
+	Unit    string
+	Whole   bool
+	Layanan []int
+}
+
+// VitalRanges lists every measurement with the form keys it is accepted
+// under. Tinggi fundus and DJJ only exist in a pregnancy visit.
+var VitalRanges = []VitalRange{
+	{Key: "sistolik", Min: 50, Max: 260, Unit: "mmHg", Whole: true},
+	{Key: "diastolik", Min: 30, Max: 160, Unit: "mmHg", Whole: true},
+	{Key: "beratBadan", Aliases: []string{"bb"}, Min: 0.3, Max: 250, Unit: "kg"},
+	{Key: "tinggiBadan", Aliases: []string{"tb", "panjangBadan", "pb"}, Min: 20, Max: 230, Unit: "cm"},
+	{Key: "suhu", Min: 30, Max: 45, Unit: "°C"},
+	{Key: "nadi", Min: 30, Max: 250, Unit: "x/menit", Whole: true},
+	{Key: "tinggiFundus", Aliases: []string{"tfu"}, Min: 5, Max: 50, Unit: "cm", Layanan: []int{pasien.LayananKehamilan}},
+	{Key: "djj", Min: 60, Max: 220, Unit: "x/menit", Whole: true, Layanan: []int{pasien.LayananKehamilan}},
+	{Key: "hb", Min: 3, Max: 25, Unit: "g/dL"},
+}
+
+func (v *Vital) field(key string) **float64 {
+	switch key {
+	case "sistolik":
+		return &v.Sistolik
+	case "diastolik":
+		return &v.Diastolik
+	case "beratBadan":
+		return &v.BeratBadan
+	case "tinggiBadan":
+		return &v.TinggiBadan
+	case "suhu":
+		return &v.Suhu
+	case "nadi":
+		return &v.Nadi
+	case "tinggiFundus":
+		return &v.TinggiFundus
+	case "djj":
+		return &v.DJJ
+	case "hb":
+		return &v.Hb
+	}
+	return nil
+}
+
+// InvalidError lists everything wrong with a submitted SOAP record.
+type InvalidError []string
+
+func (e InvalidError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// toNumber reads a measurement sent as a JSON number or as text, with a
+// decimal comma or a trailing unit ("60,5 kg").
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		s := strings.TrimSpace(strings.ReplaceAll(v, ",", "."))
+		if fields := strings.Fields(s); len(fields) == 2 {
+			s = fields[0]
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+	return 0, fmt.Errorf("bukan angka")
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	s, ok := value.(string)
+	return ok && strings.TrimSpace(s) == ""
+}
+
+// asMap returns value as a map whether it came from JSON or from BSON.
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case bson.M:
+		return v, true
+	case bson.D:
+		return v.Map(), true
+	}
+	return nil, false
+}
+
+// source is a submitted or stored record being read field by field. Every
+// key that is read is remembered so the rest can go to lainnya.
+type source struct {
+	layers []map[string]interface{}
+	used   []map[string]bool
+}
+
+func newSource(layers ...map[string]interface{}) *source {
+	s := &source{}
+	for _, layer := range layers {
+		if layer != nil {
+			s.layers = append(s.layers, layer)
+			s.used = append(s.used, map[string]bool{})
+		}
+	}
+	return s
+}
+
+// take returns the first non-empty value of any of keys, looking through
+// the layers in order.
+func (s *source) take(keys ...string) (interface{}, bool) {
+	var found interface{}
+	ok := false
+	for i, layer := range s.layers {
+		for _, key := range keys {
+			value, present := layer[key]
+			if !present {
+				continue
+			}
+			s.used[i][key] = true
+			if !ok && !isEmpty(value) {
+				found, ok = value, true
+			}
+		}
+	}
+	return found, ok
+}
+
+func (s *source) text(keys ...string) string {
+	value, ok := s.take(keys...)
+	if !ok {
+		return ""
+	}
+	if str, isString := value.(string); isString {
+		return strings.TrimSpace(str)
+	}
+	return fmt.Sprint(value)
+}
+
+func (s *source) rest() map[string]interface{} {
+	rest := map[string]interface{}{}
+	for i, layer := range s.layers {
+		for key, value := range layer {
+			if !s.used[i][key] && !isEmpty(value) {
+				rest[key] = value
+			}
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
+
+// parseVital reads the measurements from src. Values that are not numbers
+// or are out of range are reported and returned in rejected instead of in
+// the Vital.
+func parseVital(src *source, layanan int) (vital Vital, rejected map[string]interface{}, problems []string) {
+	rejected = map[string]interface{}{}
+
+	// Blood pressure is usually typed as one "120/80" value.
+	if td, ok := src.take("tekananDarah", "td"); ok {
+		parts := strings.Split(strings.TrimSuffix(strings.TrimSpace(fmt.Sprint(td)), "mmHg"), "/")
+		sistolik, errS := toNumber(strings.TrimSpace(parts[0]))
+		var diastolik float64
+		errD := errS
+		if len(parts) == 2 {
+			diastolik, errD = toNumber(strings.TrimSpace(parts[1]))
+		}
+		if len(parts) != 2 || errS != nil || errD != nil {
+			problems = append(problems, fmt.Sprintf("tekananDarah %v harus ditulis sistolik/diastolik, misalnya 120/80", td))
+			rejected["tekananDarah"] = td
+		} else {
+			vital.Sistolik, vital.Diastolik = &sistolik, &diastolik
+		}
+	}
+
+	for _, r := range VitalRanges {
+		value, ok := src.take(append([]string{r.Key}, r.Aliases...)...)
+		if !ok {
+			if current := *vital.field(r.Key); current != nil {
+				value = *current
+			} else {
+				continue
+			}
+		}
+		*vital.field(r.Key) = nil
+
+		number, err := toNumber(value)
+		switch {
+		case r.Layanan != nil && !containsInt(r.Layanan, layanan):
+			problems = append(problems, fmt.Sprintf("%s hanya dicatat pada kunjungan kehamilan", r.Key))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s %v bukan angka", r.Key, value))
+		case r.Whole && number != math.Trunc(number):
+			problems = append(problems, fmt.Sprintf("%s harus bilangan bulat", r.Key))
+		case number < r.Min || number > r.Max:
+			problems = append(problems, fmt.Sprintf("%s %v di luar rentang %v-%v %s", r.Key, number, r.Min, r.Max, r.Unit))
+		default:
+			*vital.field(r.Key) = &number
+			continue
+		}
+		rejected[r.Key] = value
+	}
+
+	if vital.Sistolik != nil && vital.Diastolik != nil && *vital.Sistolik <= *vital.Diastolik {
+		problems = append(problems, "sistolik harus lebih besar dari diastolik")
+	}
+	return vital, rejected, problems
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDiagnosis reads the coded diagnoses of a record, sent as a list of
+// codes, a list of {"kode": ...} or one comma separated string. Codes not
+// in the ICD-10 table are reported; a code given twice is kept once.
+// Diagnoses read back from the database keep the title they were stored
+// with.
+func parseDiagnosis(value interface{}) ([]Diagnosis, []string) {
+	var items []interface{}
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		for _, kode := range strings.Split(v, ",") {
+			items = append(items, kode)
+		}
+	case []interface{}:
+		items = v
+	case bson.A:
+		items = v
+	default:
+		return nil, []string{"diagnosis harus berupa daftar kode ICD-10"}
+	}
+
+	var diagnosis []Diagnosis
+	var problems []string
+	seen := map[string]bool{}
+	for _, item := range items {
+		if stored, isStored := item.(bson.M); isStored {
+			kode, _ := stored["kode"].(string)
+			nama, _ := stored["nama"].(string)
+			if kode != "" && !seen[kode] {
+				seen[kode] = true
+				diagnosis = append(diagnosis, Diagnosis{Kode: kode, Nama: nama})
+			}
+			continue
+		}
+		kode, ok := item.(string)
+		if m, isMap := asMap(item); isMap {
+			kode, ok = m["kode"].(string)
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("diagnosis %v bukan kode ICD-10", item))
+			continue
+		}
+		if strings.TrimSpace(kode) == "" {
+			continue
+		}
+		k, found := icd10.Lookup(kode)
+		if !found {
+			problems = append(problems, fmt.Sprintf("kode ICD-10 %s tidak dikenal", strings.TrimSpace(kode)))
+			continue
+		}
+		if !seen[k.Kode] {
+			seen[k.Kode] = true
+			diagnosis = append(diagnosis, Diagnosis{Kode: k.Kode, Nama: k.Nama})
+		}
+	}
+	return diagnosis, problems
+}
+
+// parseResep reads the prescription lines of a record, a list of
+// {"kode_obat", "dosis", "jumlah", "rute", "lama_hari", "keterangan"}. The
+// drug must be in the master list; the route defaults to its usual one.
+// Lines read back from the database are kept as they were stored.
+func parseResep(value interface{}) ([]Resep, []string) {
+	var items []interface{}
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		items = v
+	case bson.A:
+		items = v
+	default:
+		return nil, []string{"resep harus berupa daftar obat"}
+	}
+
+	var resep []Resep
+	var problems []string
+	for i, item := range items {
+		if stored, isStored := item.(bson.M); isStored {
+			var line Resep
+			if raw, err := bson.Marshal(stored); err == nil && bson.Unmarshal(raw, &line) == nil {
+				resep = append(resep, line)
+			}
+			continue
+		}
+		m, ok := asMap(item)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("resep baris %d harus berupa objek", i+1))
+			continue
+		}
+		line := newSource(m)
+		baris := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("resep baris %d: ", i+1)+fmt.Sprintf(format, args...))
+		}
+
+		kode := line.text("kode_obat", "kode", "obat")
+		o, found := obat.Lookup(kode)
+		if !found {
+			baris("obat %q tidak ada di daftar obat", kode)
+			continue
+		}
+		r := Resep{
+			KodeObat:   o.Kode,
+			NamaObat:   o.Nama,
+			Sediaan:    o.Sediaan,
+			Satuan:     o.Satuan,
+			Dosis:      line.text("dosis", "aturanPakai"),
+			Keterangan: line.text("keterangan"),
+			Rute:       o.Rute,
+		}
+		if r.Dosis == "" {
+			baris("dosis %s needed", o.Nama)
+		}
+		if jumlah, ok := line.take("jumlah"); !ok {
+			baris("jumlah %s needed", o.Nama)
+		} else if n, err := toNumber(jumlah); err != nil || n <= 0 {
+			baris("jumlah %v harus angka lebih dari 0", jumlah)
+		} else {
+			r.Jumlah = n
+		}
+		if rute := line.text("rute"); rute != "" {
+			if r.Rute, ok = obat.Rute[strings.ToLower(rute)]; !ok {
+				baris("rute %q tidak dikenal", rute)
+			}
+		}
+		if lama, ok := line.take("lama_hari", "lama"); ok {
+			if n, err := toNumber(lama); err != nil || n < 0 || n != math.Trunc(n) {
+				baris("lama_hari %v harus bilangan bulat", lama)
+			} else {
+				r.LamaHari = int(n)
+			}
+		}
+		resep = append(resep, r)
+	}
+	return resep, problems
+}
+
+// parse builds a Record from the data of a request or from a stored
+// document in the shape it was written before records were typed. It
+// accepts S/O/A/P at the root (KB, Imunisasi) or under soapAnc
+// (Kehamilan) and the measurements under vital, at the root or in soapAnc.
+// Problems are returned rather than failing so a migration can report all
+// of them.
+func parse(layanan int, data map[string]interface{}) (Record, []string) {
+	record := Record{IDLayanan: layanan}
+	var problems []string
+
+	anc, _ := asMap(data["soapAnc"])
+	vitalData, _ := asMap(data["vital"])
+	src := newSource(vitalData, data, anc)
+	src.take("_id", "id_layanan", "soapAnc", "vital", "lainnya", "version", "amandemen", "penulis", "dibuat_pada", "ditandatangani", "adendum")
+
+	idPasien, ok := src.take("id_pasien")
+	if id, err := pasien.IDFrom(idPasien); !ok || err != nil {
+		problems = append(problems, "id_pasien tidak valid")
+	} else {
+		record.IDPasien = id
+	}
+
+	tanggal, ok := src.take("tglDatang", "tanggal")
+	if !ok {
+		problems = append(problems, "tglDatang needed")
+	} else if t, ok := pasien.AsTime(tanggal); ok {
+		record.TglDatang = t
+	} else {
+		problems = append(problems, fmt.Sprintf("tglDatang %v tidak valid", tanggal))
+	}
+
+	record.S = src.text("s", "subjektif")
+	record.O = src.text("o", "objektif")
+	record.A = src.text("a", "analisa", "asesmen")
+	record.P = src.text("p", "penatalaksanaan", "planning")
+	record.Tindakan = src.text("tindakan")
+
+	diagnosis, _ := src.take("diagnosis")
+	var diagnosisProblems []string
+	record.Diagnosis, diagnosisProblems = parseDiagnosis(diagnosis)
+	problems = append(problems, diagnosisProblems...)
+
+	resep, _ := src.take("resep")
+	var resepProblems []string
+	record.Resep, resepProblems = parseResep(resep)
+	problems = append(problems, resepProblems...)
+
+	var rejected map[string]interface{}
+	var vitalProblems []string
+	record.Vital, rejected, vitalProblems = parseVital(src, layanan)
+	problems = append(problems, vitalProblems...)
+
+	lainnya := map[string]interface{}{}
+	if existing, ok := asMap(data["lainnya"]); ok {
+		for key, value := range existing {
+			lainnya[key] = value
+		}
+	}
+	for _, extra := range []map[string]interface{}{src.rest(), rejected} {
+		for key, value := range extra {
+			lainnya[key] = value
+		}
+	}
+	if len(lainnya) > 0 {
+		record.Lainnya = lainnya
+	}
+	return record, problems
+}
+
+// Convert reads a stored document written before records were typed. The
+// problems say what could not be read; rejected measurements are kept
+// under lainnya.
+func Convert(layanan int, doc bson.M) (Record, []string) {
+	record, problems := parse(layanan, doc)
+	if id, ok := doc["_id"].(primitive.ObjectID); ok {
+		record.ID = id
+	}
+	return record, problems
+}
+
+// Parse validates the data of a SOAP request for layanan.
+func Parse(layanan int, data map[string]interface{}) (Record, error) {
+	if _, ok := Collections[layanan]; !ok {
+		return Record{}, InvalidError{"id_layanan tidak valid"}
+	}
+	record, problems := parse(layanan, data)
+	if len(problems) > 0 {
+		return Record{}, InvalidError(problems)
+	}
+	return record, nil
+}
+
+// Create validates data as a SOAP record of layanan and inserts it with
+// penulis as its author. A KB procedure needs a signed persetujuan first,
+// see persetujuan.Require.
+func Create(ctx context.Context, db *mongo.Database, layanan int, data map[string]interface{}, penulis sesi.Bidan) (Record, error) {
+	record, err := Parse(layanan, data)
+	if err != nil {
+		return record, err
+	}
+
+	if layanan == pasien.LayananKB {
+		soap := map[string]interface{}{"tindakan": record.Tindakan, "tglDatang": record.TglDatang}
+		if err := persetujuan.Require(ctx, db, record.IDPasien, soap); err != nil {
+			return record, err
+		}
+	}
+
+	now := time.Now()
+	record.ID = primitive.NewObjectID()
+	record.Penulis = &penulis
+	record.DibuatPada = &now
+	record.Version = 1
+	if _, err := db.Collection(Collections[layanan]).InsertOne(ctx, record); err != nil {
+		return record, err
+	}
+	peringatan.Raise(ctx, db, record.observasi())
+	return record, nil
+}
+
+// observasi is what the record measured, for the danger-sign rules.
+func (r Record) observasi() peringatan.Observasi {
+	angka := map[string]float64{}
+	for _, vr := range VitalRanges {
+		if v := *r.Vital.field(vr.Key); v != nil {
+			angka[vr.Key] = *v
+		}
+	}
+	layanan := r.IDLayanan
+	return peringatan.Observasi{
+		IDPasien:  r.IDPasien,
+		IDLayanan: &layanan,
+		Sumber:    peringatan.SumberSoap,
+		IDSumber:  r.ID,
+		Tanggal:   r.TglDatang,
+		Angka:     angka,
+	}
+}
+
+// Status is the HTTP status for an error returned by Create, Update or
+// Delete.
+func Status(err error) int {
+	var invalid InvalidError
+	var required *persetujuan.ErrRequired
+	var conflict *ConflictError
+	switch {
+	case errors.Is(err, sesi.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrNotAuthor):
+		return http.StatusForbidden
+	case errors.Is(err, ErrSigned):
+		return http.StatusLocked
+	case errors.As(err, &invalid), errors.Is(err, pasien.ErrInvalidIfMatch):
+		return http.StatusBadRequest
+	case errors.As(err, &required):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &conflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, pasien.ErrIfMatchRequired):
+		return http.StatusPreconditionRequired
+	}
+	return http.StatusInternalServerError
+}
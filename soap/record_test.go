@@ -0,0 +1,169 @@
+package soap
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestToNumber(t *testing.T) {
+	for _, c := range []struct {
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{60.5, 60.5, true},
+		{int32(120), 120, true},
+		{int64(80), 80, true},
+		{json.Number("36.7"), 36.7, true},
+		{"60,5", 60.5, true},
+		{" 60,5 kg ", 60.5, true},
+		{"120", 120, true},
+		{"enam puluh", 0, false},
+		{"60 kg lebih", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+	} {
+		got, err := toNumber(c.value)
+		if (err == nil) != c.ok || c.ok && got != c.want {
+			t.Errorf("toNumber(%#v) = %v, %v, want %v, ok %v", c.value, got, err, c.want, c.ok)
+		}
+	}
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestParseVital(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		layanan  int
+		data     map[string]interface{}
+		want     Vital
+		rejected []string
+		problems int
+	}{
+		{
+			name:    "blood pressure and aliases",
+			layanan: pasien.LayananKehamilan,
+			data:    map[string]interface{}{"tekananDarah": "120/80 mmHg", "bb": "60,5 kg", "tfu": 24, "djj": "140"},
+			want:    Vital{Sistolik: float(120), Diastolik: float(80), BeratBadan: float(60.5), TinggiFundus: float(24), DJJ: float(140)},
+		},
+		{
+			name:     "blood pressure without diastolic",
+			layanan:  pasien.LayananKB,
+			data:     map[string]interface{}{"td": "120"},
+			rejected: []string{"tekananDarah"},
+			problems: 1,
+		},
+		{
+			name:     "out of range and not a number",
+			layanan:  pasien.LayananKB,
+			data:     map[string]interface{}{"beratBadan": 60500, "suhu": "panas", "nadi": 80},
+			want:     Vital{Nadi: float(80)},
+			rejected: []string{"beratBadan", "suhu"},
+			problems: 2,
+		},
+		{
+			name:     "whole number",
+			layanan:  pasien.LayananKB,
+			data:     map[string]interface{}{"nadi": 80.5},
+			rejected: []string{"nadi"},
+			problems: 1,
+		},
+		{
+			name:     "pregnancy measurement outside a pregnancy visit",
+			layanan:  pasien.LayananImunisasi,
+			data:     map[string]interface{}{"djj": 140},
+			rejected: []string{"djj"},
+			problems: 1,
+		},
+		{
+			name:     "systolic not above diastolic",
+			layanan:  pasien.LayananKB,
+			data:     map[string]interface{}{"sistolik": 80, "diastolik": 90},
+			want:     Vital{Sistolik: float(80), Diastolik: float(90)},
+			problems: 1,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			vital, rejected, problems := parseVital(newSource(c.data), c.layanan)
+			if !reflect.DeepEqual(vital, c.want) {
+				t.Errorf("vital = %+v, want %+v", vital, c.want)
+			}
+			if len(rejected) != len(c.rejected) {
+				t.Errorf("rejected = %v, want %v", rejected, c.rejected)
+			}
+			for _, key := range c.rejected {
+				if _, ok := rejected[key]; !ok {
+					t.Errorf("%s not rejected: %v", key, rejected)
+				}
+			}
+			if len(problems) != c.problems {
+				t.Errorf("problems = %q, want %d", problems, c.problems)
+			}
+		})
+	}
+}
+
+func TestParseKehamilan(t *testing.T) {
+	record, problems := parse(pasien.LayananKehamilan, map[string]interface{}{
+		"id_pasien": float64(12),
+		"tglDatang": "2024-03-05",
+		"soapAnc": bson.M{
+			"subjektif":   " mual ",
+			"o":           "baik",
+			"analisa":     "G1P0A0",
+			"planning":    "kontrol 4 minggu",
+			"bb":          "55",
+			"keluhanLain": "pusing",
+			"suhu":        "99",
+		},
+		"vital": map[string]interface{}{"sistolik": 110, "diastolik": 70},
+	})
+	if len(problems) != 1 || !strings.Contains(problems[0], "suhu") {
+		t.Errorf("problems = %q, want the suhu out of range", problems)
+	}
+	if record.IDPasien != 12 || record.TglDatang.Format("2006-01-02") != "2024-03-05" {
+		t.Errorf("id_pasien %v, tglDatang %v", record.IDPasien, record.TglDatang)
+	}
+	if record.S != "mual" || record.O != "baik" || record.A != "G1P0A0" || record.P != "kontrol 4 minggu" {
+		t.Errorf("S/O/A/P = %q %q %q %q", record.S, record.O, record.A, record.P)
+	}
+	want := Vital{Sistolik: float(110), Diastolik: float(70), BeratBadan: float(55)}
+	if !reflect.DeepEqual(record.Vital, want) {
+		t.Errorf("vital = %+v, want %+v", record.Vital, want)
+	}
+	if !reflect.DeepEqual(record.Lainnya, map[string]interface{}{"keluhanLain": "pusing", "suhu": "99"}) {
+		t.Errorf("lainnya = %v", record.Lainnya)
+	}
+}
+
+func TestParseMissing(t *testing.T) {
+	record, problems := parse(pasien.LayananKB, map[string]interface{}{"id_pasien": "abc", "s": "kontrol"})
+	if len(problems) != 2 {
+		t.Errorf("problems = %q, want id_pasien and tglDatang", problems)
+	}
+	if record.S != "kontrol" || record.Lainnya != nil {
+		t.Errorf("record = %+v", record)
+	}
+}
+
+func TestParseKeepsStoredLainnya(t *testing.T) {
+	record, _ := parse(pasien.LayananImunisasi, map[string]interface{}{
+		"id_pasien": 3,
+		"tanggal":   "2024-01-02",
+		"lainnya":   bson.M{"vaksin": "BCG"},
+		"lokasi":    "lengan kanan",
+		"catatan":   "",
+	})
+	want := map[string]interface{}{"vaksin": "BCG", "lokasi": "lengan kanan"}
+	if !reflect.DeepEqual(record.Lainnya, want) {
+		t.Errorf("lainnya = %v, want %v", record.Lainnya, want)
+	}
+}
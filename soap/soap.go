@@ -6,13 +6,27 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Soap creates a SOAP record from a body of {"id_layanan": 0, "data": {...}}.
+// The record is stamped with the bidan it was written by, so the request
+// needs the token from bidanlogin in Authorization: Bearer <token> and is
+// refused with 401 without one.
 func Soap(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	bidan, err := sesi.FromRequest(r)
+	if err != nil {
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+		w.WriteHeader(Status(err))
+		w.Write(jsonData)
+		return
+	}
+
 	// Connect to MongoDB
 	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
 	if err != nil {
@@ -42,14 +56,15 @@ func Soap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var collection *mongo.Collection
+	// The frontend numbers the layanan from 1 here.
+	var layanan int
 	switch idLayananInt {
 	case 1:
-		collection = client.Database("mydb").Collection("soap_kb")
+		layanan = pasien.LayananKB
 	case 2:
-		collection = client.Database("mydb").Collection("soap_kehamilan")
+		layanan = pasien.LayananKehamilan
 	case 3:
-		collection = client.Database("mydb").Collection("soap_imunisasi")
+		layanan = pasien.LayananImunisasi
 	default:
 		jsonData, _ := json.Marshal(map[string]interface{}{"message": "Service under construction"})
 		w.WriteHeader(http.StatusBadRequest)
@@ -57,15 +72,23 @@ func Soap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Insert data to MongoDB
-	if _, err := collection.InsertOne(context.Background(), dataMap["data"]); err != nil {
-		jsonData, _ := json.Marshal(map[string]interface{}{"message": "Error inserting data to database", "error": err.Error()})
-		w.WriteHeader(http.StatusInternalServerError)
+	data, ok := dataMap["data"].(map[string]interface{})
+	if !ok {
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": "Invalid data"})
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(jsonData)
+		return
+	}
+
+	record, err := Create(context.Background(), client.Database("mydb"), layanan, data, bidan)
+	if err != nil {
+		jsonData, _ := json.Marshal(map[string]interface{}{"message": err.Error()})
+		w.WriteHeader(Status(err))
 		w.Write(jsonData)
 		return
 	}
 
-	jsonData, _ := json.Marshal(map[string]interface{}{"message": "Success"})
+	jsonData, _ := json.Marshal(map[string]interface{}{"message": "Success", "id_soap": record.ID})
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonData)
 }
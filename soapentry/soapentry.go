@@ -0,0 +1,144 @@
+package soapentry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithRecord(w http.ResponseWriter, record soap.Record) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", pasien.ETag(record.Version))
+	jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": record})
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// SoapEntry serves one SOAP record by ?id_soap=, in whichever layanan it
+// is. GET returns it with its ETag. PUT corrects it from a body of
+// {"data": {...}, "alasan": "..."} and keeps the old content as an
+// amendment. DELETE moves it to the archive, with ?alasan=. POST with
+// ?aksi=tandatangan signs and locks it; POST with ?aksi=adendum and a body
+// of {"isi": "..."} adds an addendum to a signed record. Every change needs
+// the bidan's token, and PUT, DELETE and signing need the ETag in If-Match.
+func SoapEntry(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id_soap"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id_soap")
+		return
+	}
+	aksi := r.URL.Query().Get("aksi")
+
+	var bidan sesi.Bidan
+	if r.Method != http.MethodGet {
+		bidan, err = sesi.FromRequest(r)
+		if err != nil {
+			respondWithError(w, soap.Status(err), err.Error())
+			return
+		}
+	}
+
+	var version int64
+	if r.Method == http.MethodPut || r.Method == http.MethodDelete || aksi == "tandatangan" {
+		version, err = pasien.IfMatch(r)
+		if err != nil {
+			respondWithError(w, soap.Status(err), err.Error())
+			return
+		}
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	switch r.Method {
+	case http.MethodGet:
+		record, err := soap.Find(r.Context(), db, id)
+		if err != nil {
+			respondWithError(w, soap.Status(err), err.Error())
+			return
+		}
+		respondWithRecord(w, record)
+
+	case http.MethodPut:
+		var body struct {
+			Data   map[string]interface{} `json:"data"`
+			Alasan string                 `json:"alasan"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Data == nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		record, err := soap.Update(r.Context(), db, id, version, body.Data, body.Alasan, bidan)
+		if err != nil {
+			respondWithError(w, soap.Status(err), err.Error())
+			return
+		}
+		respondWithRecord(w, record)
+
+	case http.MethodDelete:
+		if err := soap.Delete(r.Context(), db, id, version, r.URL.Query().Get("alasan"), bidan); err != nil {
+			respondWithError(w, soap.Status(err), err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		jsonData, _ := json.Marshal(map[string]string{"message": "Delete successful"})
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+
+	case http.MethodPost:
+		var record soap.Record
+		switch aksi {
+		case "tandatangan":
+			record, err = soap.Sign(r.Context(), db, id, version, bidan)
+		case "adendum":
+			var body struct {
+				Isi string `json:"isi"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+			record, err = soap.AddAdendum(r.Context(), db, id, body.Isi, bidan)
+		default:
+			respondWithError(w, http.StatusBadRequest, "aksi harus tandatangan atau adendum")
+			return
+		}
+		if err != nil {
+			respondWithError(w, soap.Status(err), err.Error())
+			return
+		}
+		respondWithRecord(w, record)
+
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -5,15 +5,29 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
-	"strconv"
 
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"github.com/Kazengan/bidan-backend/soap"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// SoapImunisasi creates an Imunisasi SOAP record from a body of {"data": {...}}.
+// The record is stamped with the bidan it was written by, so the request
+// needs the token from bidanlogin in Authorization: Bearer <token> and is
+// refused with 401 without one.
 func SoapImunisasi(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	bidan, err := sesi.FromRequest(r)
+	if err != nil {
+		jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+		w.WriteHeader(soap.Status(err))
+		w.Write(jsonData)
+		return
+	}
+
 	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
 	if err != nil {
 		jsonData, _ := json.Marshal(map[string]string{"message": "error connecting to database"})
@@ -29,7 +43,6 @@ func SoapImunisasi(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 	db := client.Database("mydb")
-	collection := db.Collection("soap_imunisasi")
 
 	decoder := json.NewDecoder(r.Body)
 	var dataMap map[string]interface{}
@@ -41,32 +54,22 @@ func SoapImunisasi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-    data, ok := dataMap["data"].(map[string]interface{})
-    if !ok {
-        jsonData, _ := json.Marshal(map[string]string{"message": "Missing 'data' field in request body"})
-        w.WriteHeader(http.StatusBadRequest)
-        w.Write(jsonData)
-        return
-    }
-	
-	idPasien, _ := data["id_pasien"].(string)
-	data["id_pasien"], err = strconv.Atoi(idPasien)
-	if err != nil {
-		jsonData, _ := json.Marshal(map[string]string{"message": "Invalid id_pasien"})
+	data, ok := dataMap["data"].(map[string]interface{})
+	if !ok {
+		jsonData, _ := json.Marshal(map[string]string{"message": "Missing 'data' field in request body"})
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write(jsonData)
 		return
 	}
 
-	//insert data to database
-	_, err = collection.InsertOne(context.Background(), data)
+	record, err := soap.Create(context.Background(), db, pasien.LayananImunisasi, data, bidan)
 	if err != nil {
-		jsonData, _ := json.Marshal(map[string]string{"message": "Error inserting data to database"})
-		w.WriteHeader(http.StatusInternalServerError)
+		jsonData, _ := json.Marshal(map[string]string{"message": err.Error()})
+		w.WriteHeader(soap.Status(err))
 		w.Write(jsonData)
 		return
 	}
-	jsonData, _ := json.Marshal(map[string]string{"message": "Success"})
+	jsonData, _ := json.Marshal(map[string]interface{}{"message": "Success", "id_soap": record.ID})
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonData)
 }
@@ -10,7 +10,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/peringatan"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -33,29 +36,31 @@ func respondWithError(w http.ResponseWriter, status int, message string) {
 
 func processHistoryData(pasienHistory []bson.M) {
 	for _, data := range pasienHistory {
-		tglDatang := data["tglDatang"].(string)
-		tglDatang = tglDatang[:10]
-		// convert tglDatang to dd-mm-yyyy
-		tglDatang = tglDatang[8:] + "-" + tglDatang[5:7] + "-" + tglDatang[:4]
-		data["datetime"] = data["tglDatang"]
-		data["tglDatang"] = tglDatang
+		data["datetime"] = pasien.FormatDateTime(data["tglDatang"])
+		data["tglDatang"] = pasien.FormatTanggal(data["tglDatang"])
 	}
 }
 
-func convertToIndonesianDate(dateStr string) (string, error) {
-	tanggalDatetime, err := time.Parse("02-01-2006", dateStr)
-	if err != nil {
-		return "", err
+// attachPeringatan puts on every visit the open alerts raised by it and
+// returns all the open alerts of the patient, including those from lab
+// results.
+func attachPeringatan(pasienHistory []bson.M, open []peringatan.Peringatan) []peringatan.Peringatan {
+	bySoap := map[primitive.ObjectID][]peringatan.Peringatan{}
+	for _, p := range open {
+		bySoap[p.IDSumber] = append(bySoap[p.IDSumber], p)
 	}
-
-	namaHariID := []string{"Minggu", "Senin", "Selasa", "Rabu", "Kamis", "Jumat", "Sabtu"}
-	namaBulanID := []string{"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"}
-
-	hari := namaHariID[tanggalDatetime.Weekday()]
-	bulan := namaBulanID[tanggalDatetime.Month()-1]
-	tahun := tanggalDatetime.Year()
-
-	return fmt.Sprintf("%s, %d %s %d", hari, tanggalDatetime.Day(), bulan, tahun), nil
+	for _, data := range pasienHistory {
+		id, _ := data["_id"].(primitive.ObjectID)
+		alerts := bySoap[id]
+		if alerts == nil {
+			alerts = []peringatan.Peringatan{}
+		}
+		data["peringatan"] = alerts
+	}
+	if open == nil {
+		open = []peringatan.Peringatan{}
+	}
+	return open
 }
 
 func getPatientData(client *mongo.Client, idPasienArr []string, idLayananInt int) ([]bson.M, error) {
@@ -76,9 +81,9 @@ func getPatientData(client *mongo.Client, idPasienArr []string, idLayananInt int
 
 	var returnData []bson.M
 	for _, id := range idPasienArr {
-		idInt, err := strconv.ParseInt(id, 10, 64)
+		idInt, err := pasien.ParseID(id)
 		if err != nil {
-			return nil, fmt.Errorf("error converting id_pasien to int64: %v", err)
+			return nil, fmt.Errorf("invalid id_pasien: %v", err)
 		}
 
 		pasienFilter := bson.M{"id_pasien": idInt}
@@ -91,6 +96,9 @@ func getPatientData(client *mongo.Client, idPasienArr []string, idLayananInt int
 		if err := pasienInfo.Decode(&pasienData); err != nil {
 			return nil, fmt.Errorf("error decoding pasien_info: %v", err)
 		}
+		if err := pasien.Decrypt(pasienData); err != nil {
+			return nil, fmt.Errorf("error decrypting pasien_info: %v", err)
+		}
 
 		pasienHistory, err := soapCollection.Find(context.Background(), pasienFilter)
 		if err != nil {
@@ -102,6 +110,12 @@ func getPatientData(client *mongo.Client, idPasienArr []string, idLayananInt int
 			return nil, fmt.Errorf("error decoding pasien_history: %v", err)
 		}
 
+		open, err := peringatan.Open(context.Background(), db, []pasien.ID{idInt})
+		if err != nil {
+			return nil, fmt.Errorf("error finding peringatan: %v", err)
+		}
+		alerts := attachPeringatan(pasienHistoryArr, open[idInt])
+
 		// Ensure subRows is an empty array if pasienHistoryArr is empty
 		subRows := make([]bson.M, len(pasienHistoryArr))
 		copy(subRows, pasienHistoryArr)
@@ -109,20 +123,20 @@ func getPatientData(client *mongo.Client, idPasienArr []string, idLayananInt int
 		if len(pasienHistoryArr) > 0 {
 			processHistoryData(pasienHistoryArr)
 
-			lastDatang := pasienHistoryArr[len(pasienHistoryArr)-1]["tglDatang"].(string)
-			tanggalIndonesia, err := convertToIndonesianDate(lastDatang)
-			if err != nil {
-				return nil, fmt.Errorf("error converting date: %v", err)
+			tanggalIndonesia := ""
+			if lastDatang, ok := pasien.AsTime(pasienHistoryArr[len(pasienHistoryArr)-1]["datetime"]); ok {
+				tanggalIndonesia = pasien.TanggalIndonesia(lastDatang)
 			}
 
 			data := bson.M{
-				"id_pasien": idInt,
-				"usia":      pasienData["umur"],
-				"name":      pasienData["nama_pasien"],
-				"datetime":  pasienHistoryArr[len(pasienHistoryArr)-1]["datetime"].(string),
-				"tglDatang": tanggalIndonesia,
-				"subRows":   subRows,
-				"noHP":      pasienData["no_hp"],
+				"id_pasien":  idInt,
+				"usia":       pasien.Umur(pasienData, time.Now()),
+				"name":       pasienData["nama_pasien"],
+				"datetime":   pasienHistoryArr[len(pasienHistoryArr)-1]["datetime"],
+				"tglDatang":  tanggalIndonesia,
+				"subRows":    subRows,
+				"noHP":       pasienData["no_hp"],
+				"peringatan": alerts,
 			}
 
 			if idLayananInt == 0 {
@@ -139,16 +153,16 @@ func getPatientData(client *mongo.Client, idPasienArr []string, idLayananInt int
 				data["namaIbu"] = pasienData["nama_ibu"]
 			}
 
-
 			returnData = append(returnData, data)
 
 		} else {
 			data := bson.M{
-				"id_pasien": idInt,
-				"name":      pasienData["nama_pasien"],
-				"usia":      pasienData["umur"],
-				"tglDatang": "",
-				"subRows":   subRows,
+				"id_pasien":  idInt,
+				"name":       pasienData["nama_pasien"],
+				"usia":       pasien.Umur(pasienData, time.Now()),
+				"tglDatang":  "",
+				"subRows":    subRows,
+				"peringatan": alerts,
 			}
 
 			if idLayananInt == 0 {
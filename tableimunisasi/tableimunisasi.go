@@ -3,21 +3,19 @@ package tableimunisasi
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
-	"reflect"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func TableImunisasi (w http.ResponseWriter, r *http.Request) {
+func TableImunisasi(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	err := godotenv.Load()
 	if err != nil {
@@ -58,13 +56,12 @@ func TableImunisasi (w http.ResponseWriter, r *http.Request) {
 	id_pasien_str = id_pasien_str[1 : len(id_pasien_str)-1]
 	//split id_pasien_str menjadi array dengan delimiter ","
 	id_pasien_arr := strings.Split(id_pasien_str, ",")
-	//convert id_pasien_arr menjadi array of int64
 
 	var returnData []bson.M
 	for _, id := range id_pasien_arr {
-		id_int, err := strconv.ParseInt(id, 10, 64)
+		id_int, err := pasien.ParseID(id)
 		if err != nil {
-			jsonData, _ := json.Marshal(map[string]string{"message": "error converting id_pasien to int64"})
+			jsonData, _ := json.Marshal(map[string]string{"message": "invalid id_pasien"})
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(jsonData)
 			return
@@ -101,44 +98,36 @@ func TableImunisasi (w http.ResponseWriter, r *http.Request) {
 			w.Write(jsonData)
 			return
 		}
-
-		for _, data := range pasien_history_arr {
-			tglDatang := data["tglDatang"].(string)
-			tglDatang = tglDatang[:10]
-			// convert tglDatang to dd-mm-yyyy
-			tglDatang = tglDatang[8:] + "-" + tglDatang[5:7] + "-" + tglDatang[:4]
-			data["tglDatang"] = tglDatang
-		}
-
-		last_datang := pasien_history_arr[len(pasien_history_arr)-1]["tglDatang"].(string)
-
-		tanggalDatetime, err := time.Parse("02-01-2006", last_datang)
-		if err != nil {
-			jsonData, _ := json.Marshal(map[string]interface{}{"message": "error convert date", "last_datang_value": last_datang, "last_datang_type": fmt.Sprintf("%v", reflect.TypeOf(last_datang))})
+		if err := pasien.Decrypt(pasienData); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": "error decrypting pasien_info"})
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(jsonData)
 			return
 		}
 
-		nama_hari_id := []string{"Minggu", "Senin", "Selasa", "Rabu", "Kamis", "Jumat", "Sabtu"}
-		nama_bulan_id := []string{"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"}
-
-		hari := nama_hari_id[tanggalDatetime.Weekday()]
-		bulan := nama_bulan_id[tanggalDatetime.Month()-1]
-		tahun := tanggalDatetime.Year()
+		tanggal_indonesia := ""
+		if len(pasien_history_arr) > 0 {
+			if last_datang, ok := pasien.AsTime(pasien_history_arr[len(pasien_history_arr)-1]["tglDatang"]); ok {
+				tanggal_indonesia = pasien.TanggalIndonesia(last_datang)
+			}
+		}
 
-		tanggal_indonesia := hari + ", " + strconv.Itoa(tanggalDatetime.Day()) + " " + bulan + " " + strconv.Itoa(tahun)
+		for _, data := range pasien_history_arr {
+			// convert tglDatang to dd-mm-yyyy
+			data["tglDatang"] = pasien.FormatTanggal(data["tglDatang"])
+		}
 
 		returnData = append(returnData, bson.M{
-			"id_pasien":         id_int,
-			"name":              pasienData["nama_pasien"],
-			"namaAyah":          pasienData["nama_ayah"],
-			"namaIbu":			 pasienData["nama_ibu"],
-			"tglDatang":         tanggal_indonesia,
-			"subRows":           pasien_history_arr,
+			"id_pasien": id_int,
+			"name":      pasienData["nama_pasien"],
+			"namaAyah":  pasienData["nama_ayah"],
+			"namaIbu":   pasienData["nama_ibu"],
+			"usia":      pasien.Umur(pasienData, time.Now()),
+			"tglDatang": tanggal_indonesia,
+			"subRows":   pasien_history_arr,
 		})
 	}
 	jsonData, _ := json.Marshal(map[string]interface{}{"message": "success", "data": returnData})
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonData)
-}
\ No newline at end of file
+}
@@ -3,14 +3,13 @@ package tablekehamilan
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
-	"reflect"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/soap"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -58,13 +57,12 @@ func TableKehamilan(w http.ResponseWriter, r *http.Request) {
 	id_pasien_str = id_pasien_str[1 : len(id_pasien_str)-1]
 	//split id_pasien_str menjadi array dengan delimiter ","
 	id_pasien_arr := strings.Split(id_pasien_str, ",")
-	//convert id_pasien_arr menjadi array of int64
 
 	var returnData []bson.M
 	for _, id := range id_pasien_arr {
-		id_int, err := strconv.ParseInt(id, 10, 64)
+		id_int, err := pasien.ParseID(id)
 		if err != nil {
-			jsonData, _ := json.Marshal(map[string]string{"message": "error converting id_pasien to int64"})
+			jsonData, _ := json.Marshal(map[string]string{"message": "invalid id_pasien"})
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(jsonData)
 			return
@@ -78,7 +76,8 @@ func TableKehamilan(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		pasien_history, err := soap_collection.Find(context.Background(), pasien_filter)
+		history_opts := options.Find().SetSort(bson.M{"tglDatang": 1})
+		pasien_history, err := soap_collection.Find(context.Background(), pasien_filter, history_opts)
 		if err != nil {
 			jsonData, _ := json.Marshal(map[string]string{"message": "error finding pasien"})
 			w.WriteHeader(404)
@@ -101,49 +100,60 @@ func TableKehamilan(w http.ResponseWriter, r *http.Request) {
 			w.Write(jsonData)
 			return
 		}
-
-		for _, data := range pasien_history_arr {
-			tglDatang, ok := data["tglDatang"].(string)
-			if !ok {
-				jsonData, _ := json.Marshal(map[string]string{"message": "error finding date in pasien history"})
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write(jsonData)
-				return
-			}
-			tglDatang = tglDatang[:10]
-			tglDatang = tglDatang[8:] + "-" + tglDatang[5:7] + "-" + tglDatang[:4]
-			data["tglDatang"] = tglDatang
-			data["s"] = "nilai S"
-			data["o"] = "nilai O"
-			data["a"] = "nilai A"
-			data["p"] = "nilai P"
-		}
-
-		last_datang := pasien_history_arr[len(pasien_history_arr)-1]["tglDatang"].(string)
-
-		tanggalDatetime, err := time.Parse("02-01-2006", last_datang)
-		if err != nil {
-			jsonData, _ := json.Marshal(map[string]interface{}{"message": "error convert date", "last_datang_value": last_datang, "last_datang_type": fmt.Sprintf("%v", reflect.TypeOf(last_datang))})
+		if err := pasien.Decrypt(pasienData); err != nil {
+			jsonData, _ := json.Marshal(map[string]string{"message": "error decrypting pasien_info"})
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write(jsonData)
 			return
 		}
+		hpht, ada_hpht := pasien.HPHT(pasienData)
 
-		nama_hari_id := []string{"Minggu", "Senin", "Selasa", "Rabu", "Kamis", "Jumat", "Sabtu"}
-		nama_bulan_id := []string{"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"}
+		tanggal_indonesia := ""
+		if len(pasien_history_arr) > 0 {
+			if last_datang, ok := pasien.AsTime(pasien_history_arr[len(pasien_history_arr)-1]["tglDatang"]); ok {
+				tanggal_indonesia = pasien.TanggalIndonesia(last_datang)
+			}
+		}
 
-		hari := nama_hari_id[tanggalDatetime.Weekday()]
-		bulan := nama_bulan_id[tanggalDatetime.Month()-1]
-		tahun := tanggalDatetime.Year()
+		// Visits are labelled K1-K6 by the trimester they fall in, so the
+		// gestational age needs an HPHT: without one, and for visits of an
+		// earlier pregnancy, there is no label.
+		kunjungan := map[int]int{}
+		for _, data := range pasien_history_arr {
+			record, _ := soap.Convert(pasien.LayananKehamilan, data)
+			data["s"] = record.S
+			data["o"] = record.O
+			data["a"] = record.A
+			data["p"] = record.P
+			data["vital"] = record.Vital
+			data["diagnosis"] = record.Diagnosis
+			data["resep"] = record.Resep
+
+			if minggu, hari, ok := pasien.UsiaKehamilan(hpht, record.TglDatang); ada_hpht && ok {
+				trimester := pasien.Trimester(minggu)
+				kunjungan[trimester]++
+				data["kunjungan"] = pasien.LabelKunjungan(trimester, kunjungan[trimester])
+				data["usiaKehamilan"] = pasien.FormatUsiaKehamilan(minggu, hari)
+				data["usiaKehamilanMinggu"] = minggu
+				data["trimester"] = trimester
+			}
 
-		tanggal_indonesia := hari + ", " + strconv.Itoa(tanggalDatetime.Day()) + " " + bulan + " " + strconv.Itoa(tahun)
+			// convert tglDatang to dd-mm-yyyy
+			data["tglDatang"] = pasien.FormatTanggal(data["tglDatang"])
+		}
+
+		var hpht_str interface{}
+		if ada_hpht {
+			hpht_str = pasien.FormatTanggal(hpht)
+		}
 
 		returnData = append(returnData, bson.M{
 			"id_pasien": id_int,
 			"name":      pasienData["nama_pasien"],
-			"usia":      pasienData["umur"],
+			"usia":      pasien.Umur(pasienData, time.Now()),
 			"namaSuami": pasienData["nama_pasangan"],
 			"tglDatang": tanggal_indonesia,
+			"hpht":      hpht_str,
 			"subRows":   pasien_history_arr,
 		})
 	}
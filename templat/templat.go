@@ -0,0 +1,405 @@
+package templat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Kazengan/bidan-backend/pasien"
+	"github.com/Kazengan/bidan-backend/sesi"
+	"github.com/Kazengan/bidan-backend/soap"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the SOAP templates.
+const Collection = "templat_soap"
+
+var (
+	ErrNotFound  = errors.New("templat tidak ditemukan")
+	ErrForbidden = errors.New("templat ini tidak boleh diubah oleh akun ini")
+)
+
+// Templat is a reusable text for the S, O, A and P of a visit. A template
+// without a Pemilik belongs to the layanan's library, managed by the
+// superadmins; otherwise it is the personal template of that bidan.
+type Templat struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id_templat"`
+	IDLayanan  int                `bson:"id_layanan" json:"id_layanan"`
+	Nama       string             `bson:"nama" json:"nama"`
+	S          string             `bson:"s" json:"s"`
+	O          string             `bson:"o" json:"o"`
+	A          string             `bson:"a" json:"a"`
+	P          string             `bson:"p" json:"p"`
+	Pemilik    *sesi.Bidan        `bson:"pemilik,omitempty" json:"pemilik,omitempty"`
+	DibuatPada time.Time          `bson:"dibuat_pada" json:"dibuat_pada"`
+	DiubahPada *time.Time         `bson:"diubah_pada,omitempty" json:"diubah_pada,omitempty"`
+}
+
+// Bersama reports whether t is in the shared library.
+func (t Templat) Bersama() bool {
+	return t.Pemilik == nil
+}
+
+// bolehUbah reports whether b may change or delete t: superadmins manage
+// the library, every bidan their own templates.
+func (t Templat) bolehUbah(b sesi.Bidan) bool {
+	if t.Bersama() {
+		return b.IsSuperadmin()
+	}
+	return t.Pemilik.ID == b.ID
+}
+
+// Placeholders lists the {{...}} fields a template can use, with what they
+// are filled with.
+var Placeholders = map[string]string{
+	"nama":           "nama pasien",
+	"usia":           "usia pasien dalam tahun",
+	"usia_kehamilan": "usia kehamilan hari ini dari HPHT, mis. 12 minggu 3 hari",
+	"trimester":      "trimester kehamilan hari ini",
+	"metode_kb":      "metode KB terakhir",
+	"tanggal":        "tanggal hari ini, dd-mm-yyyy",
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*\}\}`)
+
+// Terisi is a template with its placeholders filled in for one patient.
+// BelumTerisi names the placeholders the patient record has no value for;
+// they are left in the text for the bidan to complete.
+type Terisi struct {
+	Templat
+	BelumTerisi []string `json:"belum_terisi,omitempty"`
+}
+
+// Fill replaces the placeholders of t with values.
+func Fill(t Templat, values map[string]string) Terisi {
+	hasil := Terisi{Templat: t}
+	kosong := map[string]bool{}
+	fill := func(text string) string {
+		return placeholder.ReplaceAllStringFunc(text, func(m string) string {
+			key := placeholder.FindStringSubmatch(m)[1]
+			if v, ok := values[key]; ok && v != "" {
+				return v
+			}
+			if _, known := Placeholders[key]; known && !kosong[key] {
+				kosong[key] = true
+				hasil.BelumTerisi = append(hasil.BelumTerisi, key)
+			}
+			return m
+		})
+	}
+	hasil.S, hasil.O, hasil.A, hasil.P = fill(t.S), fill(t.O), fill(t.A), fill(t.P)
+	return hasil
+}
+
+// Values returns the placeholder values for a patient on day now.
+func Values(ctx context.Context, db *mongo.Database, idPasien pasien.ID, now time.Time) (map[string]string, error) {
+	var doc bson.M
+	err := db.Collection("pasien").FindOne(ctx, bson.M{"id_pasien": idPasien}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := pasien.Decrypt(doc); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{"tanggal": pasien.FormatTanggal(now)}
+	if nama, ok := doc["nama_pasien"].(string); ok {
+		values["nama"] = nama
+	}
+	if umur := pasien.Umur(doc, now); umur != nil && umur != "" {
+		values["usia"] = fmt.Sprint(umur)
+	}
+	if hpht, ok := pasien.HPHT(doc); ok {
+		if minggu, hari, ok := pasien.UsiaKehamilan(hpht, now); ok {
+			values["usia_kehamilan"] = pasien.FormatUsiaKehamilan(minggu, hari)
+			values["trimester"] = fmt.Sprint(pasien.Trimester(minggu))
+		}
+	}
+
+	metode, err := metodeKB(ctx, db, idPasien, doc)
+	if err != nil {
+		return nil, err
+	}
+	values["metode_kb"] = metode
+	return values, nil
+}
+
+// metodeKB is the method of the latest KB visit recording one, or the
+// last method given on registration when there is none.
+func metodeKB(ctx context.Context, db *mongo.Database, idPasien pasien.ID, doc bson.M) (string, error) {
+	var last bson.M
+	opts := options.FindOne().SetSort(bson.D{{Key: "tglDatang", Value: -1}})
+	err := db.Collection(soap.Collections[pasien.LayananKB]).FindOne(ctx, bson.M{"id_pasien": idPasien, "tindakan": bson.M{"$nin": bson.A{nil, ""}}}, opts).Decode(&last)
+	if err == nil {
+		tindakan, _ := last["tindakan"].(string)
+		return tindakan, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	metode, _ := pasien.Lookup(doc, "data_kb.informasi_lainnya.caraKBTerakhir")
+	s, _ := metode.(string)
+	return s, nil
+}
+
+// List returns the library of layanan and the personal templates of b,
+// library first, each sorted by name.
+func List(ctx context.Context, db *mongo.Database, layanan int, b sesi.Bidan) ([]Templat, error) {
+	filter := bson.M{
+		"id_layanan": layanan,
+		"$or": bson.A{
+			bson.M{"pemilik": bson.M{"$exists": false}},
+			bson.M{"pemilik.id": b.ID},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "pemilik", Value: 1}, {Key: "nama", Value: 1}})
+	cursor, err := db.Collection(Collection).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	list := []Templat{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// request is the body of a POST or PUT. bersama puts the template in the
+// layanan's library instead of the bidan's own templates.
+type request struct {
+	IDLayanan *int   `json:"id_layanan"`
+	Nama      string `json:"nama"`
+	S         string `json:"s"`
+	O         string `json:"o"`
+	A         string `json:"a"`
+	P         string `json:"p"`
+	Bersama   bool   `json:"bersama"`
+}
+
+func (req request) validate() (Templat, error) {
+	var problems []string
+	if req.IDLayanan == nil {
+		problems = append(problems, "id_layanan needed")
+	} else if _, ok := soap.Collections[*req.IDLayanan]; !ok {
+		problems = append(problems, "id_layanan tidak valid")
+	}
+	if strings.TrimSpace(req.Nama) == "" {
+		problems = append(problems, "nama needed")
+	}
+	if strings.TrimSpace(req.S+req.O+req.A+req.P) == "" {
+		problems = append(problems, "templat harus berisi paling tidak satu dari s, o, a atau p")
+	}
+	for _, text := range []string{req.S, req.O, req.A, req.P} {
+		for _, m := range placeholder.FindAllStringSubmatch(text, -1) {
+			if _, ok := Placeholders[m[1]]; !ok {
+				problems = append(problems, fmt.Sprintf("placeholder {{%s}} tidak dikenal", m[1]))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return Templat{}, soap.InvalidError(problems)
+	}
+	return Templat{IDLayanan: *req.IDLayanan, Nama: strings.TrimSpace(req.Nama), S: req.S, O: req.O, A: req.A, P: req.P}, nil
+}
+
+func status(err error) int {
+	switch {
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	}
+	return soap.Status(err)
+}
+
+func connectToDatabase() (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database")
+	}
+	return client, nil
+}
+
+func respondWithError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	jsonData, _ := json.Marshal(payload)
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// Handler serves /api/templat for the logged-in bidan. GET ?id_layanan=
+// lists the layanan's library and the bidan's own templates; with &id_pasien= the
+// placeholders are filled in from that patient. POST adds a template, PUT
+// ?id_templat= replaces one and DELETE ?id_templat= removes it. Only
+// superadmins may add "bersama": true templates or change the library.
+// id_layanan is 0 for KB, 1 for Kehamilan and 2 for Imunisasi.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	bidan, err := sesi.FromRequest(r)
+	if err != nil {
+		respondWithError(w, status(err), err.Error())
+		return
+	}
+
+	client, err := connectToDatabase()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer client.Disconnect(context.Background())
+	db := client.Database("mydb")
+
+	switch r.Method {
+	case http.MethodGet:
+		list(w, r, db, bidan)
+	case http.MethodPost:
+		save(w, r, db, bidan, primitive.NilObjectID)
+	case http.MethodPut, http.MethodDelete:
+		id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id_templat"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_templat")
+			return
+		}
+		if r.Method == http.MethodPut {
+			save(w, r, db, bidan, id)
+			return
+		}
+		if err := remove(r.Context(), db, id, bidan); err != nil {
+			respondWithError(w, status(err), err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"message": "Delete successful"})
+	default:
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func list(w http.ResponseWriter, r *http.Request, db *mongo.Database, bidan sesi.Bidan) {
+	var layanan int
+	if _, err := fmt.Sscan(r.URL.Query().Get("id_layanan"), &layanan); err != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid id_layanan")
+		return
+	}
+	if _, ok := soap.Collections[layanan]; !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid id_layanan")
+		return
+	}
+	templates, err := List(r.Context(), db, layanan, bidan)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error executing query")
+		return
+	}
+
+	payload := map[string]interface{}{"message": "success", "data": templates, "placeholders": Placeholders}
+	if id := r.URL.Query().Get("id_pasien"); id != "" {
+		idPasien, err := pasien.ParseID(id)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid id_pasien")
+			return
+		}
+		values, err := Values(r.Context(), db, idPasien, time.Now())
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				respondWithError(w, http.StatusNotFound, "id_pasien tidak ditemukan")
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "error finding pasien")
+			return
+		}
+		filled := make([]Terisi, len(templates))
+		for i, t := range templates {
+			filled[i] = Fill(t, values)
+		}
+		payload["data"] = filled
+	}
+	respondWithJSON(w, http.StatusOK, payload)
+}
+
+// save inserts a template when id is nil and replaces template id
+// otherwise. A template keeps its owner when it is replaced.
+func save(w http.ResponseWriter, r *http.Request, db *mongo.Database, bidan sesi.Bidan, id primitive.ObjectID) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	t, err := req.validate()
+	if err != nil {
+		respondWithError(w, status(err), err.Error())
+		return
+	}
+	collection := db.Collection(Collection)
+
+	if id.IsZero() {
+		if req.Bersama && !bidan.IsSuperadmin() {
+			respondWithError(w, http.StatusForbidden, "hanya superadmin yang boleh menambah templat bersama")
+			return
+		}
+		if !req.Bersama {
+			t.Pemilik = &bidan
+		}
+		t.ID = primitive.NewObjectID()
+		t.DibuatPada = time.Now()
+		if _, err := collection.InsertOne(r.Context(), t); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error inserting data to database")
+			return
+		}
+		respondWithJSON(w, http.StatusCreated, map[string]interface{}{"message": "success", "data": t})
+		return
+	}
+
+	current, err := find(r.Context(), db, id, bidan)
+	if err != nil {
+		respondWithError(w, status(err), err.Error())
+		return
+	}
+	now := time.Now()
+	t.ID, t.Pemilik, t.DibuatPada, t.DiubahPada = current.ID, current.Pemilik, current.DibuatPada, &now
+	if _, err := collection.ReplaceOne(r.Context(), bson.M{"_id": id}, t); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating data")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "success", "data": t})
+}
+
+// find returns template id if bidan may change it.
+func find(ctx context.Context, db *mongo.Database, id primitive.ObjectID, bidan sesi.Bidan) (Templat, error) {
+	var t Templat
+	err := db.Collection(Collection).FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return Templat{}, ErrNotFound
+	}
+	if err != nil {
+		return Templat{}, err
+	}
+	if !t.bolehUbah(bidan) {
+		return Templat{}, ErrForbidden
+	}
+	return t, nil
+}
+
+func remove(ctx context.Context, db *mongo.Database, id primitive.ObjectID, bidan sesi.Bidan) error {
+	if _, err := find(ctx, db, id, bidan); err != nil {
+		return err
+	}
+	_, err := db.Collection(Collection).DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}